@@ -125,9 +125,9 @@ func processRepository(name string, repository *hub.Repository) (*catalog.Catalo
 	}
 
 	buildTo := fmt.Sprintf("%s/%s", strings.ToLower(registry), imageName)
-	superGatewayArgs, err := repository.HTTPUpstream.SuperGatewayArgs()
+	superGatewayArgs, err := repository.SuperGatewayArgs()
 	if err != nil {
-		return nil, fmt.Errorf("http upstream super-gateway args: %w", err)
+		return nil, fmt.Errorf("super-gateway args: %w", err)
 	}
 
 	if !skipBuild {
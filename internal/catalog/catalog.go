@@ -196,9 +196,9 @@ func (c *Catalog) Load(name string, hub *hub.Repository, imageName string, smith
 		}
 	}
 
-	superGatewayArgs, err := hub.HTTPUpstream.SuperGatewayArgs()
+	superGatewayArgs, err := hub.SuperGatewayArgs()
 	if err != nil {
-		return fmt.Errorf("http upstream super-gateway args: %w", err)
+		return fmt.Errorf("super-gateway args: %w", err)
 	}
 
 	artifact := Artifact{
@@ -37,7 +37,7 @@ func TestCatalogLoadHTTPUpstream(t *testing.T) {
 	if artifact.Transport != "http-stream" {
 		t.Fatalf("Transport = %q, want http-stream", artifact.Transport)
 	}
-	wantArgs := []string{"--port", "80", "--transport", "http-stream", "--http-upstream", "http://127.0.0.1:8081/mcp", "--http-upstream-path", "/mcp", "--stdio"}
+	wantArgs := []string{"--config-json", `{"port":80,"transport":"http-stream","httpUpstream":"http://127.0.0.1:8081/mcp","httpUpstreamPath":"/mcp"}`, "--stdio"}
 	if len(artifact.Entrypoint.SuperGatewayArgs) != len(wantArgs) {
 		t.Fatalf("len(SuperGatewayArgs) = %d, want %d (%v)", len(artifact.Entrypoint.SuperGatewayArgs), len(wantArgs), artifact.Entrypoint.SuperGatewayArgs)
 	}
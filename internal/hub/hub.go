@@ -1,6 +1,7 @@
 package hub
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
@@ -8,12 +9,17 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/blaxel-ai/mcp-hub/internal/smithery"
 	"gopkg.in/yaml.v2"
 )
 
+var rateLimitPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?(/[1-9][0-9]*)?$`)
+
 type Hub struct {
 	Repositories map[string]*Repository `yaml:"repositories"`
 }
@@ -48,6 +54,7 @@ type Repository struct {
 	HiddenSecrets   []string                 `yaml:"hiddenSecrets" mandatory:"false"`
 	OAuth           *OAuth                   `yaml:"oauth" mandatory:"false"`
 	HTTPUpstream    *HTTPUpstream            `yaml:"httpUpstream" mandatory:"false"`
+	RateLimit       *RateLimit               `yaml:"rateLimit" mandatory:"false"`
 	Integration     string                   `yaml:"integration" mandatory:"false"`
 	Tags            []string                 `yaml:"tags"`
 	Categories      []string                 `yaml:"categories"`
@@ -58,6 +65,18 @@ type HTTPUpstream struct {
 	AllowedPath string `yaml:"allowedPath"`
 }
 
+// RateLimit limits the requests super-gateway forwards to the MCP server.
+// Rates are requests per second written as "rate" or "rate/burst"; overrides
+// are keyed by method or by "tools/call:<tool>".
+type RateLimit struct {
+	Global       string            `yaml:"global"`
+	Session      string            `yaml:"session"`
+	MaxInFlight  int               `yaml:"maxInFlight"`
+	MaxQueue     int               `yaml:"maxQueue"`
+	QueueTimeout string            `yaml:"queueTimeout"`
+	Overrides    map[string]string `yaml:"overrides"`
+}
+
 type OAuth struct {
 	Type   string   `yaml:"type"`
 	Scopes []string `yaml:"scopes"`
@@ -86,20 +105,144 @@ func (h *HTTPUpstream) ValidateWithDefaultValues() error {
 	return nil
 }
 
-func (h *HTTPUpstream) SuperGatewayArgs() ([]string, error) {
+func (h *HTTPUpstream) applyTo(config *SuperGatewayConfig) error {
 	if h == nil {
-		return nil, nil
+		return nil
 	}
 	if err := h.ValidateWithDefaultValues(); err != nil {
+		return err
+	}
+	config.HTTPUpstream = h.URL
+	config.HTTPUpstreamPath = h.AllowedPath
+	return nil
+}
+
+func (r *RateLimit) Validate() error {
+	for name, value := range map[string]string{"global": r.Global, "session": r.Session} {
+		if value != "" && !rateLimitPattern.MatchString(value) {
+			return fmt.Errorf("rateLimit.%s must be rate or rate/burst", name)
+		}
+	}
+	for key, value := range r.Overrides {
+		if key == "" || strings.ContainsAny(key, "=,") || !rateLimitPattern.MatchString(value) {
+			return fmt.Errorf("rateLimit.overrides.%s must be rate or rate/burst", key)
+		}
+	}
+	if r.MaxInFlight < 0 || r.MaxQueue < 0 {
+		return fmt.Errorf("rateLimit.maxInFlight and rateLimit.maxQueue must not be negative")
+	}
+	if r.QueueTimeout != "" {
+		if d, err := time.ParseDuration(r.QueueTimeout); err != nil || d <= 0 {
+			return fmt.Errorf("rateLimit.queueTimeout must be a positive duration")
+		}
+	}
+	return nil
+}
+
+func (r *RateLimit) applyTo(config *SuperGatewayConfig) error {
+	if r == nil {
+		return nil
+	}
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	config.RateLimit = r.Global
+	config.SessionRateLimit = r.Session
+	if len(r.Overrides) > 0 {
+		keys := make([]string, 0, len(r.Overrides))
+		for key := range r.Overrides {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		overrides := make([]string, 0, len(keys))
+		for _, key := range keys {
+			overrides = append(overrides, key+"="+r.Overrides[key])
+		}
+		config.RateLimitOverrides = strings.Join(overrides, ",")
+	}
+	config.MaxInFlight = r.MaxInFlight
+	config.MaxQueue = r.MaxQueue
+	config.QueueTimeout = r.QueueTimeout
+	return nil
+}
+
+// SuperGatewayConfig is the super-gateway configuration of a repository,
+// encoded with the keys of super-gateway's config files
+type SuperGatewayConfig struct {
+	Port               int    `json:"port"`
+	Transport          string `json:"transport"`
+	HTTPUpstream       string `json:"httpUpstream,omitempty"`
+	HTTPUpstreamPath   string `json:"httpUpstreamPath,omitempty"`
+	RateLimit          string `json:"rateLimit,omitempty"`
+	SessionRateLimit   string `json:"sessionRateLimit,omitempty"`
+	RateLimitOverrides string `json:"rateLimitOverrides,omitempty"`
+	MaxInFlight        int    `json:"maxInflight,omitempty"`
+	MaxQueue           int    `json:"maxQueue,omitempty"`
+	QueueTimeout       string `json:"queueTimeout,omitempty"`
+}
+
+func newSuperGatewayConfig() *SuperGatewayConfig {
+	return &SuperGatewayConfig{Port: 80, Transport: "http-stream"}
+}
+
+// Args returns the super-gateway arguments passing the configuration as a
+// single --config-json, ending with the --stdio the MCP server command is
+// appended to
+func (c *SuperGatewayConfig) Args() []string {
+	// A struct of strings and ints always encodes
+	data, _ := json.Marshal(c)
+	return []string{"--config-json", string(data), "--stdio"}
+}
+
+// Validate checks the super-gateway options of the repository
+func (r *Repository) Validate() error {
+	if r.RateLimit != nil {
+		if err := r.RateLimit.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.HTTPUpstream != nil {
+		if err := r.HTTPUpstream.ValidateWithDefaultValues(); err != nil {
+			return err
+		}
+		// Requests proxied to an HTTP upstream bypass super-gateway's limits
+		if r.RateLimit != nil {
+			return fmt.Errorf("rateLimit cannot be combined with httpUpstream")
+		}
+	}
+	return nil
+}
+
+// SuperGatewayConfig returns the super-gateway configuration for the
+// repository, or nil when the image's default configuration applies
+func (r *Repository) SuperGatewayConfig() (*SuperGatewayConfig, error) {
+	if r.HTTPUpstream == nil && r.RateLimit == nil {
+		return nil, nil
+	}
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	config := newSuperGatewayConfig()
+	if err := r.HTTPUpstream.applyTo(config); err != nil {
+		return nil, err
+	}
+	if err := r.RateLimit.applyTo(config); err != nil {
 		return nil, err
 	}
-	return []string{
-		"--port", "80",
-		"--transport", "http-stream",
-		"--http-upstream", h.URL,
-		"--http-upstream-path", h.AllowedPath,
-		"--stdio",
-	}, nil
+	if *config == *newSuperGatewayConfig() {
+		return nil, nil
+	}
+	return config, nil
+}
+
+// SuperGatewayArgs returns the super-gateway arguments for the repository, or
+// nil when the image's default arguments apply
+func (r *Repository) SuperGatewayArgs() ([]string, error) {
+	config, err := r.SuperGatewayConfig()
+	if err != nil || config == nil {
+		return nil, err
+	}
+	return config.Args(), nil
 }
 
 func (h *HTTPUpstream) parsedURL() (*neturl.URL, error) {
@@ -197,13 +340,12 @@ func (h *Hub) ValidateWithDefaultValues() error {
 			}
 		}
 
-		if repository.HTTPUpstream != nil {
-			if err := repository.HTTPUpstream.ValidateWithDefaultValues(); err != nil {
-				errs = append(errs, fmt.Errorf("repository %s: %w", name, err))
-			}
-			if repository.Transport == "" {
-				repository.Transport = "http-stream"
-			}
+		if err := repository.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("repository %s: %w", name, err))
+		}
+
+		if repository.HTTPUpstream != nil && repository.Transport == "" {
+			repository.Transport = "http-stream"
 		}
 	}
 
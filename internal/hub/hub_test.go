@@ -71,23 +71,6 @@ func TestHTTPUpstreamValidateWithDefaultValues(t *testing.T) {
 	})
 }
 
-func TestHTTPUpstreamSuperGatewayArgs(t *testing.T) {
-	upstream := &HTTPUpstream{URL: "http://localhost:8081/mcp"}
-	args, err := upstream.SuperGatewayArgs()
-	if err != nil {
-		t.Fatalf("SuperGatewayArgs returned error: %v", err)
-	}
-	want := []string{"--port", "80", "--transport", "http-stream", "--http-upstream", "http://localhost:8081/mcp", "--http-upstream-path", "/mcp", "--stdio"}
-	if len(args) != len(want) {
-		t.Fatalf("len(args) = %d, want %d (%v)", len(args), len(want), args)
-	}
-	for i := range want {
-		if args[i] != want[i] {
-			t.Fatalf("args[%d] = %q, want %q (all args: %v)", i, args[i], want[i], args)
-		}
-	}
-}
-
 func TestHubValidateHTTPUpstreamDefaultsTransport(t *testing.T) {
 	h := &Hub{Repositories: map[string]*Repository{
 		"dummy": {
@@ -105,3 +88,50 @@ func TestHubValidateHTTPUpstreamDefaultsTransport(t *testing.T) {
 		t.Fatalf("Transport = %q, want http-stream", got)
 	}
 }
+
+func TestRepositorySuperGatewayArgsWithRateLimit(t *testing.T) {
+	repository := &Repository{RateLimit: &RateLimit{
+		Global:      "10/20",
+		MaxInFlight: 4,
+		Overrides:   map[string]string{"tools/call:search": "1", "ping": "0"},
+	}}
+	args, err := repository.SuperGatewayArgs()
+	if err != nil {
+		t.Fatalf("SuperGatewayArgs returned error: %v", err)
+	}
+	want := []string{"--config-json", `{"port":80,"transport":"http-stream","rateLimit":"10/20","rateLimitOverrides":"ping=0,tools/call:search=1","maxInflight":4}`, "--stdio"}
+	if strings.Join(args, " ") != strings.Join(want, " ") {
+		t.Fatalf("args = %v, want %v", args, want)
+	}
+
+	if args, err := (&Repository{RateLimit: &RateLimit{}}).SuperGatewayArgs(); args != nil || err != nil {
+		t.Fatalf("SuperGatewayArgs without options = %v, %v, want default", args, err)
+	}
+}
+
+func TestRepositoryValidateRejectsRateLimitWithHTTPUpstream(t *testing.T) {
+	repository := &Repository{
+		HTTPUpstream: &HTTPUpstream{URL: "http://localhost:8081/mcp"},
+		RateLimit:    &RateLimit{Session: "2"},
+	}
+	if err := repository.Validate(); err == nil || !strings.Contains(err.Error(), "httpUpstream") {
+		t.Fatalf("Validate error = %v, want rateLimit rejected with httpUpstream", err)
+	}
+	if args, err := repository.SuperGatewayArgs(); err == nil {
+		t.Fatalf("SuperGatewayArgs = %v, want an error", args)
+	}
+}
+
+func TestRateLimitValidate(t *testing.T) {
+	for _, limit := range []RateLimit{
+		{Global: "fast"},
+		{Session: "1/0"},
+		{QueueTimeout: "soon"},
+		{MaxQueue: -1},
+		{Overrides: map[string]string{"ping": "x"}},
+	} {
+		if err := limit.Validate(); err == nil {
+			t.Errorf("Validate(%+v) accepted", limit)
+		}
+	}
+}
@@ -0,0 +1,576 @@
+package main
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// protectedResourcePath serves the OAuth protected resource metadata
+// (RFC 9728) that MCP clients discover authorization servers from
+const protectedResourcePath = "/.well-known/oauth-protected-resource"
+
+const (
+	// jwtLeeway tolerates clock skew when checking exp and nbf
+	jwtLeeway = time.Minute
+	// jwksRefreshInterval is how long keys fetched from a JWKS URL are cached
+	jwksRefreshInterval = 5 * time.Minute
+	// jwksMinRefreshInterval limits refetches triggered by unknown key IDs
+	jwksMinRefreshInterval = 30 * time.Second
+	// maxJWKSSize bounds the JWKS document read from a file or URL
+	maxJWKSSize = 1 << 20
+)
+
+var (
+	errMissingToken      = errors.New("missing bearer token")
+	errInvalidToken      = errors.New("invalid token")
+	errInsufficientScope = errors.New("insufficient scope")
+)
+
+// authConfig configures inbound authentication of MCP clients
+type authConfig struct {
+	// Tokens are static bearer tokens or API keys
+	Tokens []string
+	// TokensFile holds more static tokens, one per line
+	TokensFile string
+	// JWKS is the path or URL of the key set JWTs are verified against
+	JWKS     string
+	Issuer   string
+	Audience string
+	// Scopes must all be granted by a JWT
+	Scopes []string
+	// Resource is the canonical URL of this MCP server, advertised in the
+	// protected resource metadata; derived from each request when empty
+	Resource string
+}
+
+// authenticator checks the credentials of requests to the MCP endpoint:
+// static tokens sent as a bearer token or X-API-Key, or JWTs signed by a key
+// from the configured JWKS
+type authenticator struct {
+	tokens   [][]byte
+	jwks     *jwksSource
+	issuer   string
+	audience string
+	scopes   []string
+	resource string
+}
+
+// newAuthenticator returns nil when cfg configures no credentials, which
+// leaves the MCP endpoint open
+func newAuthenticator(cfg authConfig) (*authenticator, error) {
+	a := &authenticator{
+		issuer:   cfg.Issuer,
+		audience: cfg.Audience,
+		scopes:   cfg.Scopes,
+		resource: cfg.Resource,
+	}
+	for _, token := range cfg.Tokens {
+		a.tokens = append(a.tokens, []byte(token))
+	}
+	if cfg.TokensFile != "" {
+		tokens, err := readTokensFile(cfg.TokensFile)
+		if err != nil {
+			return nil, err
+		}
+		a.tokens = append(a.tokens, tokens...)
+	}
+	if cfg.JWKS != "" {
+		a.jwks = newJWKSSource(cfg.JWKS)
+		if _, err := a.jwks.load(); err != nil {
+			return nil, fmt.Errorf("failed to load JWKS: %w", err)
+		}
+	} else if cfg.Issuer != "" || cfg.Audience != "" || len(cfg.Scopes) > 0 {
+		return nil, errors.New("JWT issuer, audience and scopes require a JWKS")
+	}
+
+	if len(a.tokens) == 0 && a.jwks == nil {
+		return nil, nil
+	}
+	return a, nil
+}
+
+// readTokensFile reads static tokens, one per line, skipping blank lines and
+// # comments
+func readTokensFile(path string) ([][]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open tokens file: %w", err)
+	}
+	defer file.Close()
+
+	var tokens [][]byte
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens = append(tokens, []byte(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read tokens file: %w", err)
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("tokens file %s has no tokens", path)
+	}
+	return tokens, nil
+}
+
+// Wrap rejects requests without valid credentials before they reach next.
+// A nil authenticator lets every request through.
+func (a *authenticator) Wrap(next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := a.authenticate(r); err != nil {
+			log.Printf("Rejected %s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+			a.challenge(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate checks the credentials of r
+func (a *authenticator) authenticate(r *http.Request) error {
+	token := r.Header.Get("X-API-Key")
+	if token == "" {
+		scheme, value, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			token = strings.TrimSpace(value)
+		}
+	}
+	if token == "" {
+		return errMissingToken
+	}
+
+	// Compare against every static token so timing reveals nothing
+	matched := 0
+	for _, candidate := range a.tokens {
+		matched |= subtle.ConstantTimeCompare(candidate, []byte(token))
+	}
+	if matched == 1 {
+		return nil
+	}
+
+	if a.jwks == nil || strings.Count(token, ".") != 2 {
+		return errInvalidToken
+	}
+	claims, err := a.verifyJWT(token, time.Now())
+	if err != nil {
+		return err
+	}
+	return a.checkClaims(claims, time.Now())
+}
+
+// challenge answers a rejected request the way the MCP authorization spec
+// expects: 401 with a WWW-Authenticate header pointing at the protected
+// resource metadata, or 403 when the token lacks a required scope
+func (a *authenticator) challenge(w http.ResponseWriter, r *http.Request, err error) {
+	params := []string{fmt.Sprintf("resource_metadata=%q", requestBaseURL(r)+protectedResourcePath)}
+	status := http.StatusUnauthorized
+	switch {
+	case errors.Is(err, errInsufficientScope):
+		status = http.StatusForbidden
+		params = append(params, `error="insufficient_scope"`, fmt.Sprintf("scope=%q", strings.Join(a.scopes, " ")))
+	case !errors.Is(err, errMissingToken):
+		params = append(params, `error="invalid_token"`)
+	}
+	w.Header().Set("WWW-Authenticate", "Bearer "+strings.Join(params, ", "))
+	http.Error(w, http.StatusText(status), status)
+}
+
+// HandleProtectedResourceMetadata serves the OAuth protected resource
+// metadata of the MCP endpoint
+func (a *authenticator) HandleProtectedResourceMetadata(mcpPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resource := a.resource
+		if resource == "" {
+			resource = requestBaseURL(r) + mcpPath
+		}
+		metadata := map[string]interface{}{
+			"resource":                 resource,
+			"bearer_methods_supported": []string{"header"},
+		}
+		if a.issuer != "" {
+			metadata["authorization_servers"] = []string{a.issuer}
+		}
+		if len(a.scopes) > 0 {
+			metadata["scopes_supported"] = a.scopes
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(metadata)
+	}
+}
+
+// requestBaseURL returns the scheme and host a client used to reach the
+// gateway, honoring X-Forwarded-Proto set by a TLS-terminating proxy
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
+
+// jwtClaims are the registered claims checked by the gateway
+type jwtClaims struct {
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *float64        `json:"exp"`
+	NotBefore *float64        `json:"nbf"`
+	Scope     string          `json:"scope"`
+	Scp       json.RawMessage `json:"scp"`
+}
+
+// verifyJWT checks the signature of a compact JWS and returns its claims
+func (a *authenticator) verifyJWT(token string, now time.Time) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed header", errInvalidToken)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", errInvalidToken)
+	}
+
+	keys, err := a.jwks.keysFor(header.Kid, now)
+	if err != nil {
+		log.Printf("Failed to refresh JWKS: %v", err)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range keys {
+		if verifySignature(header.Alg, key, signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("%w: bad signature", errInvalidToken)
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", errInvalidToken)
+	}
+	return &claims, nil
+}
+
+// checkClaims validates expiry, issuer, audience and scopes
+func (a *authenticator) checkClaims(claims *jwtClaims, now time.Time) error {
+	if claims.ExpiresAt == nil {
+		return fmt.Errorf("%w: no expiry", errInvalidToken)
+	}
+	if now.After(time.Unix(int64(*claims.ExpiresAt), 0).Add(jwtLeeway)) {
+		return fmt.Errorf("%w: expired", errInvalidToken)
+	}
+	if claims.NotBefore != nil && now.Add(jwtLeeway).Before(time.Unix(int64(*claims.NotBefore), 0)) {
+		return fmt.Errorf("%w: not yet valid", errInvalidToken)
+	}
+	if a.issuer != "" && claims.Issuer != a.issuer {
+		return fmt.Errorf("%w: issuer %q", errInvalidToken, claims.Issuer)
+	}
+	if a.audience != "" && !containsString(stringOrList(claims.Audience), a.audience) {
+		return fmt.Errorf("%w: audience not accepted", errInvalidToken)
+	}
+
+	granted := strings.Fields(claims.Scope)
+	granted = append(granted, stringOrList(claims.Scp)...)
+	for _, scope := range a.scopes {
+		if !containsString(granted, scope) {
+			return fmt.Errorf("%w: missing %s", errInsufficientScope, scope)
+		}
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// stringOrList decodes a claim that is either a string or a list of strings;
+// a space-separated string is split like the scope claim
+func stringOrList(raw json.RawMessage) []string {
+	var list []string
+	if err := json.Unmarshal(raw, &list); err == nil {
+		return list
+	}
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return strings.Fields(single)
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// verifySignature checks a JWS signature made with alg by key
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) bool {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		hash = crypto.SHA512
+	}
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if hash == 0 {
+			return false
+		}
+		digest := hashBytes(hash, signed)
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(pub, hash, digest, signature) == nil
+		case "PS":
+			return rsa.VerifyPSS(pub, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(alg, "ES") || hash == 0 || len(signature) != 2*size || ecdsaHash(pub.Curve) != hash {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(pub, hashBytes(hash, signed), r, s)
+	case ed25519.PublicKey:
+		return (alg == "EdDSA" || alg == "Ed25519") && ed25519.Verify(pub, signed, signature)
+	}
+	return false
+}
+
+func hashBytes(hash crypto.Hash, data []byte) []byte {
+	h := hash.New()
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// ecdsaHash returns the hash ES256, ES384 and ES512 pair with each curve
+func ecdsaHash(curve elliptic.Curve) crypto.Hash {
+	switch curve {
+	case elliptic.P256():
+		return crypto.SHA256
+	case elliptic.P384():
+		return crypto.SHA384
+	case elliptic.P521():
+		return crypto.SHA512
+	}
+	return 0
+}
+
+// jwk is a public key of a JSON Web Key Set
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey decodes an RSA, EC or Ed25519 key
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA exponent too large")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) != size || len(y) != size {
+			return nil, errors.New("EC coordinates have the wrong size")
+		}
+		return ecdsa.ParseUncompressedPublicKey(curve, append(append([]byte{4}, x...), y...))
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("Ed25519 key has the wrong size")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// jwksSource loads a JWKS from a file or URL and keeps it fresh. Keys from a
+// URL are refetched periodically and when a token names an unknown key ID.
+type jwksSource struct {
+	location string
+	client   *http.Client
+
+	mu      sync.Mutex
+	keys    map[string][]crypto.PublicKey
+	fetched time.Time
+}
+
+func newJWKSSource(location string) *jwksSource {
+	return &jwksSource{location: location, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (s *jwksSource) remote() bool {
+	return strings.HasPrefix(s.location, "http://") || strings.HasPrefix(s.location, "https://")
+}
+
+// keysFor returns the keys a token with the given key ID may be signed with;
+// every key when the token names none. Stale keys are served when a refresh
+// fails, along with the error.
+func (s *jwksSource) keysFor(kid string, now time.Time) ([]crypto.PublicKey, error) {
+	s.mu.Lock()
+	keys := s.keys
+	_, known := keys[kid]
+	age := now.Sub(s.fetched)
+	refresh := s.remote() && (age > jwksRefreshInterval || (kid != "" && !known && age > jwksMinRefreshInterval))
+	if refresh {
+		// Claim the refresh so concurrent requests keep using the cached keys
+		s.fetched = now
+	}
+	s.mu.Unlock()
+
+	var err error
+	if refresh {
+		var refreshed map[string][]crypto.PublicKey
+		if refreshed, err = s.load(); err == nil {
+			keys = refreshed
+		}
+	}
+
+	if kid != "" {
+		return keys[kid], err
+	}
+	var all []crypto.PublicKey
+	for _, list := range keys {
+		all = append(all, list...)
+	}
+	return all, err
+}
+
+// load reads the key set and replaces the cached keys. Keys that are not
+// meant for signatures or cannot be decoded are skipped.
+func (s *jwksSource) load() (map[string][]crypto.PublicKey, error) {
+	data, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	keys := make(map[string][]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			log.Printf("Skipping JWKS key %q: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = append(keys[k.Kid], key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS has no usable signing keys")
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.fetched = time.Now()
+	s.mu.Unlock()
+	return keys, nil
+}
+
+func (s *jwksSource) read() ([]byte, error) {
+	if !s.remote() {
+		return os.ReadFile(s.location)
+	}
+	resp, err := s.client.Get(s.location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS endpoint returned %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+}
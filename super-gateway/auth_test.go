@@ -0,0 +1,281 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// signJWT builds a compact JWS of claims signed with key
+func signJWT(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+
+	var signature []byte
+	var err error
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest[:])
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(k, []byte(signed))
+	}
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return signed + "." + b64(signature)
+}
+
+type testKeys struct {
+	rsa     *rsa.PrivateKey
+	ec      *ecdsa.PrivateKey
+	ed25519 ed25519.PrivateKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519: %v", err)
+	}
+	return testKeys{rsa: rsaKey, ec: ecKey, ed25519: edKey}
+}
+
+func (k testKeys) jwks() []byte {
+	ecPoint, _ := k.ec.PublicKey.Bytes()
+	data, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "n": b64(k.rsa.N.Bytes()), "e": b64(big.NewInt(int64(k.rsa.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecPoint[1:33]), "y": b64(ecPoint[33:])},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64(k.ed25519.Public().(ed25519.PublicKey))},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": b64(k.rsa.N.Bytes()), "e": "AQAB"},
+	}})
+	return data
+}
+
+func writeTempFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+	return path
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":   "https://issuer.example",
+		"aud":   []string{"https://gateway.example/mcp"},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scope": "mcp:tools other",
+	}
+}
+
+// serveAuthenticated calls an authenticated handler with the given headers
+func serveAuthenticated(a *authenticator, headers map[string]string) *httptest.ResponseRecorder {
+	handler := a.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	req := httptest.NewRequest(http.MethodPost, "http://gateway.example/mcp", nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestStaticTokens(t *testing.T) {
+	a, err := newAuthenticator(authConfig{
+		Tokens:     []string{"token-one"},
+		TokensFile: writeTempFile(t, "tokens", []byte("# deploy keys\n\ntoken-two\n")),
+	})
+	if err != nil {
+		t.Fatalf("newAuthenticator: %v", err)
+	}
+
+	for _, headers := range []map[string]string{
+		{"Authorization": "Bearer token-one"},
+		{"Authorization": "bearer token-two"},
+		{"X-API-Key": "token-one"},
+	} {
+		if rec := serveAuthenticated(a, headers); rec.Code != http.StatusOK {
+			t.Fatalf("%v: status = %d, want 200", headers, rec.Code)
+		}
+	}
+
+	rec := serveAuthenticated(a, nil)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", rec.Code)
+	}
+	if got, want := rec.Header().Get("WWW-Authenticate"), `Bearer resource_metadata="http://gateway.example/.well-known/oauth-protected-resource"`; got != want {
+		t.Fatalf("WWW-Authenticate = %q, want %q", got, want)
+	}
+
+	rec = serveAuthenticated(a, map[string]string{"Authorization": "Bearer token-three"})
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Header().Get("WWW-Authenticate"), `error="invalid_token"`) {
+		t.Fatalf("status = %d, WWW-Authenticate = %q", rec.Code, rec.Header().Get("WWW-Authenticate"))
+	}
+}
+
+func TestNoAuthConfigLeavesEndpointOpen(t *testing.T) {
+	a, err := newAuthenticator(authConfig{})
+	if err != nil || a != nil {
+		t.Fatalf("newAuthenticator = %v, %v", a, err)
+	}
+	if rec := serveAuthenticated(a, nil); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if _, err := newAuthenticator(authConfig{Issuer: "https://issuer.example"}); err == nil {
+		t.Fatal("issuer accepted without a JWKS")
+	}
+}
+
+func TestJWTValidation(t *testing.T) {
+	keys := newTestKeys(t)
+	a, err := newAuthenticator(authConfig{
+		JWKS:     writeTempFile(t, "jwks.json", keys.jwks()),
+		Issuer:   "https://issuer.example",
+		Audience: "https://gateway.example/mcp",
+		Scopes:   []string{"mcp:tools"},
+	})
+	if err != nil {
+		t.Fatalf("newAuthenticator: %v", err)
+	}
+
+	with := func(change func(claims map[string]interface{})) map[string]interface{} {
+		claims := validClaims()
+		change(claims)
+		return claims
+	}
+	for _, tc := range []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"RS256", signJWT(t, "RS256", "rsa", keys.rsa, validClaims()), http.StatusOK},
+		{"ES256", signJWT(t, "ES256", "ec", keys.ec, validClaims()), http.StatusOK},
+		{"EdDSA", signJWT(t, "EdDSA", "ed", keys.ed25519, validClaims()), http.StatusOK},
+		{"no kid", signJWT(t, "RS256", "", keys.rsa, validClaims()), http.StatusOK},
+		{"scp list", signJWT(t, "RS256", "rsa", keys.rsa, with(func(c map[string]interface{}) { delete(c, "scope"); c["scp"] = []string{"mcp:tools"} })), http.StatusOK},
+		{"audience string", signJWT(t, "RS256", "rsa", keys.rsa, with(func(c map[string]interface{}) { c["aud"] = "https://gateway.example/mcp" })), http.StatusOK},
+		{"wrong kid", signJWT(t, "RS256", "ec", keys.rsa, validClaims()), http.StatusUnauthorized},
+		{"encryption key", signJWT(t, "RS256", "enc", keys.rsa, validClaims()), http.StatusUnauthorized},
+		{"alg mismatch", signJWT(t, "RS384", "rsa", keys.rsa, validClaims()), http.StatusUnauthorized},
+		{"alg none", strings.Join(strings.Split(signJWT(t, "none", "rsa", keys.rsa, validClaims()), ".")[:2], ".") + ".", http.StatusUnauthorized},
+		{"expired", signJWT(t, "RS256", "rsa", keys.rsa, with(func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() })), http.StatusUnauthorized},
+		{"no expiry", signJWT(t, "RS256", "rsa", keys.rsa, with(func(c map[string]interface{}) { delete(c, "exp") })), http.StatusUnauthorized},
+		{"not yet valid", signJWT(t, "RS256", "rsa", keys.rsa, with(func(c map[string]interface{}) { c["nbf"] = time.Now().Add(time.Hour).Unix() })), http.StatusUnauthorized},
+		{"wrong issuer", signJWT(t, "RS256", "rsa", keys.rsa, with(func(c map[string]interface{}) { c["iss"] = "https://evil.example" })), http.StatusUnauthorized},
+		{"wrong audience", signJWT(t, "RS256", "rsa", keys.rsa, with(func(c map[string]interface{}) { c["aud"] = "https://other.example" })), http.StatusUnauthorized},
+		{"missing scope", signJWT(t, "RS256", "rsa", keys.rsa, with(func(c map[string]interface{}) { c["scope"] = "other" })), http.StatusForbidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := serveAuthenticated(a, map[string]string{"Authorization": "Bearer " + tc.token})
+			if rec.Code != tc.want {
+				t.Fatalf("status = %d, want %d (WWW-Authenticate %q)", rec.Code, tc.want, rec.Header().Get("WWW-Authenticate"))
+			}
+			if tc.want == http.StatusForbidden && !strings.Contains(rec.Header().Get("WWW-Authenticate"), `error="insufficient_scope", scope="mcp:tools"`) {
+				t.Fatalf("WWW-Authenticate = %q", rec.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestJWKSURLRefetchedForUnknownKey(t *testing.T) {
+	oldKeys, newKeys := newTestKeys(t), newTestKeys(t)
+	var fetches atomic.Int32
+	var current atomic.Value
+	current.Store(oldKeys.jwks())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Write(current.Load().([]byte))
+	}))
+	defer server.Close()
+
+	a, err := newAuthenticator(authConfig{JWKS: server.URL})
+	if err != nil {
+		t.Fatalf("newAuthenticator: %v", err)
+	}
+
+	// Keys rotate: the new key ID is unknown until the JWKS is fetched again
+	current.Store([]byte(strings.ReplaceAll(string(newKeys.jwks()), `"kid":"rsa"`, `"kid":"rsa-2"`)))
+	token := signJWT(t, "RS256", "rsa-2", newKeys.rsa, validClaims())
+
+	a.jwks.mu.Lock()
+	a.jwks.fetched = time.Now().Add(-jwksMinRefreshInterval - time.Second)
+	a.jwks.mu.Unlock()
+	if rec := serveAuthenticated(a, map[string]string{"Authorization": "Bearer " + token}); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if got := fetches.Load(); got != 2 {
+		t.Fatalf("JWKS fetched %d times, want 2", got)
+	}
+
+	// Unknown key IDs do not refetch more often than the minimum interval
+	serveAuthenticated(a, map[string]string{"Authorization": "Bearer " + signJWT(t, "RS256", "unknown", newKeys.rsa, validClaims())})
+	if got := fetches.Load(); got != 2 {
+		t.Fatalf("JWKS fetched %d times, want 2", got)
+	}
+}
+
+func TestProtectedResourceMetadata(t *testing.T) {
+	a, err := newAuthenticator(authConfig{
+		JWKS:   writeTempFile(t, "jwks.json", newTestKeys(t).jwks()),
+		Issuer: "https://issuer.example",
+		Scopes: []string{"mcp:tools"},
+	})
+	if err != nil {
+		t.Fatalf("newAuthenticator: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://gateway.example"+protectedResourcePath, nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	rec := httptest.NewRecorder()
+	a.HandleProtectedResourceMetadata("/mcp")(rec, req)
+
+	var metadata struct {
+		Resource             string   `json:"resource"`
+		AuthorizationServers []string `json:"authorization_servers"`
+		ScopesSupported      []string `json:"scopes_supported"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &metadata); err != nil {
+		t.Fatalf("unmarshal %s: %v", rec.Body, err)
+	}
+	if metadata.Resource != "https://gateway.example/mcp" || len(metadata.AuthorizationServers) != 1 || metadata.AuthorizationServers[0] != "https://issuer.example" || len(metadata.ScopesSupported) != 1 {
+		t.Fatalf("metadata = %s", rec.Body)
+	}
+}
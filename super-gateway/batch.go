@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// JSON-RPC 2.0 error codes used by the gateway
+const (
+	jsonRPCInvalidRequest = -32600
+	jsonRPCMethodNotFound = -32601
+	jsonRPCInvalidParams  = -32602
+	jsonRPCInternalError  = -32603
+	jsonRPCRequestTimeout = -32001
+)
+
+// isBatch reports whether body holds a JSON-RPC batch, i.e. a JSON array
+func isBatch(body []byte) bool {
+	trimmed := bytes.TrimLeft(body, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
+// newErrorResponse builds a JSON-RPC error response for the given request ID
+func newErrorResponse(id interface{}, code int, message string) json.RawMessage {
+	data, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"error":   map[string]interface{}{"code": code, "message": message},
+	})
+	return data
+}
+
+// parseBatch decodes a batch body. Elements that are not valid messages are
+// answered right away with an Invalid Request error, as JSON-RPC 2.0 requires;
+// initialize is rejected because it must not be part of a batch.
+func parseBatch(body []byte) ([]JSONRPCMessage, []json.RawMessage, error) {
+	var elements []json.RawMessage
+	if err := json.Unmarshal(body, &elements); err != nil {
+		return nil, nil, err
+	}
+	if len(elements) == 0 {
+		return nil, nil, fmt.Errorf("empty batch")
+	}
+
+	var msgs []JSONRPCMessage
+	var invalid []json.RawMessage
+	for _, element := range elements {
+		var msg JSONRPCMessage
+		if err := json.Unmarshal(element, &msg); err != nil || (msg.Method == "" && msg.ID == nil) {
+			invalid = append(invalid, newErrorResponse(nil, jsonRPCInvalidRequest, "Invalid Request"))
+			continue
+		}
+		if strings.EqualFold(msg.Method, "initialize") {
+			invalid = append(invalid, newErrorResponse(msg.ID, jsonRPCInvalidRequest, "initialize must not be part of a batch"))
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, invalid, nil
+}
+
+// pendingBatch tracks the requests of a forwarded batch until each of them
+// has been answered by the child
+type pendingBatch struct {
+	replies chan []byte
+	pending map[int64]json.RawMessage // gateway ID -> original request ID
+}
+
+// forwardBatch registers a route for every request of the batch on a shared
+// reply channel and forwards all messages to the child. Notifications and
+// responses inside the batch need no reply.
+func (g *Gateway) forwardBatch(clientID string, msgs []JSONRPCMessage, parent traceContext) (*pendingBatch, error) {
+	batch := &pendingBatch{
+		replies: make(chan []byte, len(msgs)),
+		pending: make(map[int64]json.RawMessage),
+	}
+
+	ids := make([]int64, len(msgs))
+	for i, msg := range msgs {
+		if msg.Method != "" && msg.ID != nil {
+			ids[i] = g.routes.Register(clientID, msg.ID, batch.replies)
+			batch.pending[ids[i]] = msg.ID
+		}
+	}
+
+	for i, msg := range msgs {
+		var err error
+		if ids[i] != 0 {
+			err = g.sendRequest(msg, clientID, ids[i], parent)
+		} else {
+			err = g.SendToMCP(msg, clientID)
+		}
+		var limitErr *rateLimitError
+		if errors.As(err, &limitErr) {
+			// Rejected requests are answered within the batch
+			g.rejectRequest(ids[i], msg, jsonRPCRateLimited, limitErr.Error())
+			continue
+		}
+		if err != nil {
+			for id := range batch.pending {
+				g.routes.Remove(id)
+			}
+			return nil, err
+		}
+	}
+	return batch, nil
+}
+
+// wait collects the replies to the batch. Requests still unanswered when the
+// timeout fires are reported as errors. It returns false when done is closed
+// or the session is terminated before every reply arrived.
+func (b *pendingBatch) wait(g *Gateway, timeout time.Duration, done <-chan struct{}) ([]json.RawMessage, bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var results []json.RawMessage
+	for received := 0; received < len(b.pending); received++ {
+		select {
+		case data := <-b.replies:
+			if data == nil {
+				// The session was terminated while the batch was in flight
+				return nil, false
+			}
+			results = append(results, data)
+		case <-timer.C:
+			// Give up on every request still in flight in one step, so each
+			// reply is either delivered or expired here, never lost in between
+			ids := make([]int64, 0, len(b.pending))
+			for id := range b.pending {
+				ids = append(ids, id)
+			}
+			expired := g.routes.RemoveIDs(ids)
+			for _, route := range expired {
+				g.expireRoute(route)
+				results = append(results, newErrorResponse(route.OriginalID, jsonRPCRequestTimeout, "Timeout waiting for response"))
+			}
+
+			// Replies whose routes were resolved before that are already
+			// delivered to the channel or about to be
+			for received += len(expired); received < len(b.pending); received++ {
+				select {
+				case data := <-b.replies:
+					if data == nil {
+						return nil, false
+					}
+					results = append(results, data)
+				case <-done:
+					return nil, false
+				}
+			}
+			return results, true
+		case <-done:
+			for id := range b.pending {
+				g.cancelRequest(id, "Client disconnected")
+			}
+			return nil, false
+		}
+	}
+	return results, true
+}
+
+// handleHTTPBatch forwards a batch POSTed to /mcp and answers with a single
+// JSON array holding every reply, or 202 when the batch held no requests
+func (g *Gateway) handleHTTPBatch(w http.ResponseWriter, r *http.Request, clientID string, body []byte) {
+	if sessionID := r.Header.Get("Mcp-Session-Id"); sessionID == "" && g.sessionMode == sessionModeIsolated {
+		http.Error(w, "Missing Mcp-Session-Id header", http.StatusBadRequest)
+		return
+	} else if sessionID != "" {
+		session, ok := g.sessions.Acquire(sessionID)
+		if !ok {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		defer g.sessions.Release(sessionID)
+
+		if pv := r.Header.Get("MCP-Protocol-Version"); pv != "" && !g.sessionSpeaks(session, pv) {
+			http.Error(w, fmt.Sprintf("Unsupported MCP-Protocol-Version %q, the session negotiated %q", pv, session.ProtocolVersion), http.StatusBadRequest)
+			return
+		}
+	} else if g.rejectWhileDraining(w) {
+		// Without a session a batch starts new work, like initialize does
+		return
+	}
+
+	msgs, results, err := parseBatch(body)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write(newErrorResponse(nil, jsonRPCInvalidRequest, "Invalid Request"))
+		return
+	}
+
+	batch, err := g.forwardBatch(clientID, msgs, traceContextFromHeaders(r.Header))
+	if err != nil {
+		log.Printf("Failed to send batch to MCP from client %s: %v", clientID, err)
+		http.Error(w, "Failed to process message", http.StatusInternalServerError)
+		return
+	}
+
+	replies, ok := batch.wait(g, g.responseTimeout, r.Context().Done())
+	if !ok {
+		if r.Context().Err() == nil {
+			http.Error(w, "Session not found", http.StatusNotFound)
+		}
+		return
+	}
+	results = append(results, replies...)
+	if len(results) == 0 {
+		// Only notifications and responses: 202 with no body
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	data, err := json.Marshal(results)
+	if err != nil {
+		http.Error(w, "Failed to encode batch response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// handleWebSocketBatch forwards a batch received over WebSocket and sends the
+// replies back to the client as one array once all of them have arrived
+func (g *Gateway) handleWebSocketBatch(c *Client, message []byte) {
+	msgs, results, err := parseBatch(message)
+	if err != nil {
+		log.Printf("Invalid batch from client %s: %v", c.ID, err)
+		g.sendToWebSocketClient(c.ID, newErrorResponse(nil, jsonRPCInvalidRequest, "Invalid Request"))
+		return
+	}
+
+	batch, err := g.forwardBatch(c.ID, msgs, c.TraceContext)
+	if err != nil {
+		log.Printf("Failed to send batch to MCP from client %s: %v", c.ID, err)
+		return
+	}
+
+	go func() {
+		// A disconnected client cancels the requests still in flight
+		replies, ok := batch.wait(g, g.responseTimeout, c.Done)
+		if !ok {
+			return
+		}
+		results = append(results, replies...)
+		if len(results) == 0 {
+			return
+		}
+		data, err := json.Marshal(results)
+		if err != nil {
+			log.Printf("Failed to encode batch response for client %s: %v", c.ID, err)
+			return
+		}
+		g.sendToWebSocketClient(c.ID, data)
+	}()
+}
+
+// sendToWebSocketClient queues data for a WebSocket client if it is still
+// connected
+func (g *Gateway) sendToWebSocketClient(clientID string, data []byte) {
+	if client, ok := g.webSocketClient(clientID); ok {
+		g.sendToWebSocket(client, data)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleHTTPMessageBatch(t *testing.T) {
+	g := newTestGateway(t, func(msg JSONRPCMessage) []string {
+		if msg.ID == nil {
+			return nil
+		}
+		id, _ := json.Marshal(msg.ID)
+		return []string{fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":{"method":%q}}`, id, msg.Method)}
+	})
+
+	body := `[
+		{"jsonrpc":"2.0","id":1,"method":"tools/list"},
+		{"jsonrpc":"2.0","method":"notifications/initialized"},
+		{"jsonrpc":"2.0","id":"two","method":"resources/list"},
+		{"jsonrpc":"2.0"}
+	]`
+	recorder := httptest.NewRecorder()
+	g.HandleHTTPMessage(recorder, httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(body)))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+	var replies []JSONRPCMessage
+	if err := json.Unmarshal(recorder.Body.Bytes(), &replies); err != nil {
+		t.Fatalf("response is not a JSON array: %v: %s", err, recorder.Body.String())
+	}
+	if len(replies) != 3 {
+		t.Fatalf("got %d replies, want 3: %s", len(replies), recorder.Body.String())
+	}
+	ids := map[string]bool{}
+	for _, reply := range replies {
+		ids[string(reply.ID)] = true
+	}
+	for _, id := range []string{`1`, `"two"`, `null`} {
+		if !ids[id] {
+			t.Fatalf("missing reply for id %s: %s", id, recorder.Body.String())
+		}
+	}
+}
+
+func TestHandleHTTPMessageBatchOfNotifications(t *testing.T) {
+	g := newTestGateway(t, func(msg JSONRPCMessage) []string { return nil })
+
+	body := `[{"jsonrpc":"2.0","method":"notifications/initialized"},{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":1}}]`
+	recorder := httptest.NewRecorder()
+	g.HandleHTTPMessage(recorder, httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(body)))
+
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202", recorder.Code)
+	}
+}
+
+func TestBatchWaitKeepsReplyResolvedAtTimeout(t *testing.T) {
+	g := NewGateway()
+	batch := &pendingBatch{replies: make(chan []byte, 1), pending: make(map[int64]json.RawMessage)}
+	id := g.routes.Register("a", json.RawMessage(`1`), batch.replies)
+	batch.pending[id] = json.RawMessage(`1`)
+
+	// The child's reply resolved the route but has not reached the batch yet
+	route, _ := g.routes.Resolve(id)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		g.deliverReply(route, JSONRPCMessage{JSONRPC: "2.0", Result: json.RawMessage(`{}`)})
+	}()
+
+	results, ok := batch.wait(g, time.Millisecond, nil)
+	if !ok || len(results) != 1 || !strings.Contains(string(results[0]), `"result"`) {
+		t.Fatalf("wait = %s, %v, want the reply", results, ok)
+	}
+}
+
+func TestHandleHTTPMessageBatchChecks(t *testing.T) {
+	g := newTestGateway(t, echoReply)
+	server := httptest.NewServer(mcpHandler(g))
+	t.Cleanup(server.Close)
+	sessionID := initializeSession(t, server.URL)
+	batch := `[{"jsonrpc":"2.0","id":2,"method":"tools/list"}]`
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(batch))
+	req.Header.Set("Mcp-Session-Id", sessionID)
+	req.Header.Set("MCP-Protocol-Version", "2024-11-05")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("batch with another protocol version: status = %d, want 400", resp.StatusCode)
+	}
+
+	g.draining.Store(true)
+	resp = doSessionRequest(t, http.MethodPost, server.URL, "", batch)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("batch without a session while draining: status = %d, want 503", resp.StatusCode)
+	}
+}
+
+func TestParseBatch(t *testing.T) {
+	if _, _, err := parseBatch([]byte(`[]`)); err == nil {
+		t.Fatal("expected an error for an empty batch")
+	}
+
+	msgs, invalid, err := parseBatch([]byte(`[{"jsonrpc":"2.0","id":1,"method":"initialize"},{"jsonrpc":"2.0","id":2,"method":"ping"}]`))
+	if err != nil {
+		t.Fatalf("parseBatch returned error: %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].Method != "ping" {
+		t.Fatalf("msgs = %+v, want only ping", msgs)
+	}
+	if len(invalid) != 1 || !strings.Contains(string(invalid[0]), "initialize must not be part of a batch") {
+		t.Fatalf("invalid = %s, want initialize rejection", invalid)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// jsonRPCRequestCancelled is the error code reported to a handler whose
+// request was cancelled by its client
+const jsonRPCRequestCancelled = -32800
+
+// cancelRequest abandons a forwarded request: its route is dropped so a late
+// reply from the child is swallowed, and the child is told to stop working
+// on it
+func (g *Gateway) cancelRequest(id int64, reason string) {
+	if route, ok := g.routes.Resolve(id); ok {
+		g.finishRequest(route, outcomeCancelled)
+		g.notifyCancelled(route, reason)
+	}
+}
+
+// expireRequest abandons a forwarded request whose reply did not arrive in
+// time and reports whether it was still in flight
+func (g *Gateway) expireRequest(id int64) bool {
+	route, ok := g.routes.Resolve(id)
+	if ok {
+		g.expireRoute(route)
+	}
+	return ok
+}
+
+// expireRoute finishes a request whose route was removed because its reply
+// did not arrive in time and tells the child to stop working on it
+func (g *Gateway) expireRoute(route *requestRoute) {
+	g.finishRequest(route, outcomeTimeout)
+	g.notifyCancelled(route, "Gateway timeout waiting for response")
+}
+
+// notifyCancelled sends notifications/cancelled for a forwarded request to
+// the child working on it
+func (g *Gateway) notifyCancelled(route *requestRoute, reason string) {
+	child := g.runningChild(route.ClientID)
+	if child == nil {
+		return
+	}
+	id := route.ID
+	params, err := json.Marshal(map[string]interface{}{
+		"requestId": id,
+		"reason":    reason,
+	})
+	if err != nil {
+		return
+	}
+	if err := child.write(JSONRPCMessage{
+		JSONRPC: "2.0",
+		Method:  "notifications/cancelled",
+		Params:  params,
+	}); err != nil {
+		log.Printf("Failed to send cancellation for request %d: %v", id, err)
+	}
+}
+
+// translateCancellation rewrites a client's notifications/cancelled so that
+// it names the gateway ID the request was forwarded under. The request's
+// route is dropped and a handler still waiting on it is released with an
+// error. It returns false when the request is not in flight for this client,
+// in which case the notification must not reach the child, since the ID
+// could belong to another client's request.
+func (g *Gateway) translateCancellation(msg JSONRPCMessage, clientID string) (JSONRPCMessage, bool) {
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return msg, false
+	}
+	id, route, ok := g.routes.RemoveByOriginalID(clientID, params["requestId"])
+	if !ok {
+		return msg, false
+	}
+	g.finishRequest(route, outcomeCancelled)
+	if route.Reply != nil {
+		select {
+		case route.Reply <- newErrorResponse(route.OriginalID, jsonRPCRequestCancelled, "Request cancelled"):
+		default:
+		}
+	}
+
+	params["requestId"] = gatewayID(id)
+	translated, err := json.Marshal(params)
+	if err != nil {
+		return msg, false
+	}
+	msg.Params = translated
+	return msg, true
+}
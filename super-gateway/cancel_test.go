@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimedOutRequestIsCancelledInChild(t *testing.T) {
+	forwarded := make(chan JSONRPCMessage, 2)
+	g := newTestGateway(t, func(msg JSONRPCMessage) []string {
+		forwarded <- msg
+		return nil
+	})
+	g.responseTimeout = 50 * time.Millisecond
+
+	recorder := httptest.NewRecorder()
+	g.HandleHTTPMessage(recorder, httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":"slow","method":"tools/call"}`)))
+	if recorder.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want 504", recorder.Code)
+	}
+
+	request := <-forwarded
+	select {
+	case cancellation := <-forwarded:
+		if cancellation.Method != "notifications/cancelled" {
+			t.Fatalf("child received %s, want notifications/cancelled", cancellation.Method)
+		}
+		var params struct {
+			RequestID json.RawMessage `json:"requestId"`
+		}
+		_ = json.Unmarshal(cancellation.Params, &params)
+		if string(params.RequestID) != string(request.ID) {
+			t.Fatalf("cancelled requestId = %s, want child-side id %s", params.RequestID, request.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("child did not receive notifications/cancelled")
+	}
+
+	// A late reply for the cancelled request is swallowed
+	g.handleChildLine(g.child, `{"jsonrpc":"2.0","id":`+string(request.ID)+`,"result":{}}`)
+	if n := g.routes.Len(); n != 0 {
+		t.Fatalf("%d routes left after cancellation", n)
+	}
+}
+
+func TestClientCancellationIsTranslated(t *testing.T) {
+	g := NewGateway()
+	id := g.routes.Register("client-a", json.RawMessage(`"req-1"`), nil)
+
+	msg := JSONRPCMessage{JSONRPC: "2.0", Method: "notifications/cancelled", Params: json.RawMessage(`{"requestId":"req-1","reason":"user abort"}`)}
+	if _, ok := g.translateCancellation(msg, "client-b"); ok {
+		t.Fatal("a client must not cancel another client's request")
+	}
+
+	translated, ok := g.translateCancellation(msg, "client-a")
+	if !ok {
+		t.Fatal("expected cancellation to be translated")
+	}
+	var params map[string]json.RawMessage
+	_ = json.Unmarshal(translated.Params, &params)
+	if string(params["requestId"]) != string(gatewayID(id)) {
+		t.Fatalf("requestId = %s, want %s", params["requestId"], gatewayID(id))
+	}
+	if string(params["reason"]) != `"user abort"` {
+		t.Fatalf("reason = %s, want it preserved", params["reason"])
+	}
+	if g.routes.Len() != 0 {
+		t.Fatal("cancelled request route was not dropped")
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Directions of cassette entries, named like those of logged messages
+const (
+	directionToChild   = "gateway_to_child"
+	directionFromChild = "child_to_gateway"
+)
+
+// cassetteEntry is one line of a cassette: a JSON-RPC message exchanged with
+// an MCP server
+type cassetteEntry struct {
+	Time      time.Time `json:"time"`
+	Session   string    `json:"session,omitempty"`
+	Direction string    `json:"direction"`
+	// ElapsedMs is how long the MCP server took to answer, set on replies
+	ElapsedMs *float64        `json:"elapsedMs,omitempty"`
+	Message   json.RawMessage `json:"message"`
+}
+
+// cassetteRecorder appends every message exchanged with MCP servers to a
+// JSONL cassette file, masking secrets like the logs do
+type cassetteRecorder struct {
+	mu     sync.Mutex
+	file   io.WriteCloser
+	redact *redactor
+	// sent holds when each request awaiting a reply was written, by session
+	// and ID
+	sent map[string]time.Time
+}
+
+func newCassetteRecorder(path string, redact *redactor) (*cassetteRecorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600) // #nosec G304 -- the cassette path is chosen by the operator.
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cassette: %w", err)
+	}
+	return &cassetteRecorder{file: file, redact: redact, sent: make(map[string]time.Time)}, nil
+}
+
+// record appends a message passing in direction between the gateway and the
+// child of sessionID
+func (r *cassetteRecorder) record(direction, sessionID string, data []byte) {
+	if r == nil {
+		return
+	}
+	var msg JSONRPCMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return
+	}
+	message := json.RawMessage(data)
+	if r.redact != nil {
+		// Masking a secret inside an escaped string can break the document,
+		// which is then kept as a string
+		masked := r.redact.JSON(string(data))
+		if !json.Valid([]byte(masked)) {
+			masked, _ := json.Marshal(masked)
+			message = masked
+		} else {
+			message = json.RawMessage(masked)
+		}
+	}
+
+	now := time.Now()
+	entry := cassetteEntry{Time: now, Session: sessionID, Direction: direction, Message: message}
+	key := sessionID + "\x00" + string(msg.ID)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if msg.ID != nil {
+		switch {
+		case direction == directionToChild && msg.Method != "":
+			r.sent[key] = now
+		case direction == directionFromChild && msg.Method == "":
+			if sent, ok := r.sent[key]; ok {
+				elapsed := float64(now.Sub(sent).Microseconds()) / 1000
+				entry.ElapsedMs = &elapsed
+				delete(r.sent, key)
+			}
+		}
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	if _, err := r.file.Write(append(line, '\n')); err != nil {
+		log.Printf("Failed to record message: %v", err)
+	}
+}
+
+// Close closes the cassette file
+func (r *cassetteRecorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// cassette is a recording loaded for replay
+type cassette struct {
+	exchanges []cassetteExchange
+}
+
+// cassetteExchange is a recorded request with what the MCP server sent while
+// answering it: notifications and, last, the reply
+type cassetteExchange struct {
+	method   string
+	params   string
+	messages []JSONRPCMessage
+}
+
+// loadCassette reads a cassette written by cassetteRecorder
+func loadCassette(path string) (*cassette, error) {
+	file, err := os.Open(path) // #nosec G304 -- the cassette path is chosen by the operator.
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cassette: %w", err)
+	}
+	defer file.Close()
+
+	c := &cassette{}
+	// open holds the exchanges awaiting their reply by session and ID, and
+	// latest the most recent one of each session
+	open := make(map[string]int)
+	latest := make(map[string]int)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 1024*1024), maxScannerTokenSize)
+	for n := 1; scanner.Scan(); n++ {
+		var entry cassetteEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("cassette line %d: %w", n, err)
+		}
+		if len(entry.Message) > 0 && entry.Message[0] == '"' {
+			// Recorded as text because masking broke it, so it cannot be replayed
+			continue
+		}
+		var msg JSONRPCMessage
+		if err := json.Unmarshal(entry.Message, &msg); err != nil {
+			return nil, fmt.Errorf("cassette line %d: invalid message: %w", n, err)
+		}
+		key := entry.Session + "\x00" + string(msg.ID)
+
+		switch {
+		case entry.Direction == directionToChild && msg.ID != nil && msg.Method != "":
+			c.exchanges = append(c.exchanges, cassetteExchange{method: msg.Method, params: canonicalParams(msg.Params)})
+			open[key] = len(c.exchanges) - 1
+			latest[entry.Session] = len(c.exchanges) - 1
+		case entry.Direction == directionFromChild && msg.ID == nil && msg.Method != "":
+			// Notifications belong to the request the server was working on
+			if i, ok := latest[entry.Session]; ok {
+				c.exchanges[i].messages = append(c.exchanges[i].messages, msg)
+			}
+		case entry.Direction == directionFromChild && msg.ID != nil && msg.Method == "":
+			if i, ok := open[key]; ok {
+				c.exchanges[i].messages = append(c.exchanges[i].messages, msg)
+				delete(open, key)
+			}
+		}
+		// The gateway's notifications and the server's own requests are not
+		// replayed
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+	return c, nil
+}
+
+// canonicalParams returns params in a form that compares equal for equal
+// values, leaving out the per-request _meta
+func canonicalParams(params json.RawMessage) string {
+	if len(params) == 0 {
+		return ""
+	}
+	var value interface{}
+	if err := json.Unmarshal(params, &value); err != nil {
+		return string(params)
+	}
+	if object, ok := value.(map[string]interface{}); ok {
+		delete(object, "_meta")
+	}
+	canonical, _ := json.Marshal(value)
+	return string(canonical)
+}
+
+// cassettePlayer answers requests from a cassette. Each replayed MCP server
+// has its own player, which is used by one goroutine only.
+type cassettePlayer struct {
+	cassette *cassette
+	redact   *redactor
+	used     []bool
+}
+
+func (c *cassette) player(redact *redactor) *cassettePlayer {
+	return &cassettePlayer{cassette: c, redact: redact, used: make([]bool, len(c.exchanges))}
+}
+
+// answer returns the lines the recorded MCP server sent in reply to line
+func (p *cassettePlayer) answer(line []byte) []string {
+	var msg JSONRPCMessage
+	if err := json.Unmarshal(line, &msg); err != nil || msg.ID == nil || msg.Method == "" {
+		// Notifications and replies to the server's requests get no answer
+		return nil
+	}
+	// Recorded params are masked, so the request is masked the same way
+	// before comparing them
+	masked := msg
+	if p.redact != nil {
+		_ = json.Unmarshal([]byte(p.redact.JSON(string(line))), &masked)
+	}
+
+	exchange := p.find(masked.Method, canonicalParams(masked.Params))
+	if exchange == nil && msg.Method == "ping" {
+		// Pings are rarely recorded, and readiness probes rely on them
+		reply, _ := json.Marshal(JSONRPCMessage{JSONRPC: "2.0", ID: msg.ID, Result: json.RawMessage(`{}`)})
+		return []string{string(reply)}
+	}
+	if exchange == nil {
+		reply, _ := json.Marshal(JSONRPCMessage{
+			JSONRPC: "2.0",
+			ID:      msg.ID,
+			Error:   map[string]interface{}{"code": jsonRPCMethodNotFound, "message": "No recorded response for " + msg.Method},
+		})
+		return []string{string(reply)}
+	}
+
+	lines := make([]string, 0, len(exchange.messages))
+	for _, recorded := range exchange.messages {
+		if recorded.Method == "" {
+			recorded.ID = msg.ID
+		}
+		data, err := json.Marshal(recorded)
+		if err != nil {
+			continue
+		}
+		lines = append(lines, string(data))
+	}
+	return lines
+}
+
+// find picks the exchange answering a request: the first unused one with
+// the same method and params, then any with them, then the same by method
+// alone, so requests repeated more often than recorded or with params
+// that changed since still get an answer
+func (p *cassettePlayer) find(method, params string) *cassetteExchange {
+	for _, exact := range []bool{true, false} {
+		for _, fresh := range []bool{true, false} {
+			for i := range p.cassette.exchanges {
+				exchange := &p.cassette.exchanges[i]
+				if exchange.method != method || (exact && exchange.params != params) || (fresh && p.used[i]) {
+					continue
+				}
+				p.used[i] = true
+				return exchange
+			}
+		}
+	}
+	return nil
+}
+
+// startReplayChild starts an MCP server that answers from the cassette
+// instead of running the command. It stops once its stdin is closed.
+func (g *Gateway) startReplayChild(sessionID string) *childProcess {
+	stdinReader, stdinWriter := io.Pipe()
+	c := &childProcess{
+		SessionID:   sessionID,
+		stdin:       stdinWriter,
+		stdinWriter: bufio.NewWriter(stdinWriter),
+		exited:      make(chan struct{}),
+		startedAt:   time.Now(),
+	}
+	c.touch()
+	player := g.replay.player(g.redact)
+	if sessionID == "" {
+		log.Printf("Started MCP server replaying %d recorded requests", len(g.replay.exchanges))
+	} else {
+		log.Printf("Started MCP server for session %s replaying %d recorded requests", sessionID, len(g.replay.exchanges))
+	}
+
+	go func() {
+		scanner := bufio.NewScanner(stdinReader)
+		scanner.Buffer(make([]byte, 0, 1024*1024), maxScannerTokenSize)
+		for scanner.Scan() {
+			for _, line := range player.answer(scanner.Bytes()) {
+				g.handleChildLine(c, line)
+			}
+		}
+		close(c.exited)
+		g.handleChildExit(c)
+	}()
+	return c
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postMessage sends a single JSON-RPC message to g and returns the reply body
+func postMessage(g *Gateway, body string) string {
+	recorder := httptest.NewRecorder()
+	g.HandleHTTPMessage(recorder, httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(body)))
+	return recorder.Body.String()
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	redact := newRedactor(nil, nil, []string{"params.arguments.apiKey"})
+	path := filepath.Join(t.TempDir(), "cassette.jsonl")
+	recorder, err := newCassetteRecorder(path, redact)
+	if err != nil {
+		t.Fatalf("newCassetteRecorder: %v", err)
+	}
+
+	live := newTestGateway(t, func(msg JSONRPCMessage) []string {
+		switch msg.Method {
+		case "initialize":
+			return []string{fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":{"protocolVersion":"2025-06-18","capabilities":{},"serverInfo":{"name":"live"}}}`, msg.ID)}
+		case "tools/call":
+			var params struct {
+				Arguments struct {
+					Query string `json:"query"`
+				} `json:"arguments"`
+			}
+			_ = json.Unmarshal(msg.Params, &params)
+			return []string{
+				`{"jsonrpc":"2.0","method":"notifications/message","params":{"level":"info","data":"searching"}}`,
+				fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":{"content":[{"type":"text","text":"results for %s"}]}}`, msg.ID, params.Arguments.Query),
+			}
+		}
+		return nil
+	})
+	live.child.recorder = recorder
+	if err := live.WaitForReady(5 * time.Second); err != nil {
+		t.Fatalf("WaitForReady: %v", err)
+	}
+	for _, query := range []string{"go", "rust"} {
+		postMessage(live, `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"search","arguments":{"query":"`+query+`","apiKey":"live-key"}}}`)
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read cassette: %v", err)
+	}
+	if strings.Contains(string(data), "live-key") || !strings.Contains(string(data), `"elapsedMs"`) {
+		t.Fatalf("cassette = %s, want masked keys and reply times", data)
+	}
+
+	cassette, err := loadCassette(path)
+	if err != nil {
+		t.Fatalf("loadCassette: %v", err)
+	}
+	g := NewGateway()
+	g.replay = cassette
+	g.redact = redact
+	go g.Run()
+	if err := g.StartMCPServer(nil); err != nil {
+		t.Fatalf("StartMCPServer: %v", err)
+	}
+	t.Cleanup(g.sharedChild().stop)
+	if err := g.WaitForReady(5 * time.Second); err != nil {
+		t.Fatalf("WaitForReady: %v", err)
+	}
+	if info := g.sharedChild().initResult.Load(); info == nil || !strings.Contains(string(info.ServerInfo), "live") {
+		t.Fatalf("replayed initialize result = %+v", info)
+	}
+
+	// The request matches the recording despite a different masked key and
+	// the order of the calls
+	body := postMessage(g, `{"jsonrpc":"2.0","id":"r","method":"tools/call","params":{"name":"search","arguments":{"query":"rust","apiKey":"replay-key"}}}`)
+	if !strings.Contains(body, `"id":"r"`) || !strings.Contains(body, "results for rust") {
+		t.Fatalf("replayed tools/call = %s", body)
+	}
+	if body := postMessage(g, `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`); !strings.Contains(body, "No recorded response") {
+		t.Fatalf("unrecorded tools/list = %s", body)
+	}
+	if body := postMessage(g, `{"jsonrpc":"2.0","id":3,"method":"ping"}`); !strings.Contains(body, `"result":{}`) {
+		t.Fatalf("ping = %s", body)
+	}
+}
+
+func TestCassettePlayerFallsBackToMethod(t *testing.T) {
+	c := &cassette{exchanges: []cassetteExchange{
+		{method: "tools/call", params: `{"name":"a"}`, messages: []JSONRPCMessage{{JSONRPC: "2.0", ID: []byte("1"), Result: []byte(`"a"`)}}},
+		{method: "tools/call", params: `{"name":"b"}`, messages: []JSONRPCMessage{{JSONRPC: "2.0", ID: []byte("2"), Result: []byte(`"b"`)}}},
+	}}
+	p := c.player(nil)
+
+	for i, want := range []string{`"b"`, `"b"`, `"a"`} {
+		params := `{"name":"b","_meta":{"progressToken":1}}`
+		if i == 2 {
+			params = `{"name":"c"}`
+		}
+		lines := p.answer([]byte(`{"jsonrpc":"2.0","id":9,"method":"tools/call","params":` + params + `}`))
+		if len(lines) != 1 || !strings.Contains(lines[0], `"result":`+want) || !strings.Contains(lines[0], `"id":9`) {
+			t.Fatalf("call %d = %v, want result %s", i, lines, want)
+		}
+	}
+}
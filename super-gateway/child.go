@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// childProcess is a running instance of the MCP server command. In shared
+// mode a single child serves every client; in isolated mode each session owns
+// one, identified by SessionID.
+type childProcess struct {
+	SessionID        string
+	cmd              *exec.Cmd
+	stdin            io.Closer
+	stdinWriter      *bufio.Writer
+	stdinMu          sync.Mutex
+	readinessReply   chan JSONRPCMessage
+	readinessReplyMu sync.Mutex
+	// lastActivity is the time of the last message exchanged with the child,
+	// in Unix nanoseconds
+	lastActivity atomic.Int64
+	// stopped is set when the gateway kills the child on purpose, so its exit
+	// is not treated as a crash
+	stopped atomic.Bool
+	// exited is closed once the process has exited, with exitErr set to the
+	// error it exited with
+	exited    chan struct{}
+	exitErr   error
+	startedAt time.Time
+	// calls are the gateway's own requests awaiting a reply, by ID
+	calls    map[string]chan JSONRPCMessage
+	callsMu  sync.Mutex
+	nextCall atomic.Int64
+	// toolsMu serializes tool list refreshes; exposedTools is the filtered
+	// tool list clients last saw, empty until it was first fetched
+	toolsMu      sync.Mutex
+	exposedTools string
+	// initResult is the child's answer to the gateway's initialize request,
+	// nil until the handshake succeeded
+	initResult atomic.Pointer[initializeResult]
+	// probeFailed is set while the child does not answer readiness pings
+	probeFailed atomic.Bool
+	// recorder, if set, records the messages exchanged with the child
+	recorder *cassetteRecorder
+}
+
+// childEnv returns environ without the gateway's own variables, so secrets
+// such as the accepted auth tokens do not reach the MCP server
+func childEnv(environ []string) []string {
+	env := make([]string, 0, len(environ))
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if !gatewayEnv(name) {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// startChild launches the stored MCP server command. The child's stdout is
+// routed through handleChildLine and its exit is reported to handleChildExit.
+func (g *Gateway) startChild(sessionID string) (*childProcess, error) {
+	if g.replay != nil {
+		return g.startReplayChild(sessionID), nil
+	}
+	g.cmdMu.Lock()
+	cmdParts := g.cmdParts
+	g.cmdMu.Unlock()
+	if len(cmdParts) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+
+	c := &childProcess{
+		SessionID: sessionID,
+		cmd:       exec.Command(cmdParts[0], cmdParts[1:]...),
+		exited:    make(chan struct{}),
+		recorder:  g.recorder,
+	}
+	c.cmd.Env = childEnv(os.Environ())
+	c.touch()
+
+	// Set up pipes
+	stdin, err := c.cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+	c.stdin = stdin
+	c.stdinWriter = bufio.NewWriter(stdin)
+
+	stdout, err := c.cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+	stdoutScanner := bufio.NewScanner(stdout)
+	// The default bufio.Scanner buffer caps lines at 64KB, which is too small for
+	// large JSON-RPC payloads such as tools/list responses from some MCP servers
+	// (e.g. SigNoz). Raise the max token size so those lines are not dropped with
+	// a "token too long" error.
+	stdoutScanner.Buffer(make([]byte, 0, 1024*1024), maxScannerTokenSize)
+
+	stderr, err := c.cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stderr pipe: %w", err)
+	}
+	stderrScanner := bufio.NewScanner(stderr)
+	stderrScanner.Buffer(make([]byte, 0, 1024*1024), maxScannerTokenSize)
+
+	// Start the process
+	if err := c.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start command: %w", err)
+	}
+
+	c.startedAt = time.Now()
+	if sessionID == "" {
+		log.Printf("Started MCP server with PID: %d", c.cmd.Process.Pid)
+	} else {
+		log.Printf("Started MCP server for session %s with PID: %d", sessionID, c.cmd.Process.Pid)
+	}
+
+	// Create channels to signal when stdout/stderr reading is complete
+	stdoutDone := make(chan struct{})
+	stderrDone := make(chan struct{})
+
+	// Handle stdout
+	go func() {
+		defer close(stdoutDone)
+		for stdoutScanner.Scan() {
+			g.handleChildLine(c, stdoutScanner.Text())
+		}
+
+		// Check for scanner errors
+		if err := stdoutScanner.Err(); err != nil {
+			log.Printf("stdout scanner error: %v", err)
+		}
+	}()
+
+	// Handle stderr
+	go func() {
+		defer close(stderrDone)
+		for stderrScanner.Scan() {
+			// Rewrite OAuth URLs for proper routing
+			rewrittenLine := rewriteOAuthURL(stderrScanner.Text())
+			if sessionID == "" {
+				log.Printf("Child stderr: %s", rewrittenLine)
+			} else {
+				log.Printf("Child stderr [%s]: %s", sessionID, rewrittenLine)
+			}
+		}
+	}()
+
+	// Monitor process exit - wait for stdout/stderr to finish before calling Wait()
+	go func() {
+		// Wait for both stdout and stderr goroutines to finish reading
+		<-stdoutDone
+		<-stderrDone
+
+		if err := c.cmd.Wait(); err != nil {
+			log.Printf("MCP server exited with error: %v", err)
+			c.exitErr = err
+		} else {
+			log.Printf("MCP server exited normally")
+		}
+		close(c.exited)
+
+		g.handleChildExit(c)
+	}()
+
+	return c, nil
+}
+
+// running reports whether the child's process has not exited yet
+func (c *childProcess) running() bool {
+	if c.exited == nil {
+		return c.stdinWriter != nil
+	}
+	select {
+	case <-c.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+// stop kills the child without it counting as a crash
+func (c *childProcess) stop() {
+	c.stopped.Store(true)
+	if c.cmd != nil && c.cmd.Process != nil {
+		_ = c.cmd.Process.Kill()
+		return
+	}
+	// A replayed child has no process and stops once its stdin is closed
+	if c.stdin != nil {
+		_ = c.stdin.Close()
+	}
+}
+
+// touch records activity on the child
+func (c *childProcess) touch() {
+	c.lastActivity.Store(time.Now().UnixNano())
+}
+
+// LastActivity returns when a message was last exchanged with the child
+func (c *childProcess) LastActivity() time.Time {
+	return time.Unix(0, c.lastActivity.Load())
+}
+
+// write sends a message to the child's stdin as is
+func (c *childProcess) write(msg JSONRPCMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	logMessage("gateway_to_child", c.SessionID, msg, data)
+	return c.writeLine(data)
+}
+
+// writeLine writes one newline-delimited message to the child's stdin
+func (c *childProcess) writeLine(data []byte) error {
+	c.stdinMu.Lock()
+	defer c.stdinMu.Unlock()
+
+	c.touch()
+	c.recorder.record(directionToChild, c.SessionID, data)
+	if _, err := c.stdinWriter.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write to stdin: %w", err)
+	}
+
+	return c.stdinWriter.Flush()
+}
+
+// deliverReadinessReply hands the reply to the gateway's own initialize
+// request to whoever is waiting for it
+func (c *childProcess) deliverReadinessReply(msg JSONRPCMessage) {
+	c.readinessReplyMu.Lock()
+	defer c.readinessReplyMu.Unlock()
+	if c.readinessReply != nil {
+		select {
+		case c.readinessReply <- msg:
+		default:
+		}
+	}
+}
+
+// expectReadinessReply sets up the channel on which the reply to the
+// gateway's own initialize request is delivered; the returned function
+// tears it down again
+func (c *childProcess) expectReadinessReply() (<-chan JSONRPCMessage, func()) {
+	reply := make(chan JSONRPCMessage, 1)
+	c.readinessReplyMu.Lock()
+	c.readinessReply = reply
+	c.readinessReplyMu.Unlock()
+
+	return reply, func() {
+		c.readinessReplyMu.Lock()
+		c.readinessReply = nil
+		c.readinessReplyMu.Unlock()
+	}
+}
+
+// initialize performs the initialize handshake with params, sending the
+// request once and notifications/initialized after the reply, and returns the
+// child's initialize result. It runs the gateway's own handshake with the
+// shared child, and replays a client's handshake with the replacement of a
+// session's child so it can serve the session without the client noticing.
+func (c *childProcess) initialize(params json.RawMessage, timeout time.Duration) (json.RawMessage, error) {
+	reply, done := c.expectReadinessReply()
+	defer done()
+
+	if err := c.write(JSONRPCMessage{
+		JSONRPC: "2.0",
+		ID:      json.RawMessage(readinessCheckID),
+		Method:  "initialize",
+		Params:  params,
+	}); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var result json.RawMessage
+	select {
+	case msg := <-reply:
+		if msg.Error != nil {
+			return nil, fmt.Errorf("MCP server returned error during initialize: %v", msg.Error)
+		}
+		result = msg.Result
+	case <-c.exited:
+		return nil, fmt.Errorf("MCP server exited during initialize")
+	case <-timer.C:
+		return nil, fmt.Errorf("timeout waiting for initialize response")
+	}
+
+	return result, c.write(JSONRPCMessage{JSONRPC: "2.0", Method: "notifications/initialized"})
+}
+
+// call sends a request of the gateway's own to the child and waits for the
+// reply. It must not be called from the goroutine reading the child's stdout.
+func (c *childProcess) call(method string, params json.RawMessage, timeout time.Duration) (JSONRPCMessage, error) {
+	id := fmt.Sprintf(`"gateway-%d"`, c.nextCall.Add(1))
+	reply := make(chan JSONRPCMessage, 1)
+	c.callsMu.Lock()
+	if c.calls == nil {
+		c.calls = make(map[string]chan JSONRPCMessage)
+	}
+	c.calls[id] = reply
+	c.callsMu.Unlock()
+	defer func() {
+		c.callsMu.Lock()
+		delete(c.calls, id)
+		c.callsMu.Unlock()
+	}()
+
+	if err := c.write(JSONRPCMessage{JSONRPC: "2.0", ID: json.RawMessage(id), Method: method, Params: params}); err != nil {
+		return JSONRPCMessage{}, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case msg := <-reply:
+		if msg.Error != nil {
+			return msg, fmt.Errorf("MCP server returned error for %s: %v", method, msg.Error)
+		}
+		return msg, nil
+	case <-c.exited:
+		return JSONRPCMessage{}, fmt.Errorf("MCP server exited during %s", method)
+	case <-timer.C:
+		return JSONRPCMessage{}, fmt.Errorf("timeout waiting for %s response", method)
+	}
+}
+
+// deliverCallReply hands a reply to a pending call and reports whether msg
+// was one
+func (c *childProcess) deliverCallReply(msg JSONRPCMessage) bool {
+	if msg.Method != "" || msg.ID == nil {
+		return false
+	}
+	c.callsMu.Lock()
+	defer c.callsMu.Unlock()
+	reply, ok := c.calls[string(msg.ID)]
+	if ok {
+		reply <- msg
+		delete(c.calls, string(msg.ID))
+	}
+	return ok
+}
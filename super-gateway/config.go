@@ -0,0 +1,898 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Defaults of options that have no better home
+const (
+	defaultPort             = 8000
+	defaultReadinessTimeout = 30 * time.Second
+	defaultResponseTimeout  = 5 * time.Minute
+)
+
+// Config is the gateway's configuration. Each option is read from a config
+// file, a flag and an environment variable, in increasing precedence.
+type Config struct {
+	Port             int
+	HealthPort       int
+	Transport        string
+	Authentication   bool
+	HTTPUpstream     string
+	HTTPUpstreamPath string
+
+	SessionIdleTimeout time.Duration
+	MaxSessions        int
+	SessionMode        string
+	MaxProcesses       int
+	ProcessIdleTimeout time.Duration
+	ReplayBufferSize   int
+
+	TraceExporter string
+	TraceEndpoint string
+	TraceFile     string
+	LogLevel      string
+	LogFormat     string
+	LogPayloads   bool
+	RedactEnv     []string
+	RedactPaths   []string
+
+	AuthTokens     []string
+	AuthTokensFile string
+	AuthJWKS       string
+	AuthIssuer     string
+	AuthAudience   string
+	AuthScopes     []string
+	AuthResource   string
+
+	ToolsConfig string
+	ToolsAllow  []string
+	ToolsDeny   []string
+	ToolsPrefix string
+	ToolsRename string
+
+	RateLimit          string
+	SessionRateLimit   string
+	RateLimitOverrides string
+	MaxInFlight        int
+	MaxQueue           int
+	QueueTimeout       time.Duration
+
+	SendQueueSize int
+	SendOverflow  string
+	SendTimeout   time.Duration
+
+	WSPingInterval   time.Duration
+	WSPongTimeout    time.Duration
+	WSMaxMessageSize int64
+	WSAllowedOrigins string
+	WSCompression    bool
+
+	ProtocolVersions       string
+	InitializeMode         string
+	SkipReadinessCheck     bool
+	ReadinessTimeout       time.Duration
+	ReadinessProbeInterval time.Duration
+	ResponseTimeout        time.Duration
+
+	Restart             string
+	MaxRestarts         int
+	RestartWindow       time.Duration
+	RestartBackoff      time.Duration
+	MaxRestartBackoff   time.Duration
+	RestartHealthyAfter time.Duration
+
+	ShutdownTimeout  time.Duration
+	ChildStopTimeout time.Duration
+
+	Record string
+	Replay string
+
+	Connect     string
+	Headers     []string
+	BearerToken string
+
+	// Stdio is the MCP server command and its arguments
+	Stdio []string
+
+	// warnings are logged once logging is set up
+	warnings []string
+}
+
+func defaultConfig() Config {
+	restarts := defaultRestartPolicy()
+	return Config{
+		Port:                defaultPort,
+		Transport:           transportWebSocket,
+		SessionIdleTimeout:  defaultSessionIdleTimeout,
+		MaxSessions:         defaultMaxSessions,
+		SessionMode:         sessionModeShared,
+		MaxProcesses:        defaultMaxProcesses,
+		ProcessIdleTimeout:  defaultProcessIdleTimeout,
+		ReplayBufferSize:    defaultReplayBufferSize,
+		TraceExporter:       traceExporterNone,
+		LogLevel:            "info",
+		LogFormat:           logFormatJSON,
+		MaxQueue:            defaultMaxQueue,
+		QueueTimeout:        defaultQueueTimeout,
+		SendQueueSize:       defaultSendQueueSize,
+		SendOverflow:        overflowBlock,
+		SendTimeout:         defaultSendTimeout,
+		WSPingInterval:      defaultWSPingInterval,
+		WSPongTimeout:       defaultWSPongTimeout,
+		WSMaxMessageSize:    maxScannerTokenSize,
+		ProtocolVersions:    strings.Join(defaultProtocolVersions, ","),
+		InitializeMode:      initializeForward,
+		ReadinessTimeout:    defaultReadinessTimeout,
+		ResponseTimeout:     defaultResponseTimeout,
+		Restart:             restarts.Mode,
+		MaxRestarts:         restarts.MaxRestarts,
+		RestartWindow:       restarts.Window,
+		RestartBackoff:      restarts.Backoff,
+		MaxRestartBackoff:   restarts.MaxBackoff,
+		RestartHealthyAfter: restarts.HealthyAfter,
+		ShutdownTimeout:     defaultShutdownTimeout,
+		ChildStopTimeout:    defaultChildStopTimeout,
+	}
+}
+
+// option is one configuration setting: --Name on the command line, Env in
+// the environment and Key in a config file. Env names start with
+// SUPER_GATEWAY_ so the MCP server's own variables, which share the
+// environment, do not configure the gateway.
+type option struct {
+	Name  string
+	Arg   string
+	Env   string
+	Key   string
+	Usage string
+	// Secret options are masked by --print-config
+	Secret bool
+	Value  flag.Getter
+}
+
+// options lists every setting bound to the fields of c, in the order they
+// are documented
+func (c *Config) options() []option {
+	return []option{
+		{Name: "port", Arg: "<port>", Env: "SUPER_GATEWAY_PORT", Key: "port", Usage: "Port to listen on", Value: &intValue{p: &c.Port, min: 1, max: 65535}},
+		{Name: "health-port", Arg: "<port>", Env: "SUPER_GATEWAY_HEALTH_PORT", Key: "healthPort", Usage: "Port of the /health, /livez, /readyz, /status and /metrics endpoints, 0 for --port + 1", Value: &intValue{p: &c.HealthPort, max: 65535}},
+		{Name: "transport", Arg: "<transport>", Env: "SUPER_GATEWAY_TRANSPORT", Key: "transport", Usage: "Connection transport: 'websocket', 'http-stream', 'sse' (legacy HTTP+SSE), or a comma-separated list served together on one port", Value: &stringValue{p: &c.Transport, check: checkWith(parseTransports)}},
+		{Name: "authentication", Env: "SUPER_GATEWAY_AUTHENTICATION", Key: "authentication", Usage: "Enable OAuth callback proxy (forwards non-MCP requests to port 12849)", Value: &boolValue{p: &c.Authentication}},
+		{Name: "http-upstream", Arg: "<url>", Env: "SUPER_GATEWAY_HTTP_UPSTREAM", Key: "httpUpstream", Usage: "Fixed loopback HTTP MCP upstream URL to proxy instead of stdio JSON-RPC", Value: &stringValue{p: &c.HTTPUpstream}},
+		{Name: "http-upstream-path", Arg: "<path>", Env: "SUPER_GATEWAY_HTTP_UPSTREAM_PATH", Key: "httpUpstreamPath", Usage: "Public MCP path to allow for HTTP upstream mode (default: upstream path)", Value: &stringValue{p: &c.HTTPUpstreamPath}},
+		{Name: "session-idle-timeout", Arg: "<duration>", Env: "SUPER_GATEWAY_SESSION_IDLE_TIMEOUT", Key: "sessionIdleTimeout", Usage: "Expire HTTP sessions idle for this long, 0 disables", Value: &durationValue{p: &c.SessionIdleTimeout}},
+		{Name: "max-sessions", Arg: "<n>", Env: "SUPER_GATEWAY_MAX_SESSIONS", Key: "maxSessions", Usage: "Maximum concurrent HTTP sessions, 0 for unlimited", Value: &intValue{p: &c.MaxSessions}},
+		{Name: "session-mode", Arg: "<mode>", Env: "SUPER_GATEWAY_SESSION_MODE", Key: "sessionMode", Usage: "'shared' runs one MCP server for all clients, 'isolated' one per session", Value: &stringValue{p: &c.SessionMode, check: checkOneOf(sessionModeShared, sessionModeIsolated)}},
+		{Name: "max-processes", Arg: "<n>", Env: "SUPER_GATEWAY_MAX_PROCESSES", Key: "maxProcesses", Usage: "Maximum concurrent MCP server processes in isolated mode, 0 for unlimited", Value: &intValue{p: &c.MaxProcesses}},
+		{Name: "process-idle-timeout", Arg: "<duration>", Env: "SUPER_GATEWAY_PROCESS_IDLE_TIMEOUT", Key: "processIdleTimeout", Usage: "Stop a session's MCP server after this long without traffic, 0 disables", Value: &durationValue{p: &c.ProcessIdleTimeout}},
+		{Name: "replay-buffer-size", Arg: "<n>", Env: "SUPER_GATEWAY_REPLAY_BUFFER_SIZE", Key: "replayBufferSize", Usage: "SSE events kept per session so clients reconnecting with Last-Event-ID get what they missed, 0 to disable", Value: &intValue{p: &c.ReplayBufferSize}},
+		{Name: "trace-exporter", Arg: "<exporter>", Env: "SUPER_GATEWAY_TRACE_EXPORTER", Key: "traceExporter", Usage: "Export request spans: 'none', 'otlp', 'stdout' or 'file'", Value: &stringValue{p: &c.TraceExporter, check: checkOneOf(traceExporterNone, traceExporterOTLP, traceExporterStdout, traceExporterFile)}},
+		{Name: "trace-endpoint", Arg: "<url>", Env: "SUPER_GATEWAY_TRACE_ENDPOINT", Key: "traceEndpoint", Usage: "OTLP/HTTP traces endpoint (default: OTEL_EXPORTER_OTLP_TRACES_ENDPOINT, OTEL_EXPORTER_OTLP_ENDPOINT or http://localhost:4318/v1/traces)", Value: &stringValue{p: &c.TraceEndpoint}},
+		{Name: "trace-file", Arg: "<path>", Env: "SUPER_GATEWAY_TRACE_FILE", Key: "traceFile", Usage: "File the 'file' trace exporter appends OTLP/JSON lines to", Value: &stringValue{p: &c.TraceFile}},
+		{Name: "log-level", Arg: "<level>", Env: "SUPER_GATEWAY_LOG_LEVEL", Key: "logLevel", Usage: "Log verbosity: 'debug', 'info', 'warn' or 'error'", Value: &stringValue{p: &c.LogLevel, check: checkWith(parseLogLevel)}},
+		{Name: "log-format", Arg: "<format>", Env: "SUPER_GATEWAY_LOG_FORMAT", Key: "logFormat", Usage: "Log output: 'json' or 'text'", Value: &stringValue{p: &c.LogFormat, check: checkOneOf(logFormatJSON, logFormatText)}},
+		{Name: "log-payloads", Env: "SUPER_GATEWAY_LOG_PAYLOADS", Key: "logPayloads", Usage: "Include JSON-RPC payloads in debug logs", Value: &boolValue{p: &c.LogPayloads}},
+		{Name: "redact-env", Arg: "<names>", Env: "SUPER_GATEWAY_REDACT_ENV", Key: "redactEnv", Usage: "Comma-separated env vars whose values are masked in logs, in addition to names containing KEY, TOKEN, SECRET, PASSWORD, CREDENTIAL or AUTH", Value: &listValue{p: &c.RedactEnv}},
+		{Name: "redact-paths", Arg: "<paths>", Env: "SUPER_GATEWAY_REDACT_PATHS", Key: "redactPaths", Usage: "Comma-separated JSON paths masked in logged payloads, '*' matches any key or index, e.g. params.arguments.password", Value: &listValue{p: &c.RedactPaths}},
+		{Name: "auth-tokens", Arg: "<tokens>", Env: "SUPER_GATEWAY_AUTH_TOKENS", Key: "authTokens", Usage: "Comma-separated static bearer tokens or API keys required on the MCP endpoint", Secret: true, Value: &listValue{p: &c.AuthTokens}},
+		{Name: "auth-tokens-file", Arg: "<path>", Env: "SUPER_GATEWAY_AUTH_TOKENS_FILE", Key: "authTokensFile", Usage: "File of static tokens, one per line", Value: &stringValue{p: &c.AuthTokensFile}},
+		{Name: "auth-jwks", Arg: "<path|url>", Env: "SUPER_GATEWAY_AUTH_JWKS", Key: "authJwks", Usage: "JWKS file or URL to verify JWT bearer tokens against", Value: &stringValue{p: &c.AuthJWKS}},
+		{Name: "auth-issuer", Arg: "<iss>", Env: "SUPER_GATEWAY_AUTH_ISSUER", Key: "authIssuer", Usage: "Required JWT issuer, advertised as authorization server", Value: &stringValue{p: &c.AuthIssuer}},
+		{Name: "auth-audience", Arg: "<aud>", Env: "SUPER_GATEWAY_AUTH_AUDIENCE", Key: "authAudience", Usage: "Required JWT audience", Value: &stringValue{p: &c.AuthAudience}},
+		{Name: "auth-scopes", Arg: "<scopes>", Env: "SUPER_GATEWAY_AUTH_SCOPES", Key: "authScopes", Usage: "Comma-separated scopes a JWT must grant", Value: &listValue{p: &c.AuthScopes}},
+		{Name: "auth-resource", Arg: "<url>", Env: "SUPER_GATEWAY_AUTH_RESOURCE", Key: "authResource", Usage: "Canonical MCP server URL for protected resource metadata (default: derived from request)", Value: &stringValue{p: &c.AuthResource}},
+		{Name: "tools-config", Arg: "<path>", Env: "SUPER_GATEWAY_TOOLS_CONFIG", Key: "toolsConfig", Usage: "JSON file with allow, deny, prefix, rename and descriptions tool filter settings", Value: &stringValue{p: &c.ToolsConfig}},
+		{Name: "tools-allow", Arg: "<globs>", Env: "SUPER_GATEWAY_TOOLS_ALLOW", Key: "toolsAllow", Usage: "Comma-separated glob patterns of tools to expose", Value: &listValue{p: &c.ToolsAllow}},
+		{Name: "tools-deny", Arg: "<globs>", Env: "SUPER_GATEWAY_TOOLS_DENY", Key: "toolsDeny", Usage: "Comma-separated glob patterns of tools to hide, overriding --tools-allow", Value: &listValue{p: &c.ToolsDeny}},
+		{Name: "tools-prefix", Arg: "<prefix>", Env: "SUPER_GATEWAY_TOOLS_PREFIX", Key: "toolsPrefix", Usage: "Prefix added to exposed tool names", Value: &stringValue{p: &c.ToolsPrefix}},
+		{Name: "tools-rename", Arg: "<pairs>", Env: "SUPER_GATEWAY_TOOLS_RENAME", Key: "toolsRename", Usage: "Comma-separated original=exposed tool renames", Value: &stringValue{p: &c.ToolsRename, check: checkOptional(parseToolRenames)}},
+		{Name: "rate-limit", Arg: "<rate[/burst]>", Env: "SUPER_GATEWAY_RATE_LIMIT", Key: "rateLimit", Usage: "Requests per second forwarded to the MCP server across all clients, 0 for unlimited", Value: &stringValue{p: &c.RateLimit, check: checkOptional(parseRateLimit)}},
+		{Name: "session-rate-limit", Arg: "<rate[/burst]>", Env: "SUPER_GATEWAY_SESSION_RATE_LIMIT", Key: "sessionRateLimit", Usage: "Requests per second per session or connection, 0 for unlimited", Value: &stringValue{p: &c.SessionRateLimit, check: checkOptional(parseRateLimit)}},
+		{Name: "rate-limit-overrides", Arg: "<list>", Env: "SUPER_GATEWAY_RATE_LIMIT_OVERRIDES", Key: "rateLimitOverrides", Usage: "Comma-separated method[:tool]=rate[/burst] limits replacing --rate-limit for matching requests, e.g. tools/call:search=2/5,ping=0", Value: &stringValue{p: &c.RateLimitOverrides, check: checkOptional(parseRateLimitOverrides)}},
+		{Name: "max-inflight", Arg: "<n>", Env: "SUPER_GATEWAY_MAX_INFLIGHT", Key: "maxInflight", Usage: "Maximum requests awaiting a reply from the MCP server, 0 for unlimited", Value: &intValue{p: &c.MaxInFlight}},
+		{Name: "max-queue", Arg: "<n>", Env: "SUPER_GATEWAY_MAX_QUEUE", Key: "maxQueue", Usage: "Maximum requests waiting in line for --max-inflight", Value: &intValue{p: &c.MaxQueue}},
+		{Name: "queue-timeout", Arg: "<duration>", Env: "SUPER_GATEWAY_QUEUE_TIMEOUT", Key: "queueTimeout", Usage: "How long a request waits in line before it is rejected", Value: &durationValue{p: &c.QueueTimeout, positive: true}},
+		{Name: "send-queue-size", Arg: "<n>", Env: "SUPER_GATEWAY_SEND_QUEUE_SIZE", Key: "sendQueueSize", Usage: "Messages queued per client before the overflow policy applies", Value: &intValue{p: &c.SendQueueSize, min: 1}},
+		{Name: "send-overflow", Arg: "<policy>", Env: "SUPER_GATEWAY_SEND_OVERFLOW", Key: "sendOverflow", Usage: "What to do when a client's queue is full: 'block' stops reading the MCP server's output for up to --send-timeout, then drops the message; 'disconnect' closes the client; 'drop-oldest' discards the oldest queued message", Value: &stringValue{p: &c.SendOverflow, check: checkWith(parseOverflowPolicy)}},
+		{Name: "send-timeout", Arg: "<duration>", Env: "SUPER_GATEWAY_SEND_TIMEOUT", Key: "sendTimeout", Usage: "How long the block policy waits for room in a client's queue", Value: &durationValue{p: &c.SendTimeout, positive: true}},
+		{Name: "ws-ping-interval", Arg: "<duration>", Env: "SUPER_GATEWAY_WS_PING_INTERVAL", Key: "wsPingInterval", Usage: "How often WebSocket clients are pinged, 0 to disable heartbeats", Value: &durationValue{p: &c.WSPingInterval}},
+		{Name: "ws-pong-timeout", Arg: "<duration>", Env: "SUPER_GATEWAY_WS_PONG_TIMEOUT", Key: "wsPongTimeout", Usage: "How long a WebSocket client may take to answer a ping or accept a write before it is disconnected", Value: &durationValue{p: &c.WSPongTimeout, positive: true}},
+		{Name: "ws-max-message-size", Arg: "<bytes>", Env: "SUPER_GATEWAY_WS_MAX_MESSAGE_SIZE", Key: "wsMaxMessageSize", Usage: "Largest WebSocket message accepted from a client", Value: &int64Value{p: &c.WSMaxMessageSize, min: 1}},
+		{Name: "ws-allowed-origins", Arg: "<list>", Env: "SUPER_GATEWAY_WS_ALLOWED_ORIGINS", Key: "wsAllowedOrigins", Usage: "Comma-separated origins, e.g. https://*.example.com, browsers may open WebSocket connections from; all when unset", Value: &stringValue{p: &c.WSAllowedOrigins, check: checkOptional(parseAllowedOrigins)}},
+		{Name: "ws-compression", Env: "SUPER_GATEWAY_WS_COMPRESSION", Key: "wsCompression", Usage: "Negotiate permessage-deflate compression with WebSocket clients", Value: &boolValue{p: &c.WSCompression}},
+		{Name: "protocol-versions", Arg: "<list>", Env: "SUPER_GATEWAY_PROTOCOL_VERSIONS", Key: "protocolVersions", Usage: "Comma-separated MCP protocol versions accepted from clients, the first one requested from the MCP server", Value: &stringValue{p: &c.ProtocolVersions, check: checkWith(parseProtocolVersions)}},
+		{Name: "initialize-mode", Arg: "<mode>", Env: "SUPER_GATEWAY_INITIALIZE_MODE", Key: "initializeMode", Usage: "'forward' sends each client's initialize to the MCP server, 'cache' answers it with the result of the gateway's own handshake", Value: &stringValue{p: &c.InitializeMode, check: checkWith(parseInitializeMode)}},
+		{Name: "skip-readiness-check", Env: "SUPER_GATEWAY_SKIP_READINESS_CHECK", Key: "skipReadinessCheck", Usage: "Do not wait for the MCP server to answer initialize at startup and after restarts", Value: &boolValue{p: &c.SkipReadinessCheck}},
+		{Name: "readiness-timeout", Arg: "<duration>", Env: "SUPER_GATEWAY_READINESS_TIMEOUT", Key: "readinessTimeout", Usage: "How long the MCP server may take to answer the gateway's initialize", Value: &durationValue{p: &c.ReadinessTimeout, positive: true}},
+		{Name: "readiness-probe-interval", Arg: "<duration>", Env: "SUPER_GATEWAY_READINESS_PROBE_INTERVAL", Key: "readinessProbeInterval", Usage: "Ping the MCP server this often and report not ready on /readyz while it does not answer, 0 to disable", Value: &durationValue{p: &c.ReadinessProbeInterval}},
+		{Name: "response-timeout", Arg: "<duration>", Env: "SUPER_GATEWAY_RESPONSE_TIMEOUT", Key: "responseTimeout", Usage: "How long to wait for the MCP server's reply to a request", Value: &durationValue{p: &c.ResponseTimeout, positive: true}},
+		{Name: "restart", Arg: "<mode>", Env: "SUPER_GATEWAY_RESTART", Key: "restart", Usage: "When to restart an MCP server that exited: 'never', 'on-failure' or 'always'", Value: &stringValue{p: &c.Restart, check: checkWith(parseRestartMode)}},
+		{Name: "max-restarts", Arg: "<n>", Env: "SUPER_GATEWAY_MAX_RESTARTS", Key: "maxRestarts", Usage: "Restarts allowed within --restart-window before the MCP server is given up on, 0 for unlimited", Value: &intValue{p: &c.MaxRestarts}},
+		{Name: "restart-window", Arg: "<duration>", Env: "SUPER_GATEWAY_RESTART_WINDOW", Key: "restartWindow", Usage: "Sliding window --max-restarts applies to", Value: &durationValue{p: &c.RestartWindow, positive: true}},
+		{Name: "restart-backoff", Arg: "<duration>", Env: "SUPER_GATEWAY_RESTART_BACKOFF", Key: "restartBackoff", Usage: "Delay before the first restart, doubled with jitter for each further one", Value: &durationValue{p: &c.RestartBackoff}},
+		{Name: "max-restart-backoff", Arg: "<duration>", Env: "SUPER_GATEWAY_MAX_RESTART_BACKOFF", Key: "maxRestartBackoff", Usage: "Longest delay between restarts, 0 for 30s", Value: &durationValue{p: &c.MaxRestartBackoff}},
+		{Name: "restart-healthy-after", Arg: "<duration>", Env: "SUPER_GATEWAY_RESTART_HEALTHY_AFTER", Key: "restartHealthyAfter", Usage: "Uptime after which an MCP server's restart history is forgotten", Value: &durationValue{p: &c.RestartHealthyAfter}},
+		{Name: "shutdown-timeout", Arg: "<duration>", Env: "SUPER_GATEWAY_SHUTDOWN_TIMEOUT", Key: "shutdownTimeout", Usage: "How long in-flight requests may take to finish after SIGTERM before connections are closed", Value: &durationValue{p: &c.ShutdownTimeout}},
+		{Name: "child-stop-timeout", Arg: "<duration>", Env: "SUPER_GATEWAY_CHILD_STOP_TIMEOUT", Key: "childStopTimeout", Usage: "How long the MCP server may take to exit after SIGTERM before it is killed", Value: &durationValue{p: &c.ChildStopTimeout, positive: true}},
+		{Name: "record", Arg: "<path>", Env: "SUPER_GATEWAY_RECORD_FILE", Key: "record", Usage: "Append every JSON-RPC message exchanged with the MCP server, with reply times, to this JSONL cassette, masking secrets like the logs", Value: &stringValue{p: &c.Record}},
+		{Name: "replay", Arg: "<path>", Env: "SUPER_GATEWAY_REPLAY_FILE", Key: "replay", Usage: "Answer requests from a cassette written by --record instead of running the MCP server; --stdio is not needed", Value: &stringValue{p: &c.Replay}},
+		{Name: "connect", Arg: "<url>", Env: "SUPER_GATEWAY_CONNECT_URL", Key: "connect", Usage: "Client mode: expose the remote MCP server at this http(s) Streamable HTTP or ws(s) URL on stdin/stdout instead of running --stdio", Value: &stringValue{p: &c.Connect}},
+		{Name: "header", Arg: "<header>", Env: "SUPER_GATEWAY_CONNECT_HEADERS", Key: "headers", Usage: "'Name: value' header sent to the --connect server, may be repeated; the env var is comma-separated and adds to the flags", Secret: true, Value: &listValue{p: &c.Headers, repeated: true}},
+		{Name: "bearer-token", Arg: "<token>", Env: "SUPER_GATEWAY_CONNECT_BEARER_TOKEN", Key: "bearerToken", Usage: "Bearer token sent to the --connect server", Secret: true, Value: &stringValue{p: &c.BearerToken}},
+	}
+}
+
+// Settings handled outside the option table
+const (
+	configEnv       = "SUPER_GATEWAY_CONFIG_FILE"
+	configJSONEnv   = "SUPER_GATEWAY_CONFIG_JSON"
+	stdioEnv        = "SUPER_GATEWAY_STDIO_COMMAND"
+	stdioKey        = "stdio"
+	stdioFlag       = "--stdio"
+	configUsage     = "YAML (.yaml, .yml) or JSON file of options keyed by their camelCase names, e.g. sessionMode: isolated, and stdio: the MCP server command as a list or a shell-quoted string"
+	configJSONUsage = "The options of a config file as an inline JSON object, e.g. {\"port\":80}"
+)
+
+// gatewayEnv reports whether name is one of the gateway's own variables,
+// which are kept from MCP servers
+func gatewayEnv(name string) bool {
+	switch name {
+	case configEnv, configJSONEnv, stdioEnv:
+		return true
+	}
+	for _, opt := range (&Config{}).options() {
+		if opt.Env == name {
+			return true
+		}
+	}
+	return false
+}
+
+// legacyEnv are the variables read before the SUPER_GATEWAY_ prefix. They
+// still set their option, below the prefixed variables, with a warning.
+var legacyEnv = []struct {
+	Name   string
+	Option string
+	// convert maps the old value format to the option's, false when the
+	// old gateway ignored the value
+	convert func(string) (string, bool)
+}{
+	{Name: "PORT", Option: "port", convert: keepValue},
+	{Name: "TRANSPORT", Option: "transport", convert: keepValue},
+	// A bare number is seconds
+	{Name: "MCP_RESPONSE_TIMEOUT", Option: "response-timeout", convert: func(value string) (string, bool) {
+		if _, err := strconv.Atoi(value); err == nil {
+			return value + "s", true
+		}
+		return value, true
+	}},
+	// Only "true" skipped the check
+	{Name: "SKIP_READINESS_CHECK", Option: "skip-readiness-check", convert: func(value string) (string, bool) {
+		return value, value == "true"
+	}},
+}
+
+func keepValue(value string) (string, bool) { return value, true }
+
+// errPrintConfig is returned by loadConfig when --print-config was given
+var errPrintConfig = errors.New("print config")
+
+// loadConfig builds the configuration from the defaults, the config file
+// named by --config or given inline by --config-json, the flags in args and
+// the environment.
+// Everything after --stdio in args is the MCP server command.
+func loadConfig(args []string, getenv func(string) string) (Config, error) {
+	cfg := defaultConfig()
+	options := cfg.options()
+
+	var command []string
+	hasCommand := false
+	for i, arg := range args {
+		if arg == stdioFlag {
+			args, command, hasCommand = args[:i], args[i+1:], true
+			break
+		}
+	}
+
+	// Flags are recorded first and applied after the config file, which they
+	// override
+	type assignment struct {
+		option *option
+		value  string
+	}
+	var assignments []assignment
+	fs := flag.NewFlagSet("super-gateway", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	for i := range options {
+		opt := &options[i]
+		fs.Var(&recordedValue{Getter: opt.Value, record: func(value string) {
+			assignments = append(assignments, assignment{opt, value})
+		}}, opt.Name, opt.Usage)
+	}
+	configPath := fs.String("config", "", configUsage)
+	configJSON := fs.String("config-json", "", configJSONUsage)
+	printConfig := fs.Bool("print-config", false, "Print the resulting configuration as JSON and exit")
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	if fs.NArg() > 0 {
+		return cfg, fmt.Errorf("unexpected argument %q; the MCP server command goes after --stdio", fs.Arg(0))
+	}
+
+	if *configPath == "" {
+		*configPath = getenv(configEnv)
+	}
+	if *configJSON == "" {
+		*configJSON = getenv(configJSONEnv)
+	}
+	var values map[string]interface{}
+	var err error
+	switch {
+	case *configPath != "" && *configJSON != "":
+		return cfg, fmt.Errorf("--config cannot be combined with --config-json")
+	case *configPath != "":
+		values, err = readConfigFile(*configPath)
+	case *configJSON != "":
+		values, err = parseJSONConfig([]byte(*configJSON))
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := cfg.applyFile(options, values); err != nil {
+		return cfg, fmt.Errorf("config: %w", err)
+	}
+
+	for _, a := range assignments {
+		if err := a.option.Value.Set(a.value); err != nil {
+			return cfg, fmt.Errorf("invalid value %q for --%s: %w", a.value, a.option.Name, err)
+		}
+	}
+
+	for _, legacy := range legacyEnv {
+		value := getenv(legacy.Name)
+		if value == "" {
+			continue
+		}
+		for _, opt := range options {
+			if opt.Name != legacy.Option {
+				continue
+			}
+			cfg.warnings = append(cfg.warnings, fmt.Sprintf("%s is deprecated, use %s", legacy.Name, opt.Env))
+			converted, ok := legacy.convert(value)
+			if !ok {
+				break
+			}
+			if err := opt.Value.Set(converted); err != nil {
+				return cfg, fmt.Errorf("invalid value %q for %s: %w", value, legacy.Name, err)
+			}
+		}
+	}
+
+	for _, opt := range options {
+		value := getenv(opt.Env)
+		if value == "" {
+			continue
+		}
+		values := []string{value}
+		if list, ok := opt.Value.(*listValue); ok && list.repeated {
+			values = splitList(value)
+		}
+		for _, v := range values {
+			if err := opt.Value.Set(v); err != nil {
+				return cfg, fmt.Errorf("invalid value %q for %s: %w", value, opt.Env, err)
+			}
+		}
+	}
+
+	if hasCommand {
+		cfg.Stdio = command
+	} else if value := getenv(stdioEnv); value != "" {
+		cfg.Stdio = []string{value}
+	}
+
+	if err := cfg.validate(); err != nil {
+		return cfg, err
+	}
+	if *printConfig {
+		return cfg, errPrintConfig
+	}
+	return cfg, nil
+}
+
+// applyFile sets the options named by the keys of a config file
+func (c *Config) applyFile(options []option, values map[string]interface{}) error {
+	byKey := make(map[string]*option, len(options))
+	for i := range options {
+		byKey[options[i].Key] = &options[i]
+	}
+	for key, value := range values {
+		if key == stdioKey {
+			switch value := value.(type) {
+			case string:
+				c.Stdio = []string{value}
+			case []string:
+				c.Stdio = value
+			}
+			continue
+		}
+		opt, ok := byKey[key]
+		if !ok {
+			return fmt.Errorf("unknown option %q", key)
+		}
+		var err error
+		switch value := value.(type) {
+		case string:
+			err = opt.Value.Set(value)
+		case []string:
+			list, ok := opt.Value.(*listValue)
+			if !ok {
+				return fmt.Errorf("option %q takes a single value, not a list", key)
+			}
+			list.setAll(value)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid value %v for %s: %w", value, key, err)
+		}
+	}
+	return nil
+}
+
+// validate checks the options that depend on each other
+func (c *Config) validate() error {
+	if c.Connect != "" {
+		return nil
+	}
+	if len(c.Stdio) == 0 && c.Replay == "" {
+		return fmt.Errorf("--stdio flag is required with at least one argument")
+	}
+	if c.Record != "" && c.Replay != "" {
+		return fmt.Errorf("--record cannot be combined with --replay")
+	}
+	// A command given as one string is split like a shell command line
+	if len(c.Stdio) == 1 && strings.Contains(c.Stdio[0], " ") {
+		if _, err := splitCommandLine(c.Stdio[0]); err != nil {
+			return err
+		}
+	}
+	if c.MaxRestartBackoff > 0 && c.MaxRestartBackoff < c.RestartBackoff {
+		return fmt.Errorf("max restart backoff %s is shorter than restart backoff %s", c.MaxRestartBackoff, c.RestartBackoff)
+	}
+	if c.HTTPUpstream == "" {
+		if c.HTTPUpstreamPath != "" {
+			return fmt.Errorf("--http-upstream-path requires --http-upstream")
+		}
+	} else {
+		if _, err := ParseHTTPUpstreamConfig(c.HTTPUpstream, c.HTTPUpstreamPath); err != nil {
+			return fmt.Errorf("invalid HTTP upstream config: %w", err)
+		}
+		if transports, _ := parseTransports(c.Transport); len(transports) != 1 || !transports[transportHTTPStream] {
+			return fmt.Errorf("--http-upstream requires --transport http-stream")
+		}
+		if c.Authentication {
+			return fmt.Errorf("--http-upstream cannot be combined with --authentication")
+		}
+		if c.SessionMode == sessionModeIsolated {
+			return fmt.Errorf("--http-upstream cannot be combined with --session-mode isolated")
+		}
+		// Requests proxied to the upstream are not admitted by the limiter
+		if c.rateLimits().enabled() {
+			return fmt.Errorf("--http-upstream cannot be combined with rate limits or --max-inflight")
+		}
+		// Traffic with an HTTP upstream does not pass the gateway as JSON-RPC
+		// lines
+		if c.Record != "" || c.Replay != "" {
+			return fmt.Errorf("--record and --replay require a stdio MCP server")
+		}
+	}
+	// Isolated sessions and HTTP upstreams see every client's own initialize
+	if c.InitializeMode == initializeCache && (c.HTTPUpstream != "" || c.SessionMode == sessionModeIsolated) {
+		return fmt.Errorf("--initialize-mode cache requires a shared stdio MCP server")
+	}
+	return nil
+}
+
+// rateLimits returns the limits on requests forwarded to the MCP server
+func (c *Config) rateLimits() rateLimitConfig {
+	limits := rateLimitConfig{MaxInFlight: c.MaxInFlight, MaxQueue: c.MaxQueue, QueueTimeout: c.QueueTimeout}
+	limits.Global, _ = parseRateLimit(c.RateLimit)
+	limits.Session, _ = parseRateLimit(c.SessionRateLimit)
+	limits.Overrides, _ = parseRateLimitOverrides(c.RateLimitOverrides)
+	return limits
+}
+
+// healthPort is the port of the health and metrics endpoints
+func (c *Config) healthPort() int {
+	if c.HealthPort != 0 {
+		return c.HealthPort
+	}
+	return c.Port + 1
+}
+
+// writeJSON writes the configuration in the format read by --config, with
+// secrets masked
+func (c *Config) writeJSON(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.WriteString("{\n")
+	for _, opt := range c.options() {
+		value := opt.Value.Get()
+		if opt.Secret && opt.Value.String() != "" {
+			value = redactedValue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "  %q: %s,\n", opt.Key, encoded)
+	}
+	stdio, _ := json.Marshal(c.Stdio)
+	if c.Stdio == nil {
+		stdio = []byte("[]")
+	}
+	fmt.Fprintf(&buf, "  %q: %s\n}\n", stdioKey, stdio)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// writeUsage documents every option with its default and env var
+func writeUsage(w io.Writer, program string) {
+	fmt.Fprintf(w, "Usage: %s [options] --stdio <command> [args...]\n", program)
+	fmt.Fprintf(w, "\nOptions:\n")
+	writeUsageLine(w, "--config <path>", configUsage+" (env: "+configEnv+")")
+	writeUsageLine(w, "--config-json <json>", configJSONUsage+" (env: "+configJSONEnv+")")
+	writeUsageLine(w, "--print-config", "Print the resulting configuration as JSON and exit")
+	defaults := defaultConfig()
+	for _, opt := range defaults.options() {
+		name := "--" + opt.Name
+		if opt.Arg != "" {
+			name += " " + opt.Arg
+		}
+		var notes []string
+		if _, isBool := opt.Value.(*boolValue); !isBool {
+			if value := opt.Value.String(); value != "" && !strings.Contains(opt.Usage, "(default:") {
+				notes = append(notes, "default: "+value)
+			}
+		}
+		notes = append(notes, "env: "+opt.Env)
+		writeUsageLine(w, name, opt.Usage+" ("+strings.Join(notes, ", ")+")")
+	}
+	writeUsageLine(w, "--stdio <command>", "MCP server command to run; everything after it is passed to the subprocess. A single string, as the env var gives it, is split into words like a shell command line with quotes and backslashes (env: "+stdioEnv+")")
+	fmt.Fprintf(w, "\nOptions are read from the config file, then flags, then environment variables, each overriding the previous.\n")
+	fmt.Fprintf(w, "The deprecated PORT, TRANSPORT, MCP_RESPONSE_TIMEOUT (seconds or a duration) and SKIP_READINESS_CHECK are still read, below their SUPER_GATEWAY_ names.\n")
+	fmt.Fprintf(w, "\nExamples:\n")
+	fmt.Fprintf(w, "  WebSocket transport:\n")
+	fmt.Fprintf(w, "    %s --port 8000 --transport websocket --stdio npx -y @modelcontextprotocol/server-filesystem /path\n", program)
+	fmt.Fprintf(w, "  Legacy HTTP+SSE transport:\n")
+	fmt.Fprintf(w, "    %s --port 8000 --transport sse --stdio npx -y @modelcontextprotocol/server-filesystem /path\n", program)
+	fmt.Fprintf(w, "  All transports on one port:\n")
+	fmt.Fprintf(w, "    %s --port 8000 --transport http-stream,sse,websocket --stdio npx -y @modelcontextprotocol/server-filesystem /path\n", program)
+	fmt.Fprintf(w, "  HTTP streaming transport:\n")
+	fmt.Fprintf(w, "    %s --port 8000 --transport http-stream --stdio npx -y @modelcontextprotocol/server-filesystem /path\n", program)
+	fmt.Fprintf(w, "  With OAuth authentication (mcp-remote):\n")
+	fmt.Fprintf(w, "    %s --port 8000 --transport http-stream --authentication --stdio mcp-remote https://example.com/mcp\n", program)
+	fmt.Fprintf(w, "  Existing HTTP MCP upstream on loopback:\n")
+	fmt.Fprintf(w, "    %s --port 8000 --transport http-stream --http-upstream http://127.0.0.1:8081/mcp --stdio go run ./cmd/dummy_mcp\n", program)
+	fmt.Fprintf(w, "  Recording traffic, then serving the recording without the MCP server:\n")
+	fmt.Fprintf(w, "    %s --port 8000 --transport http-stream --record tavily.jsonl --stdio npx -y tavily-mcp\n", program)
+	fmt.Fprintf(w, "    %s --port 8000 --transport http-stream --replay tavily.jsonl\n", program)
+	fmt.Fprintf(w, "  Options from a config file:\n")
+	fmt.Fprintf(w, "    %s --config gateway.yaml --stdio npx -y @modelcontextprotocol/server-filesystem /path\n", program)
+	fmt.Fprintf(w, "  Client mode, bridging a local stdio MCP host to a deployed server:\n")
+	fmt.Fprintf(w, "    %s --connect https://example.com/mcp --header 'X-API-Key: ...'\n", program)
+	fmt.Fprintf(w, "\nNote: Everything after --stdio is passed to the subprocess\n")
+}
+
+func writeUsageLine(w io.Writer, name, usage string) {
+	if len(name) < 22 {
+		name += strings.Repeat(" ", 22-len(name))
+	}
+	fmt.Fprintf(w, "  %s %s\n", name, usage)
+}
+
+// readConfigFile reads a YAML or JSON config file, told apart by the
+// extension, into option values, each a string or a list of strings
+func readConfigFile(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- the config file is chosen by the operator.
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return parseYAMLConfig(data)
+	}
+	return parseJSONConfig(data)
+}
+
+func parseYAMLConfig(data []byte) (map[string]interface{}, error) {
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid YAML config: %w", err)
+	}
+	return configValues(raw)
+}
+
+func parseJSONConfig(data []byte) (map[string]interface{}, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid JSON config: %w", err)
+	}
+	return configValues(raw)
+}
+
+// configValues turns the decoded values of a config file into strings and
+// lists of strings, as flags would give them
+func configValues(raw map[string]interface{}) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(raw))
+	for key, value := range raw {
+		if list, ok := value.([]interface{}); ok {
+			items := make([]string, 0, len(list))
+			for _, item := range list {
+				s, err := configScalar(item)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", key, err)
+				}
+				items = append(items, s)
+			}
+			values[key] = items
+			continue
+		}
+		s, err := configScalar(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		values[key] = s
+	}
+	return values, nil
+}
+
+func configScalar(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case int:
+		return strconv.Itoa(value), nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	}
+	return "", fmt.Errorf("values must be strings, numbers, booleans or lists of them")
+}
+
+// recordedValue passes a flag's value to record instead of setting it
+type recordedValue struct {
+	flag.Getter
+	record func(string)
+}
+
+func (v *recordedValue) Set(value string) error {
+	v.record(value)
+	return nil
+}
+
+func (v *recordedValue) IsBoolFlag() bool {
+	b, ok := v.Getter.(*boolValue)
+	return ok && b.IsBoolFlag()
+}
+
+type stringValue struct {
+	p     *string
+	check func(string) error
+}
+
+func (v *stringValue) Set(value string) error {
+	if v.check != nil {
+		if err := v.check(value); err != nil {
+			return err
+		}
+	}
+	*v.p = value
+	return nil
+}
+
+func (v *stringValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return *v.p
+}
+
+func (v *stringValue) Get() interface{} { return *v.p }
+
+// checkWith validates a string with one of the gateway's parse functions
+func checkWith[T any](parse func(string) (T, error)) func(string) error {
+	return func(value string) error {
+		_, err := parse(value)
+		return err
+	}
+}
+
+// checkOptional is checkWith for options that are unset when empty
+func checkOptional[T any](parse func(string) (T, error)) func(string) error {
+	return func(value string) error {
+		if value == "" {
+			return nil
+		}
+		_, err := parse(value)
+		return err
+	}
+}
+
+func checkOneOf(allowed ...string) func(string) error {
+	return func(value string) error {
+		for _, a := range allowed {
+			if value == a {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(allowed, ", "))
+	}
+}
+
+type intValue struct {
+	p        *int
+	min, max int
+}
+
+func (v *intValue) Set(value string) error {
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("not a number")
+	}
+	if parsed < v.min || (v.max > 0 && parsed > v.max) {
+		if v.max > 0 {
+			return fmt.Errorf("must be between %d and %d", v.min, v.max)
+		}
+		return fmt.Errorf("must be at least %d", v.min)
+	}
+	*v.p = parsed
+	return nil
+}
+
+func (v *intValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strconv.Itoa(*v.p)
+}
+
+func (v *intValue) Get() interface{} { return *v.p }
+
+type int64Value struct {
+	p   *int64
+	min int64
+}
+
+func (v *int64Value) Set(value string) error {
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("not a number")
+	}
+	if parsed < v.min {
+		return fmt.Errorf("must be at least %d", v.min)
+	}
+	*v.p = parsed
+	return nil
+}
+
+func (v *int64Value) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strconv.FormatInt(*v.p, 10)
+}
+
+func (v *int64Value) Get() interface{} { return *v.p }
+
+type durationValue struct {
+	p *time.Duration
+	// positive rejects 0 for durations where it has no meaning
+	positive bool
+}
+
+func (v *durationValue) Set(value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("not a duration such as 30s or 5m")
+	}
+	if parsed < 0 || (v.positive && parsed == 0) {
+		if v.positive {
+			return fmt.Errorf("must be positive")
+		}
+		return fmt.Errorf("must not be negative")
+	}
+	*v.p = parsed
+	return nil
+}
+
+func (v *durationValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return formatDuration(*v.p)
+}
+
+func (v *durationValue) Get() interface{} { return v.String() }
+
+// formatDuration drops the zero units time.Duration.String adds, so 5m0s
+// reads 5m
+func formatDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+type boolValue struct {
+	p *bool
+}
+
+func (v *boolValue) Set(value string) error {
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("must be true or false")
+	}
+	*v.p = parsed
+	return nil
+}
+
+func (v *boolValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strconv.FormatBool(*v.p)
+}
+
+func (v *boolValue) Get() interface{} { return *v.p }
+
+func (v *boolValue) IsBoolFlag() bool { return true }
+
+// listValue is a comma-separated list. A repeated list collects one item
+// per flag instead, since its items may contain commas.
+type listValue struct {
+	p        *[]string
+	repeated bool
+}
+
+func (v *listValue) Set(value string) error {
+	if v.repeated {
+		*v.p = append(*v.p, value)
+		return nil
+	}
+	*v.p = splitList(value)
+	return nil
+}
+
+func (v *listValue) setAll(items []string) {
+	*v.p = append([]string(nil), items...)
+}
+
+func (v *listValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strings.Join(*v.p, ",")
+}
+
+func (v *listValue) Get() interface{} {
+	if *v.p == nil {
+		return []string{}
+	}
+	return *v.p
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// env returns a getenv backed by vars
+func env(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, "gateway.json", `{
+  "port": 9000,
+  "sessionMode": "isolated",
+  "maxSessions": 10,
+  "restartBackoff": "2s",
+  "toolsAllow": ["search", "fetch"],
+  "stdio": ["node", "server.js"]
+}`)
+	cfg, err := loadConfig([]string{"--config", path, "--max-sessions", "20", "--restart-backoff=3s"}, env(map[string]string{"SUPER_GATEWAY_MAX_SESSIONS": "30"}))
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if cfg.Port != 9000 || cfg.SessionMode != sessionModeIsolated {
+		t.Errorf("file options = %d %s, want 9000 isolated", cfg.Port, cfg.SessionMode)
+	}
+	if cfg.MaxSessions != 30 {
+		t.Errorf("max sessions = %d, want the env value 30", cfg.MaxSessions)
+	}
+	if cfg.RestartBackoff != 3*time.Second {
+		t.Errorf("restart backoff = %s, want the flag value 3s", cfg.RestartBackoff)
+	}
+	if strings.Join(cfg.ToolsAllow, ",") != "search,fetch" || strings.Join(cfg.Stdio, " ") != "node server.js" {
+		t.Errorf("lists = %v %v", cfg.ToolsAllow, cfg.Stdio)
+	}
+	if cfg.ResponseTimeout != defaultResponseTimeout || cfg.healthPort() != 9001 {
+		t.Errorf("defaults = %s %d", cfg.ResponseTimeout, cfg.healthPort())
+	}
+}
+
+func TestLoadConfigYAML(t *testing.T) {
+	path := writeConfigFile(t, "gateway.yaml", `# Gateway for the search server
+port: 9000
+sessionMode: isolated   # one server per session
+logPayloads: true
+restartBackoff: 2s
+toolsAllow: [search, fetch]
+redactPaths:
+  - params.arguments.password
+  - "params.arguments.api_key"
+stdio:
+  - node
+  - server.js
+  - --greeting=hello world
+`)
+	cfg, err := loadConfig([]string{"--config", path}, env(nil))
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if cfg.Port != 9000 || cfg.SessionMode != sessionModeIsolated || !cfg.LogPayloads || cfg.RestartBackoff != 2*time.Second {
+		t.Errorf("scalars = %d %s %t %s", cfg.Port, cfg.SessionMode, cfg.LogPayloads, cfg.RestartBackoff)
+	}
+	if strings.Join(cfg.ToolsAllow, ",") != "search,fetch" || strings.Join(cfg.RedactPaths, ",") != "params.arguments.password,params.arguments.api_key" {
+		t.Errorf("lists = %v %v", cfg.ToolsAllow, cfg.RedactPaths)
+	}
+	if strings.Join(cfg.Stdio, "|") != "node|server.js|--greeting=hello world" {
+		t.Errorf("stdio = %q", cfg.Stdio)
+	}
+}
+
+func TestLoadConfigIgnoresUnprefixedEnv(t *testing.T) {
+	// AUTH_TOKENS and the like belong to the MCP server sharing the environment
+	cfg, err := loadConfig([]string{"--stdio", "x"}, env(map[string]string{"AUTH_TOKENS": "t", "LOG_LEVEL": "debug"}))
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if len(cfg.AuthTokens) != 0 || cfg.LogLevel != "info" || len(cfg.warnings) != 0 {
+		t.Fatalf("auth tokens %v, log level %s, warnings %v, want defaults", cfg.AuthTokens, cfg.LogLevel, cfg.warnings)
+	}
+}
+
+func TestLoadConfigLegacyEnv(t *testing.T) {
+	for name, tc := range map[string]struct {
+		env   map[string]string
+		check func(cfg Config) bool
+	}{
+		"PORT overrides the flag":       {map[string]string{"PORT": "9000"}, func(cfg Config) bool { return cfg.Port == 9000 }},
+		"prefixed name wins":            {map[string]string{"PORT": "9000", "SUPER_GATEWAY_PORT": "9100"}, func(cfg Config) bool { return cfg.Port == 9100 }},
+		"TRANSPORT":                     {map[string]string{"TRANSPORT": "http-stream"}, func(cfg Config) bool { return cfg.Transport == transportHTTPStream }},
+		"MCP_RESPONSE_TIMEOUT seconds":  {map[string]string{"MCP_RESPONSE_TIMEOUT": "90"}, func(cfg Config) bool { return cfg.ResponseTimeout == 90*time.Second }},
+		"MCP_RESPONSE_TIMEOUT duration": {map[string]string{"MCP_RESPONSE_TIMEOUT": "2m"}, func(cfg Config) bool { return cfg.ResponseTimeout == 2*time.Minute }},
+		"SKIP_READINESS_CHECK":          {map[string]string{"SKIP_READINESS_CHECK": "true"}, func(cfg Config) bool { return cfg.SkipReadinessCheck }},
+		"SKIP_READINESS_CHECK not true": {map[string]string{"SKIP_READINESS_CHECK": "1"}, func(cfg Config) bool { return !cfg.SkipReadinessCheck }},
+	} {
+		cfg, err := loadConfig([]string{"--port", "8500", "--stdio", "x"}, env(tc.env))
+		if err != nil {
+			t.Errorf("%s: loadConfig: %v", name, err)
+			continue
+		}
+		if !tc.check(cfg) {
+			t.Errorf("%s: config = %+v", name, cfg)
+		}
+		if len(cfg.warnings) != 1 || !strings.Contains(cfg.warnings[0], "deprecated") {
+			t.Errorf("%s: warnings = %v, want a deprecation warning", name, cfg.warnings)
+		}
+	}
+
+	if _, err := loadConfig([]string{"--stdio", "x"}, env(map[string]string{"PORT": "http"})); err == nil || !strings.Contains(err.Error(), "PORT") {
+		t.Errorf("bad PORT: error = %v", err)
+	}
+}
+
+func TestChildEnvDropsGatewayVariables(t *testing.T) {
+	got := childEnv([]string{"PATH=/bin", "SUPER_GATEWAY_AUTH_TOKENS=secret", "SUPER_GATEWAY_AUTH_JWKS=/keys.json", "API_KEY=server", "SUPER_GATEWAY_CONFIG_FILE=gateway.json"})
+	if strings.Join(got, " ") != "PATH=/bin API_KEY=server" {
+		t.Fatalf("childEnv = %v", got)
+	}
+}
+
+func TestLoadConfigStdio(t *testing.T) {
+	// Flags after --stdio belong to the MCP server
+	cfg, err := loadConfig([]string{"--port", "9000", "--stdio", "server", "--port", "1"}, env(nil))
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if cfg.Port != 9000 || strings.Join(cfg.Stdio, " ") != "server --port 1" {
+		t.Fatalf("port %d, stdio %v", cfg.Port, cfg.Stdio)
+	}
+
+	// A command string is split like a shell command line
+	cfg, err = loadConfig(nil, env(map[string]string{"SUPER_GATEWAY_STDIO_COMMAND": `node server.js --name "My Server" 'a b'`}))
+	if err != nil {
+		t.Fatalf("STDIO_COMMAND: %v", err)
+	}
+	g := NewGateway()
+	if err := g.prepareCommand(cfg.Stdio); err != nil {
+		t.Fatalf("prepareCommand: %v", err)
+	}
+	if strings.Join(g.cmdParts, "|") != "node|server.js|--name|My Server|a b" {
+		t.Fatalf("command = %q", g.cmdParts)
+	}
+
+	// A replayed MCP server has no command
+	if _, err := loadConfig([]string{"--replay", "cassette.jsonl"}, env(nil)); err != nil {
+		t.Fatalf("--replay without --stdio: %v", err)
+	}
+}
+
+func TestLoadConfigInlineJSON(t *testing.T) {
+	cfg, err := loadConfig([]string{"--config-json", `{"port":80,"transport":"http-stream","logPayloads":true,"stdio":["node","server.js"]}`}, env(nil))
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if cfg.Port != 80 || cfg.Transport != transportHTTPStream || !cfg.LogPayloads || len(cfg.Stdio) != 2 {
+		t.Fatalf("config = %+v", cfg)
+	}
+
+	// --config is always a path
+	if _, err := loadConfig([]string{"--config", `{"port":80}`, "--stdio", "x"}, env(nil)); err == nil || !strings.Contains(err.Error(), "failed to read config file") {
+		t.Fatalf("--config with JSON: error = %v, want it read as a path", err)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		args []string
+		env  map[string]string
+		want string
+	}{
+		"bad flag value":  {[]string{"--session-idle-timeout", "soon", "--stdio", "x"}, nil, "--session-idle-timeout"},
+		"bad env value":   {[]string{"--stdio", "x"}, map[string]string{"SUPER_GATEWAY_TRANSPORT": "carrier-pigeon"}, "SUPER_GATEWAY_TRANSPORT"},
+		"negative":        {[]string{"--max-restarts", "-1", "--stdio", "x"}, nil, "at least 0"},
+		"unknown flag":    {[]string{"--bogus", "--stdio", "x"}, nil, "bogus"},
+		"positional":      {[]string{"server.js"}, nil, "unexpected argument"},
+		"missing command": {nil, nil, "--stdio"},
+		"backoff":         {[]string{"--restart-backoff", "1m", "--max-restart-backoff", "1s", "--stdio", "x"}, nil, "shorter than"},
+		"upstream":        {[]string{"--http-upstream", "http://127.0.0.1:8081/mcp", "--stdio", "x"}, nil, "requires --transport http-stream"},
+		"upstream limits": {[]string{"--http-upstream", "http://127.0.0.1:8081/mcp", "--transport", "http-stream", "--session-rate-limit", "2", "--stdio", "x"}, nil, "rate limits"},
+		"cache":           {[]string{"--initialize-mode", "cache", "--session-mode", "isolated", "--stdio", "x"}, nil, "shared stdio"},
+		"unknown key":     {[]string{"--config-json", `{"prot":1}`, "--stdio", "x"}, nil, `unknown option "prot"`},
+		"list for scalar": {[]string{"--config-json", `{"port":[1,2]}`, "--stdio", "x"}, nil, "single value"},
+		"two configs":     {[]string{"--config", "gateway.json", "--stdio", "x"}, map[string]string{"SUPER_GATEWAY_CONFIG_JSON": "{}"}, "cannot be combined"},
+		"record replay":   {[]string{"--record", "a.jsonl", "--replay", "b.jsonl"}, nil, "cannot be combined"},
+		"unquoted stdio":  {nil, map[string]string{"SUPER_GATEWAY_STDIO_COMMAND": `node server.js "My Server`}, "unterminated double quote"},
+	} {
+		_, err := loadConfig(tc.args, env(tc.env))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: error = %v, want %q", name, err, tc.want)
+		}
+	}
+
+	yaml := writeConfigFile(t, "gateway.yaml", "port: [9000\n")
+	if _, err := loadConfig([]string{"--config", yaml, "--stdio", "x"}, env(nil)); err == nil || !strings.Contains(err.Error(), "invalid YAML config") {
+		t.Errorf("broken YAML config: error = %v", err)
+	}
+	nested := writeConfigFile(t, "gateway.yml", "tools:\n  allow: search\n")
+	if _, err := loadConfig([]string{"--config", nested, "--stdio", "x"}, env(nil)); err == nil || !strings.Contains(err.Error(), "values must be") {
+		t.Errorf("nested YAML config: error = %v", err)
+	}
+
+	if _, err := loadConfig([]string{"--help"}, env(nil)); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("--help: error = %v, want flag.ErrHelp", err)
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	cfg, err := loadConfig([]string{"--print-config", "--auth-tokens", "secret1,secret2", "--header", "X-Key: a,b", "--stdio", "node", "server.js"}, env(map[string]string{"SUPER_GATEWAY_CONNECT_HEADERS": "X-Other: c"}))
+	if !errors.Is(err, errPrintConfig) {
+		t.Fatalf("loadConfig = %v, want errPrintConfig", err)
+	}
+	if strings.Join(cfg.Headers, "|") != "X-Key: a,b|X-Other: c" {
+		t.Errorf("headers = %q", cfg.Headers)
+	}
+
+	var buf bytes.Buffer
+	if err := cfg.writeJSON(&buf); err != nil {
+		t.Fatalf("writeJSON: %v", err)
+	}
+	if strings.Contains(buf.String(), "secret1") || !strings.Contains(buf.String(), `"authTokens": "[REDACTED]"`) {
+		t.Errorf("secrets not masked:\n%s", buf.String())
+	}
+
+	// The printed config loads back into the same options
+	var printed map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &printed); err != nil {
+		t.Fatalf("printed config is not JSON: %v", err)
+	}
+	if printed["responseTimeout"] != "5m" || printed["port"] != float64(defaultPort) {
+		t.Errorf("printed = %v", printed)
+	}
+	delete(printed, "authTokens")
+	delete(printed, "headers")
+	roundTrip, _ := json.Marshal(printed)
+	reloaded, err := loadConfig([]string{"--config-json", string(roundTrip)}, env(nil))
+	if err != nil {
+		t.Fatalf("reload printed config: %v", err)
+	}
+	if reloaded.ResponseTimeout != cfg.ResponseTimeout || strings.Join(reloaded.Stdio, " ") != "node server.js" {
+		t.Errorf("reloaded = %+v", reloaded)
+	}
+}
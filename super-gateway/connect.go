@@ -0,0 +1,724 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Client mode (--connect) bridges a local stdio MCP host to a remote MCP
+// server over Streamable HTTP or WebSocket
+
+const (
+	connectInitialBackoff = 500 * time.Millisecond
+	connectMaxBackoff     = 30 * time.Second
+	// connectPostAttempts bounds the attempts to deliver a message to a
+	// server that cannot be reached
+	connectPostAttempts = 5
+	// reinitializeID is the JSON-RPC ID of the initialize request replayed to
+	// set up a new session after the previous one was lost
+	reinitializeID = `"connect-reinitialize"`
+)
+
+// connectConfig configures client mode
+type connectConfig struct {
+	URL string
+	// Headers are added to every request, e.g. for authentication
+	Headers http.Header
+}
+
+// parseConnectHeaders parses headers written as "Name: value"
+func parseConnectHeaders(values []string) (http.Header, error) {
+	headers := make(http.Header)
+	for _, value := range values {
+		name, headerValue, ok := strings.Cut(value, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid header %q: want Name: value", value)
+		}
+		headers.Add(name, strings.TrimSpace(headerValue))
+	}
+	return headers, nil
+}
+
+// backoff doubles the delay between reconnection attempts up to a maximum
+type backoff struct {
+	delay time.Duration
+}
+
+func (b *backoff) next() time.Duration {
+	if b.delay == 0 {
+		b.delay = connectInitialBackoff
+	} else {
+		b.delay = min(2*b.delay, connectMaxBackoff)
+	}
+	return b.delay
+}
+
+func (b *backoff) reset() {
+	b.delay = 0
+}
+
+// sleepContext waits for d and reports false if ctx ends first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// isDialError reports whether err means the server could not be reached, so
+// the request was never sent and may safely be retried
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// connectClient is the transport-independent part of client mode
+type connectClient struct {
+	cfg   connectConfig
+	out   io.Writer
+	outMu sync.Mutex
+
+	initMu sync.Mutex
+	// initialize is the host's initialize request, replayed when a new
+	// session has to be set up behind the host's back
+	initialize *JSONRPCMessage
+}
+
+// runConnect bridges the MCP host on in and out to the server at cfg.URL
+// until in is closed or ctx ends
+func runConnect(ctx context.Context, cfg connectConfig, in io.Reader, out io.Writer) error {
+	target, err := url.Parse(cfg.URL)
+	if err != nil {
+		return fmt.Errorf("invalid connect URL: %w", err)
+	}
+	c := &connectClient{cfg: cfg, out: out}
+	lines := readLines(in)
+
+	switch target.Scheme {
+	case "http", "https":
+		return c.runHTTP(ctx, lines)
+	case "ws", "wss":
+		return c.runWebSocket(ctx, lines)
+	default:
+		return fmt.Errorf("connect URL must use http, https, ws or wss, got %q", target.Scheme)
+	}
+}
+
+// readLines reads the host's messages, one per line
+func readLines(in io.Reader) <-chan []byte {
+	lines := make(chan []byte)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(make([]byte, 64*1024), maxScannerTokenSize)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			lines <- append([]byte(nil), line...)
+		}
+		if err := scanner.Err(); err != nil {
+			log.Printf("Error reading stdin: %v", err)
+		}
+	}()
+	return lines
+}
+
+// emit writes a message to the host on a line of its own
+func (c *connectClient) emit(data []byte) {
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, data); err != nil {
+		log.Printf("Dropping invalid message from remote MCP server: %v", err)
+		return
+	}
+	compact.WriteByte('\n')
+
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	if _, err := c.out.Write(compact.Bytes()); err != nil {
+		log.Printf("Error writing to stdout: %v", err)
+	}
+}
+
+// decodeLine decodes the messages of a line, which may hold a batch
+func decodeLine(line []byte) []JSONRPCMessage {
+	if isBatch(line) {
+		var msgs []JSONRPCMessage
+		_ = json.Unmarshal(line, &msgs)
+		return msgs
+	}
+	var msg JSONRPCMessage
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return nil
+	}
+	return []JSONRPCMessage{msg}
+}
+
+// isRequest reports whether msg expects a reply
+func isRequest(msg JSONRPCMessage) bool {
+	return msg.Method != "" && msg.ID != nil
+}
+
+// rememberInitialize keeps the host's initialize request for later replay
+func (c *connectClient) rememberInitialize(msgs []JSONRPCMessage) bool {
+	for _, msg := range msgs {
+		if isRequest(msg) && strings.EqualFold(msg.Method, "initialize") {
+			c.initMu.Lock()
+			c.initialize = &msg
+			c.initMu.Unlock()
+			return true
+		}
+	}
+	return false
+}
+
+// reinitializeMessages returns the host's initialize request under the
+// gateway's own ID followed by the initialized notification
+func (c *connectClient) reinitializeMessages() ([][]byte, bool) {
+	c.initMu.Lock()
+	defer c.initMu.Unlock()
+	if c.initialize == nil {
+		return nil, false
+	}
+	msg := *c.initialize
+	msg.ID = json.RawMessage(reinitializeID)
+	initialize, err := json.Marshal(msg)
+	if err != nil {
+		return nil, false
+	}
+	return [][]byte{initialize, []byte(`{"jsonrpc":"2.0","method":"notifications/initialized"}`)}, true
+}
+
+// failRequests answers the requests of a line that could not be delivered
+// with an error, so the host is not left waiting
+func (c *connectClient) failRequests(line []byte, reason string) {
+	var replies []json.RawMessage
+	for _, msg := range decodeLine(line) {
+		if isRequest(msg) {
+			replies = append(replies, newErrorResponse(msg.ID, jsonRPCInternalError, reason))
+		}
+	}
+	if len(replies) == 0 {
+		return
+	}
+	if !isBatch(line) {
+		c.emit(replies[0])
+		return
+	}
+	data, err := json.Marshal(replies)
+	if err == nil {
+		c.emit(data)
+	}
+}
+
+// httpBridge speaks Streamable HTTP to the remote server
+type httpBridge struct {
+	*connectClient
+	ctx    context.Context
+	client *http.Client
+
+	mu              sync.Mutex
+	sessionID       string
+	protocolVersion string
+	// streaming is set while the standalone SSE stream of streamSession is open
+	streaming     bool
+	streamSession string
+
+	// reinitMu makes concurrent requests that hit an expired session set up
+	// a single new one
+	reinitMu sync.Mutex
+	inflight sync.WaitGroup
+}
+
+func (c *connectClient) runHTTP(ctx context.Context, lines <-chan []byte) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	b := &httpBridge{connectClient: c, ctx: ctx, client: &http.Client{}}
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				b.inflight.Wait()
+				b.closeSession()
+				return nil
+			}
+			msgs := decodeLine(line)
+			// initialize runs on its own; requests run concurrently so that
+			// slow tool calls do not hold up the rest
+			requests := false
+			for _, msg := range msgs {
+				requests = requests || isRequest(msg)
+			}
+			if c.rememberInitialize(msgs) || !requests {
+				b.post(line, msgs)
+				continue
+			}
+			b.inflight.Add(1)
+			go func() {
+				defer b.inflight.Done()
+				b.post(line, msgs)
+			}()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// newRequest builds a request to the remote server carrying the configured
+// headers and the current session, which it also returns
+func (b *httpBridge) newRequest(method string, body []byte) (*http.Request, string, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(b.ctx, method, b.cfg.URL, reader)
+	if err != nil {
+		return nil, "", err
+	}
+	for name, values := range b.cfg.Headers {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json, text/event-stream")
+
+	b.mu.Lock()
+	sessionID, protocolVersion := b.sessionID, b.protocolVersion
+	b.mu.Unlock()
+	if sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", sessionID)
+	}
+	if protocolVersion != "" {
+		req.Header.Set("MCP-Protocol-Version", protocolVersion)
+	}
+	return req, sessionID, nil
+}
+
+func (b *httpBridge) currentSession() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sessionID
+}
+
+// post delivers a line from the host and passes the reply back. A session
+// the server no longer knows is replaced by a new one, once.
+func (b *httpBridge) post(line []byte, msgs []JSONRPCMessage) {
+	initialize := len(msgs) == 1 && strings.EqualFold(msgs[0].Method, "initialize")
+	var delay backoff
+	reinitialized := false
+	for attempt := 1; ; attempt++ {
+		req, sessionID, err := b.newRequest(http.MethodPost, line)
+		if err != nil {
+			b.failRequests(line, err.Error())
+			return
+		}
+		resp, err := b.client.Do(req)
+		if err != nil {
+			if isDialError(err) && attempt < connectPostAttempts {
+				log.Printf("Failed to reach remote MCP server, retrying: %v", err)
+				if sleepContext(b.ctx, delay.next()) {
+					continue
+				}
+			}
+			b.failRequests(line, fmt.Sprintf("Failed to reach remote MCP server: %v", err))
+			return
+		}
+
+		if resp.StatusCode == http.StatusNotFound && sessionID != "" && !initialize && !reinitialized {
+			_ = resp.Body.Close()
+			log.Printf("Session %s expired, starting a new one", sessionID)
+			if err := b.reinitialize(sessionID); err != nil {
+				log.Printf("Failed to start a new session: %v", err)
+				b.failRequests(line, "Session expired and could not be renewed")
+				return
+			}
+			reinitialized = true
+			continue
+		}
+
+		b.handleResponse(resp, line, initialize)
+		return
+	}
+}
+
+// handleResponse passes the messages of a POST response to the host, whether
+// they come as JSON or as an SSE stream
+func (b *httpBridge) handleResponse(resp *http.Response, line []byte, initialize bool) {
+	defer resp.Body.Close()
+	if sessionID := resp.Header.Get("Mcp-Session-Id"); sessionID != "" {
+		b.mu.Lock()
+		b.sessionID = sessionID
+		b.mu.Unlock()
+	}
+
+	replied := false
+	deliver := func(data []byte) {
+		if initialize {
+			b.initialized(data)
+		}
+		for _, msg := range decodeLine(data) {
+			replied = replied || (msg.Method == "" && msg.ID != nil)
+		}
+		b.emit(data)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "text/event-stream":
+		err := readSSE(resp.Body, func(id, event string, data []byte) {
+			if event == "message" {
+				deliver(data)
+			}
+		})
+		if !replied && b.ctx.Err() == nil {
+			log.Printf("Response stream from remote MCP server ended before the reply: %v", err)
+			b.failRequests(line, "Response stream from remote MCP server ended before the reply")
+		}
+	case mediaType == "application/json":
+		// JSON-RPC errors may come with an error status, e.g. 429
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxScannerTokenSize))
+		if err != nil || !json.Valid(body) {
+			b.failRequests(line, fmt.Sprintf("Invalid response from remote MCP server (%s)", resp.Status))
+			return
+		}
+		deliver(body)
+	case resp.StatusCode >= http.StatusBadRequest:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		log.Printf("Remote MCP server returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		b.failRequests(line, fmt.Sprintf("Remote MCP server returned %s", resp.Status))
+	}
+}
+
+// initialized records the protocol version of a successful initialize reply
+// and opens the session's standalone stream
+func (b *httpBridge) initialized(data []byte) {
+	var reply struct {
+		Result struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(data, &reply); err != nil || reply.Result.ProtocolVersion == "" {
+		return
+	}
+	b.mu.Lock()
+	b.protocolVersion = reply.Result.ProtocolVersion
+	b.mu.Unlock()
+	b.openStream()
+}
+
+// reinitialize replaces the expired session stale by replaying the host's
+// initialize request. The reply is not passed on; the host already has one.
+func (b *httpBridge) reinitialize(stale string) error {
+	b.reinitMu.Lock()
+	defer b.reinitMu.Unlock()
+	if b.currentSession() != stale {
+		// Another request already set up a new session
+		return nil
+	}
+	msgs, ok := b.reinitializeMessages()
+	if !ok {
+		return errors.New("no initialize request to replay")
+	}
+
+	b.mu.Lock()
+	b.sessionID = ""
+	b.mu.Unlock()
+	for _, body := range msgs {
+		req, _, err := b.newRequest(http.MethodPost, body)
+		if err != nil {
+			return err
+		}
+		resp, err := b.client.Do(req)
+		if err != nil {
+			return err
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		if resp.StatusCode >= http.StatusBadRequest {
+			return fmt.Errorf("remote MCP server returned %s", resp.Status)
+		}
+		if sessionID := resp.Header.Get("Mcp-Session-Id"); sessionID != "" {
+			b.mu.Lock()
+			b.sessionID = sessionID
+			b.mu.Unlock()
+		}
+	}
+	b.openStream()
+	return nil
+}
+
+// openStream starts reading the standalone SSE stream (GET) of the current
+// session unless it is already open
+func (b *httpBridge) openStream() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.streaming && b.streamSession == b.sessionID {
+		return
+	}
+	b.streaming = true
+	b.streamSession = b.sessionID
+	go b.stream(b.sessionID)
+}
+
+// stream passes the server-initiated messages of a session to the host,
+// reconnecting with backoff and resuming after the last event seen, until
+// the session changes or the server says it has no such stream
+func (b *httpBridge) stream(sessionID string) {
+	defer func() {
+		b.mu.Lock()
+		if b.streamSession == sessionID {
+			b.streaming = false
+		}
+		b.mu.Unlock()
+	}()
+
+	var delay backoff
+	var lastEventID string
+	for b.ctx.Err() == nil && b.currentSession() == sessionID {
+		req, _, err := b.newRequest(http.MethodGet, nil)
+		if err != nil {
+			return
+		}
+		req.Header.Set("Accept", "text/event-stream")
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := b.client.Do(req)
+		if err != nil {
+			if b.ctx.Err() == nil {
+				log.Printf("Failed to open SSE stream, retrying: %v", err)
+				sleepContext(b.ctx, delay.next())
+			}
+			continue
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusMethodNotAllowed:
+			_ = resp.Body.Close()
+			log.Printf("Remote MCP server offers no standalone SSE stream")
+			return
+		case resp.StatusCode == http.StatusNotFound:
+			// The session is gone; the next request sets up a new one
+			_ = resp.Body.Close()
+			return
+		case resp.StatusCode != http.StatusOK:
+			_ = resp.Body.Close()
+			log.Printf("Failed to open SSE stream: remote MCP server returned %s", resp.Status)
+			sleepContext(b.ctx, delay.next())
+			continue
+		}
+
+		delay.reset()
+		err = readSSE(resp.Body, func(id, event string, data []byte) {
+			if id != "" {
+				lastEventID = id
+			}
+			if event == "message" {
+				b.emit(data)
+			}
+		})
+		_ = resp.Body.Close()
+		if b.ctx.Err() == nil {
+			if err != nil {
+				log.Printf("SSE stream failed, reconnecting: %v", err)
+			} else {
+				log.Printf("SSE stream ended, reconnecting")
+			}
+			sleepContext(b.ctx, delay.next())
+		}
+	}
+}
+
+// closeSession ends the session on the server once the host is gone
+func (b *httpBridge) closeSession() {
+	if b.currentSession() == "" {
+		return
+	}
+	req, _, err := b.newRequest(http.MethodDelete, nil)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(b.ctx, 5*time.Second)
+	defer cancel()
+	resp, err := b.client.Do(req.WithContext(ctx))
+	if err != nil {
+		log.Printf("Failed to end session: %v", err)
+		return
+	}
+	_ = resp.Body.Close()
+}
+
+// readSSE calls fn for every event of an SSE stream until it ends, returning
+// nil when the server closed it. Events without a type are "message" events.
+func readSSE(r io.Reader, fn func(id, event string, data []byte)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxScannerTokenSize)
+	var id, event string
+	var data []byte
+	for scanner.Scan() {
+		line := scanner.Text()
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch {
+		case line == "":
+			if data != nil {
+				if event == "" {
+					event = "message"
+				}
+				fn(id, event, data)
+			}
+			event, data = "", nil
+		case field == "":
+			// Comment, e.g. a keepalive ping
+		case field == "id":
+			id = value
+		case field == "event":
+			event = value
+		case field == "data":
+			if data != nil {
+				data = append(data, '\n')
+			}
+			data = append(data, value...)
+		}
+	}
+	return scanner.Err()
+}
+
+func (c *connectClient) runWebSocket(ctx context.Context, lines <-chan []byte) error {
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 30 * time.Second,
+		Subprotocols:     []string{"mcp"},
+	}
+	var delay backoff
+	reconnecting := false
+	for {
+		conn, resp, err := dialer.DialContext(ctx, c.cfg.URL, c.cfg.Headers.Clone())
+		if err != nil {
+			if resp != nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+				return fmt.Errorf("remote MCP server rejected the connection: %s", resp.Status)
+			}
+			log.Printf("Failed to connect to remote MCP server, retrying: %v", err)
+			if !sleepContext(ctx, delay.next()) {
+				return ctx.Err()
+			}
+			continue
+		}
+		delay.reset()
+		log.Printf("Connected to remote MCP server")
+
+		done, err := c.bridgeWebSocket(ctx, conn, lines, reconnecting)
+		if done {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("Connection to remote MCP server lost, reconnecting: %v", err)
+		reconnecting = true
+	}
+}
+
+// bridgeWebSocket relays messages over one connection until it fails, in
+// which case the requests awaiting a reply are answered with an error, or
+// until the host is gone, in which case it reports true. A new connection
+// replays the host's initialize request first.
+func (c *connectClient) bridgeWebSocket(ctx context.Context, conn *websocket.Conn, lines <-chan []byte, reinitialize bool) (bool, error) {
+	defer func() { _ = conn.Close() }()
+	conn.SetReadLimit(maxScannerTokenSize)
+
+	var pendingMu sync.Mutex
+	pending := make(map[string]json.RawMessage)
+	failPending := func() {
+		pendingMu.Lock()
+		defer pendingMu.Unlock()
+		for key, id := range pending {
+			c.emit(newErrorResponse(id, jsonRPCInternalError, "Connection to remote MCP server lost"))
+			delete(pending, key)
+		}
+	}
+
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			msgs := decodeLine(data)
+			if len(msgs) == 1 && string(msgs[0].ID) == reinitializeID {
+				continue
+			}
+			pendingMu.Lock()
+			for _, msg := range msgs {
+				if msg.Method == "" && msg.ID != nil {
+					delete(pending, string(msg.ID))
+				}
+			}
+			pendingMu.Unlock()
+			c.emit(data)
+		}
+	}()
+
+	if reinitialize {
+		if msgs, ok := c.reinitializeMessages(); ok {
+			for _, msg := range msgs {
+				if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+					return false, err
+				}
+			}
+		}
+	}
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return true, nil
+			}
+			msgs := decodeLine(line)
+			c.rememberInitialize(msgs)
+			pendingMu.Lock()
+			for _, msg := range msgs {
+				if isRequest(msg) {
+					pending[string(msg.ID)] = msg.ID
+				}
+			}
+			pendingMu.Unlock()
+			if err := conn.WriteMessage(websocket.TextMessage, line); err != nil {
+				failPending()
+				return false, err
+			}
+		case err := <-readErr:
+			failPending()
+			return false, err
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+}
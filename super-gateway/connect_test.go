@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// connectHost plays the local MCP host of a client mode bridge
+type connectHost struct {
+	in   *io.PipeWriter
+	out  *bufio.Reader
+	done chan error
+}
+
+func startConnect(t *testing.T, cfg connectConfig) *connectHost {
+	t.Helper()
+	inReader, inWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	host := &connectHost{in: inWriter, out: bufio.NewReader(outReader), done: make(chan error, 1)}
+	go func() {
+		host.done <- runConnect(ctx, cfg, inReader, outWriter)
+		_ = outWriter.Close()
+	}()
+	t.Cleanup(func() {
+		cancel()
+		_ = inWriter.Close()
+	})
+	return host
+}
+
+func (h *connectHost) send(t *testing.T, line string) {
+	t.Helper()
+	if _, err := io.WriteString(h.in, line+"\n"); err != nil {
+		t.Fatalf("write to bridge: %v", err)
+	}
+}
+
+func (h *connectHost) receive(t *testing.T) JSONRPCMessage {
+	t.Helper()
+	lines := make(chan string, 1)
+	go func() {
+		line, _ := h.out.ReadString('\n')
+		lines <- line
+	}()
+	select {
+	case line := <-lines:
+		var msg JSONRPCMessage
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			t.Fatalf("bridge wrote %q: %v", line, err)
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message from bridge")
+		return JSONRPCMessage{}
+	}
+}
+
+func TestConnectHTTPRenewsExpiredSession(t *testing.T) {
+	var initializes atomic.Int32
+	reply, _ := handshakeReply("2025-06-18")
+	g := newTestGateway(t, func(msg JSONRPCMessage) []string {
+		if msg.Method == "initialize" {
+			initializes.Add(1)
+		}
+		return reply(msg)
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "secret" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mcpHandler(g)(w, r)
+	}))
+	t.Cleanup(server.Close)
+	headers, err := parseConnectHeaders([]string{"X-API-Key: secret"})
+	if err != nil {
+		t.Fatalf("parseConnectHeaders: %v", err)
+	}
+	host := startConnect(t, connectConfig{URL: server.URL, Headers: headers})
+
+	host.send(t, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-06-18"}}`)
+	if reply := host.receive(t); string(reply.ID) != "1" || !strings.Contains(string(reply.Result), "2025-06-18") {
+		t.Fatalf("initialize reply = %+v", reply)
+	}
+	host.send(t, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	host.send(t, `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`)
+	if reply := host.receive(t); string(reply.ID) != "2" {
+		t.Fatalf("tools/list reply = %+v", reply)
+	}
+
+	g.sessions.mu.Lock()
+	var sessionID string
+	for id := range g.sessions.sessions {
+		sessionID = id
+	}
+	g.sessions.mu.Unlock()
+	g.terminateSession(sessionID)
+
+	host.send(t, `{"jsonrpc":"2.0","id":"three","method":"tools/list"}`)
+	if reply := host.receive(t); string(reply.ID) != `"three"` || reply.Error != nil {
+		t.Fatalf("reply after session expiry = %+v", reply)
+	}
+	if got := initializes.Load(); got != 2 {
+		t.Fatalf("child saw %d initialize requests, want 2", got)
+	}
+
+	// Closing stdin ends the session
+	_ = host.in.Close()
+	select {
+	case err := <-host.done:
+		if err != nil {
+			t.Fatalf("runConnect: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("bridge did not stop after stdin closed")
+	}
+	if n := g.sessions.Len(); n != 0 {
+		t.Fatalf("%d sessions left, want 0", n)
+	}
+}
+
+func TestConnectWebSocketReconnects(t *testing.T) {
+	var initializes atomic.Int32
+	reply, _ := handshakeReply("2025-06-18")
+	g := newTestGateway(t, func(msg JSONRPCMessage) []string {
+		if msg.Method == "initialize" {
+			initializes.Add(1)
+		}
+		return reply(msg)
+	})
+	server := httptest.NewServer(http.HandlerFunc(g.HandleWebSocket))
+	t.Cleanup(server.Close)
+	host := startConnect(t, connectConfig{URL: "ws" + strings.TrimPrefix(server.URL, "http")})
+
+	host.send(t, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`)
+	if reply := host.receive(t); string(reply.ID) != "1" {
+		t.Fatalf("initialize reply = %+v", reply)
+	}
+
+	// Drop the connection from the server side
+	g.clientsMu.RLock()
+	for _, client := range g.clients {
+		_ = client.Conn.Close()
+	}
+	g.clientsMu.RUnlock()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for initializes.Load() != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("initialize not replayed after reconnecting")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	host.send(t, `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`)
+	if reply := host.receive(t); string(reply.ID) != "2" || reply.Error != nil {
+		t.Fatalf("reply after reconnecting = %+v", reply)
+	}
+}
+
+func TestConnectAnswersFailedRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Unavailable", http.StatusBadGateway)
+	}))
+	defer server.Close()
+	host := startConnect(t, connectConfig{URL: server.URL})
+
+	host.send(t, `{"jsonrpc":"2.0","id":7,"method":"tools/list"}`)
+	reply := host.receive(t)
+	data, _ := json.Marshal(reply.Error)
+	if string(reply.ID) != "7" || !strings.Contains(string(data), fmt.Sprint(jsonRPCInternalError)) {
+		t.Fatalf("reply = %+v, want internal error", reply)
+	}
+}
+
+func TestReadSSE(t *testing.T) {
+	stream := ": ping\n\nid: 1\ndata: {\"a\":1}\n\nevent: endpoint\ndata: /messages\n\nid: 2\ndata: line1\ndata: line2\n\n"
+	var got []string
+	err := readSSE(strings.NewReader(stream), func(id, event string, data []byte) {
+		got = append(got, id+"|"+event+"|"+string(data))
+	})
+	want := []string{`1|message|{"a":1}`, "1|endpoint|/messages", "2|message|line1\nline2"}
+	if err != nil || strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("events = %q, %v, want %q", got, err, want)
+	}
+}
+
+func TestParseConnectHeaders(t *testing.T) {
+	headers, err := parseConnectHeaders([]string{"Authorization: Bearer abc", "X-Tenant:acme"})
+	if err != nil || headers.Get("Authorization") != "Bearer abc" || headers.Get("X-Tenant") != "acme" {
+		t.Fatalf("parseConnectHeaders = %v, %v", headers, err)
+	}
+	for _, value := range []string{"novalue", ": x", "Bad Name: x"} {
+		if _, err := parseConnectHeaders([]string{value}); err == nil {
+			t.Errorf("parseConnectHeaders(%q) accepted", value)
+		}
+	}
+}
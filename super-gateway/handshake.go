@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Ways a client's initialize request is handled in shared mode
+const (
+	// initializeForward sends every client's initialize to the MCP server
+	initializeForward = "forward"
+	// initializeCache answers it with the result of the gateway's own
+	// handshake, so the shared server is initialized exactly once
+	initializeCache = "cache"
+)
+
+// defaultProtocolVersions are the MCP protocol versions the gateway speaks,
+// most preferred first
+var defaultProtocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// handshakeConfig controls the initialize handshake with the MCP server and
+// the protocol versions accepted from clients
+type handshakeConfig struct {
+	// ProtocolVersions are the accepted versions; the first is requested in
+	// the gateway's own initialize
+	ProtocolVersions []string
+	InitializeMode   string
+	// Timeout is how long the MCP server may take to answer initialize
+	Timeout time.Duration
+	// Skip leaves the handshake to the first client
+	Skip bool
+}
+
+func defaultHandshakeConfig() handshakeConfig {
+	return handshakeConfig{ProtocolVersions: defaultProtocolVersions, InitializeMode: initializeForward, Timeout: defaultReadinessTimeout}
+}
+
+// supports reports whether version is one of the accepted protocol versions
+func (h handshakeConfig) supports(version string) bool {
+	for _, v := range h.ProtocolVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// initializeParams are the params of the gateway's own initialize request
+func (h handshakeConfig) initializeParams() json.RawMessage {
+	params, _ := json.Marshal(map[string]interface{}{
+		"protocolVersion": h.ProtocolVersions[0],
+		"capabilities":    map[string]interface{}{},
+		"clientInfo":      map[string]string{"name": "super-gateway", "version": "1.0.0"},
+	})
+	return params
+}
+
+// parseProtocolVersions parses a comma-separated list of protocol versions
+func parseProtocolVersions(value string) ([]string, error) {
+	var versions []string
+	for _, version := range strings.Split(value, ",") {
+		version = strings.TrimSpace(version)
+		if version == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", version); err != nil {
+			return nil, fmt.Errorf("invalid protocol version %q: must be a date like 2025-06-18", version)
+		}
+		versions = append(versions, version)
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("no protocol versions given")
+	}
+	return versions, nil
+}
+
+// parseInitializeMode validates an initialize mode name
+func parseInitializeMode(value string) (string, error) {
+	switch value {
+	case initializeForward, initializeCache:
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid initialize mode %q: must be 'forward' or 'cache'", value)
+}
+
+// cachedInitialize returns the shared MCP server's initialize result when
+// clients are answered from it, or nil when their initialize is forwarded
+func (g *Gateway) cachedInitialize() *initializeResult {
+	if g.handshake.InitializeMode != initializeCache || g.sessionMode == sessionModeIsolated {
+		return nil
+	}
+	child := g.sharedChild()
+	if child == nil {
+		return nil
+	}
+	// Without a handshake of the gateway's own there is nothing to answer
+	// with, and the server has to see the client's initialize after all
+	if info := child.initResult.Load(); info != nil && len(info.raw) > 0 {
+		return info
+	}
+	return nil
+}
+
+// answerInitialize replies to a client's initialize request from the cached
+// result and reports whether it did
+func (g *Gateway) answerInitialize(id int64, msg JSONRPCMessage) bool {
+	info := g.cachedInitialize()
+	if info == nil {
+		return false
+	}
+	g.routes.Describe(id, msg.Method, "", nil)
+	route, ok := g.routes.Resolve(id)
+	if !ok {
+		return true
+	}
+	g.deliverReply(route, JSONRPCMessage{JSONRPC: "2.0", Result: info.raw})
+	return true
+}
+
+// recordProtocolVersion stores the protocol version an initialize result
+// negotiated for clientID's session, against which the MCP-Protocol-Version
+// header of its later requests is checked
+func (g *Gateway) recordProtocolVersion(clientID string, result json.RawMessage) {
+	var info struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	if err := json.Unmarshal(result, &info); err != nil || info.ProtocolVersion == "" {
+		return
+	}
+	g.sessions.SetProtocolVersion(clientID, info.ProtocolVersion)
+}
+
+// sessionSpeaks reports whether protocolVersion is the one negotiated for
+// session; a session that never learnt its version accepts any the gateway
+// supports
+func (g *Gateway) sessionSpeaks(session Session, protocolVersion string) bool {
+	if session.ProtocolVersion == "" {
+		return g.handshake.supports(protocolVersion)
+	}
+	return protocolVersion == session.ProtocolVersion
+}
+
+// acceptInitializeResult records the MCP server's initialize result unless it
+// negotiated a protocol version the gateway does not speak
+func (g *Gateway) acceptInitializeResult(child *childProcess, result json.RawMessage) error {
+	var info struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	if err := json.Unmarshal(result, &info); err != nil {
+		return fmt.Errorf("invalid initialize result: %w", err)
+	}
+	if !g.handshake.supports(info.ProtocolVersion) {
+		return fmt.Errorf("MCP server chose unsupported protocol version %q, supported: %s", info.ProtocolVersion, strings.Join(g.handshake.ProtocolVersions, ", "))
+	}
+	child.markInitialized(result)
+	return nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// handshakeReply emulates a child that answers initialize with
+// protocolVersion and every other request like echoReply; received returns
+// the methods it got so far
+func handshakeReply(protocolVersion string) (reply func(msg JSONRPCMessage) []string, received func() []string) {
+	var mu sync.Mutex
+	var methods []string
+	reply = func(msg JSONRPCMessage) []string {
+		mu.Lock()
+		methods = append(methods, msg.Method)
+		mu.Unlock()
+		if msg.Method == "initialize" && msg.ID != nil {
+			return []string{fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":{"protocolVersion":%q,"capabilities":{},"serverInfo":{"name":"test-server"}}}`, msg.ID, protocolVersion)}
+		}
+		return echoReply(msg)
+	}
+	return reply, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), methods...)
+	}
+}
+
+// waitForMethods waits until the child received want
+func waitForMethods(t *testing.T, received func() []string, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := strings.Join(received(), ",")
+		if got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("child received %s, want %s", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWaitForReadyPerformsSingleHandshake(t *testing.T) {
+	reply, received := handshakeReply("2025-03-26")
+	g := newTestGateway(t, reply)
+	g.handshake.ProtocolVersions = []string{"2025-03-26", "2024-11-05"}
+
+	if err := g.WaitForReady(5 * time.Second); err != nil {
+		t.Fatalf("WaitForReady: %v", err)
+	}
+	waitForMethods(t, received, "initialize,notifications/initialized")
+	if info := g.child.initResult.Load(); info == nil || info.ProtocolVersion != "2025-03-26" {
+		t.Fatalf("cached initialize result = %+v", info)
+	}
+}
+
+func TestWaitForReadyRejectsUnsupportedProtocolVersion(t *testing.T) {
+	reply, _ := handshakeReply("2099-01-01")
+	g := newTestGateway(t, reply)
+
+	if err := g.WaitForReady(5 * time.Second); err == nil || !strings.Contains(err.Error(), "unsupported protocol version") {
+		t.Fatalf("WaitForReady = %v, want an unsupported version error", err)
+	}
+	if ready, _ := g.readiness(); ready {
+		t.Fatal("gateway ready after a failed handshake")
+	}
+}
+
+func TestInitializeAnsweredFromCache(t *testing.T) {
+	reply, received := handshakeReply("2025-06-18")
+	g := newTestGateway(t, reply)
+	g.handshake.InitializeMode = initializeCache
+	if err := g.WaitForReady(5 * time.Second); err != nil {
+		t.Fatalf("WaitForReady: %v", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(g.HandleHTTPMessage))
+	defer server.Close()
+
+	resp := doSessionRequest(t, http.MethodPost, server.URL, "", `{"jsonrpc":"2.0","id":7,"method":"initialize","params":{"protocolVersion":"2025-06-18"}}`)
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	sessionID := resp.Header.Get("Mcp-Session-Id")
+	if resp.StatusCode != http.StatusOK || sessionID == "" || !strings.Contains(string(body), `"id":7`) || !strings.Contains(string(body), "test-server") {
+		t.Fatalf("initialize = %d %s, want the cached result", resp.StatusCode, body)
+	}
+
+	resp = doSessionRequest(t, http.MethodPost, server.URL, sessionID, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	_ = resp.Body.Close()
+	resp = doSessionRequest(t, http.MethodPost, server.URL, sessionID, `{"jsonrpc":"2.0","id":8,"method":"tools/list"}`)
+	_ = resp.Body.Close()
+	waitForMethods(t, received, "initialize,notifications/initialized,tools/list")
+}
+
+func TestProtocolVersionHeaderValidated(t *testing.T) {
+	g := newTestGateway(t, echoReply)
+	server := httptest.NewServer(mcpHandler(g))
+	t.Cleanup(server.Close)
+	g.handshake.ProtocolVersions = []string{"2025-03-26"}
+	sessionID := initializeSession(t, server.URL)
+
+	for version, want := range map[string]int{
+		"":           http.StatusOK,
+		"2025-03-26": http.StatusOK,
+		"2024-11-05": http.StatusBadRequest,
+	} {
+		req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`))
+		req.Header.Set("Mcp-Session-Id", sessionID)
+		if version != "" {
+			req.Header.Set("MCP-Protocol-Version", version)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("MCP-Protocol-Version %q: status = %d, want %d", version, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestProtocolVersionHeaderMatchesNegotiatedVersion(t *testing.T) {
+	reply, _ := handshakeReply("2025-06-18")
+	g := newTestGateway(t, reply)
+	server := httptest.NewServer(mcpHandler(g))
+	t.Cleanup(server.Close)
+	g.handshake.ProtocolVersions = []string{"2025-11-25", "2025-06-18"}
+
+	resp := doSessionRequest(t, http.MethodPost, server.URL, "", `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-11-25"}}`)
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	sessionID := resp.Header.Get("Mcp-Session-Id")
+
+	// The client asked for 2025-11-25 but the server settled on 2025-06-18
+	for version, want := range map[string]int{
+		"2025-06-18": http.StatusOK,
+		"2025-11-25": http.StatusBadRequest,
+	} {
+		req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`))
+		req.Header.Set("Mcp-Session-Id", sessionID)
+		req.Header.Set("MCP-Protocol-Version", version)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("MCP-Protocol-Version %q: status = %d, want %d", version, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestHTTPUpstreamHandshake(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg JSONRPCMessage
+		_ = json.NewDecoder(r.Body).Decode(&msg)
+		mu.Lock()
+		received = append(received, msg.Method+" "+r.Header.Get("Mcp-Session-Id")+" "+r.Header.Get("MCP-Protocol-Version"))
+		mu.Unlock()
+		if msg.ID == nil {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.Header().Set("Mcp-Session-Id", "upstream-session")
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "event: message\ndata: {\"jsonrpc\":\"2.0\",\"id\":%s,\"result\":{\"protocolVersion\":\"2025-06-18\",\"capabilities\":{}}}\n\n", msg.ID)
+	}))
+	defer upstream.Close()
+
+	config, err := ParseHTTPUpstreamConfig(upstream.URL+"/mcp", "")
+	if err != nil {
+		t.Fatalf("ParseHTTPUpstreamConfig: %v", err)
+	}
+	g := NewGateway()
+	g.child = &childProcess{}
+	if err := g.WaitForHTTPUpstreamReady(config.URL, 5*time.Second); err != nil {
+		t.Fatalf("WaitForHTTPUpstreamReady: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got := strings.Join(received, ","); got != "initialize  ,notifications/initialized upstream-session 2025-06-18" {
+		t.Fatalf("upstream received %s", got)
+	}
+	if info := g.child.initResult.Load(); info == nil || info.ProtocolVersion != "2025-06-18" {
+		t.Fatalf("cached initialize result = %+v", info)
+	}
+}
+
+func TestParseProtocolVersions(t *testing.T) {
+	versions, err := parseProtocolVersions(" 2025-06-18, 2025-03-26 ")
+	if err != nil || strings.Join(versions, ",") != "2025-06-18,2025-03-26" {
+		t.Fatalf("parseProtocolVersions = %v, %v", versions, err)
+	}
+	for _, value := range []string{"", "latest", "2025-13-01"} {
+		if _, err := parseProtocolVersions(value); err == nil {
+			t.Errorf("parseProtocolVersions(%q) succeeded", value)
+		}
+	}
+}
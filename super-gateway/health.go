@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// defaultHandshakeRetry is the delay before the first retry of a failed
+// initialize handshake with the shared MCP server
+const defaultHandshakeRetry = time.Second
+
+// initializeResult is the part of the MCP server's initialize result the
+// gateway reports on /status
+type initializeResult struct {
+	ProtocolVersion string          `json:"protocolVersion"`
+	Capabilities    json.RawMessage `json:"capabilities,omitempty"`
+	ServerInfo      json.RawMessage `json:"serverInfo,omitempty"`
+	// raw is the whole result, which clients are answered with in cache mode
+	raw json.RawMessage
+}
+
+// markInitialized records that the child answered initialize with result,
+// which is empty when the handshake was skipped
+func (c *childProcess) markInitialized(result json.RawMessage) {
+	info := &initializeResult{raw: result}
+	if len(result) > 0 {
+		if err := json.Unmarshal(result, info); err != nil {
+			log.Printf("Warning: Failed to parse initialize result: %v", err)
+		}
+	}
+	c.initResult.Store(info)
+}
+
+// gatewayError is the last error the gateway ran into with its MCP server
+type gatewayError struct {
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// recordError logs a problem with the MCP server and keeps it for /status
+func (g *Gateway) recordError(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	log.Printf("%s", message)
+	g.lastError.Store(&gatewayError{Message: message, Time: time.Now()})
+}
+
+// liveness reports whether the gateway process is worth keeping. It only
+// fails once the shared MCP server exited for good, since restarting the
+// gateway is then the only way to recover.
+func (g *Gateway) liveness() (bool, string) {
+	if g.gaveUp.Load() {
+		return false, "MCP server exited and will not be restarted"
+	}
+	return true, ""
+}
+
+// readiness reports whether the gateway can serve traffic, and why not
+func (g *Gateway) readiness() (bool, string) {
+	if g.draining.Load() {
+		return false, "shutting down"
+	}
+	// Isolated sessions start their children on demand, so there is no single
+	// process whose state reflects the gateway's readiness
+	if g.sessionMode == sessionModeIsolated {
+		return true, ""
+	}
+	if g.restarting.Load() {
+		return false, "MCP server restarting"
+	}
+	child := g.sharedChild()
+	switch {
+	case child == nil || !child.running():
+		return false, "MCP server not running"
+	case child.initResult.Load() == nil:
+		return false, "MCP server not initialized"
+	case child.probeFailed.Load():
+		return false, "MCP server failed readiness probe"
+	}
+	return true, ""
+}
+
+// retryHandshake repeats the initialize handshake with a shared child whose
+// handshake failed, so readiness recovers once the server answers. Retries
+// back off from interval up to defaultMaxBackoff and stop once the child is
+// initialized, exits or is replaced, or the gateway shuts down.
+func (g *Gateway) retryHandshake(child *childProcess, interval time.Duration) {
+	for delay := interval; ; delay = min(2*delay, defaultMaxBackoff) {
+		select {
+		case <-child.exited:
+			return
+		case <-time.After(delay):
+		}
+		if g.draining.Load() || g.sharedChild() != child || child.initResult.Load() != nil {
+			return
+		}
+		if err := g.initializeChild(child, g.handshake.Timeout); err != nil {
+			log.Printf("MCP server handshake retry failed: %v", err)
+			continue
+		}
+		return
+	}
+}
+
+// HandleLivez answers liveness probes
+func (g *Gateway) HandleLivez(w http.ResponseWriter, r *http.Request) {
+	writeProbeResult(w, g.liveness)
+}
+
+// HandleReadyz answers readiness probes
+func (g *Gateway) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	writeProbeResult(w, g.readiness)
+}
+
+func writeProbeResult(w http.ResponseWriter, check func() (bool, string)) {
+	if ok, reason := check(); !ok {
+		http.Error(w, reason, http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("OK"))
+}
+
+// gatewayStatus is the document served on /status
+type gatewayStatus struct {
+	Live             bool            `json:"live"`
+	Ready            bool            `json:"ready"`
+	Reason           string          `json:"reason,omitempty"`
+	SessionMode      string          `json:"sessionMode"`
+	UptimeSeconds    float64         `json:"uptimeSeconds"`
+	Child            *childStatus    `json:"child,omitempty"`
+	Restarts         uint64          `json:"restarts"`
+	ProtocolVersion  string          `json:"protocolVersion,omitempty"`
+	ServerInfo       json.RawMessage `json:"serverInfo,omitempty"`
+	Capabilities     json.RawMessage `json:"capabilities,omitempty"`
+	Sessions         sessionCounts   `json:"sessions"`
+	InFlightRequests int             `json:"inFlightRequests"`
+	LastError        *gatewayError   `json:"lastError,omitempty"`
+}
+
+type childStatus struct {
+	PID           int     `json:"pid"`
+	Running       bool    `json:"running"`
+	Initialized   bool    `json:"initialized"`
+	UptimeSeconds float64 `json:"uptimeSeconds"`
+}
+
+type sessionCounts struct {
+	HTTP      int `json:"http"`
+	WebSocket int `json:"websocket"`
+	SSE       int `json:"sse"`
+	Processes int `json:"processes"`
+}
+
+// status collects the gateway's state for /status
+func (g *Gateway) status() gatewayStatus {
+	status := gatewayStatus{
+		SessionMode:      g.sessionMode,
+		UptimeSeconds:    time.Since(g.startedAt).Seconds(),
+		Restarts:         g.metrics.restartCount(),
+		InFlightRequests: g.routes.Len(),
+		LastError:        g.lastError.Load(),
+	}
+	status.Live, _ = g.liveness()
+	status.Ready, status.Reason = g.readiness()
+	if !status.Live {
+		_, status.Reason = g.liveness()
+	}
+
+	if child := g.sharedChild(); child != nil {
+		status.Child = &childStatus{Running: child.running()}
+		if child.cmd != nil && child.cmd.Process != nil {
+			status.Child.PID = child.cmd.Process.Pid
+		}
+		if status.Child.Running {
+			status.Child.UptimeSeconds = time.Since(child.startedAt).Seconds()
+		}
+		if info := child.initResult.Load(); info != nil {
+			status.Child.Initialized = true
+			status.ProtocolVersion = info.ProtocolVersion
+			status.ServerInfo = info.ServerInfo
+			status.Capabilities = info.Capabilities
+		}
+	}
+
+	status.Sessions.HTTP = g.sessions.Len()
+	g.clientsMu.RLock()
+	status.Sessions.WebSocket = len(g.clients)
+	g.clientsMu.RUnlock()
+	g.sseClientsMu.RLock()
+	status.Sessions.SSE = len(g.sseClients)
+	g.sseClientsMu.RUnlock()
+	status.Sessions.Processes = g.processes.Running()
+	return status
+}
+
+// HandleStatus serves the gateway's detailed state as JSON
+func (g *Gateway) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(g.status()); err != nil {
+		log.Printf("Failed to write status: %v", err)
+	}
+}
+
+// RunReadinessProbe pings the shared MCP server every interval once it is
+// initialized, taking the gateway out of rotation while it does not answer
+func (g *Gateway) RunReadinessProbe(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if g.draining.Load() {
+			return
+		}
+		child := g.sharedChild()
+		if child == nil || g.restarting.Load() || !child.running() || child.initResult.Load() == nil {
+			continue
+		}
+
+		if _, err := child.call("ping", nil, interval); err != nil {
+			if !child.probeFailed.Swap(true) {
+				g.recordError("Readiness probe failed: %v", err)
+			}
+			continue
+		}
+		if child.probeFailed.Swap(false) {
+			log.Printf("Readiness probe succeeded again")
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// probe calls a health handler and returns its status code
+func probe(handler http.HandlerFunc) int {
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	return recorder.Code
+}
+
+// waitForStatus waits until handler answers with want
+func waitForStatus(t *testing.T, handler http.HandlerFunc, want int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		code := probe(handler)
+		if code == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("status = %d, want %d", code, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReadinessFollowsHandshake(t *testing.T) {
+	g := newTestGateway(t, func(msg JSONRPCMessage) []string {
+		return []string{fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":{"protocolVersion":"2025-03-26","capabilities":{"tools":{}},"serverInfo":{"name":"test-server","version":"1.2.3"}}}`, msg.ID)}
+	})
+
+	if code := probe(g.HandleReadyz); code != http.StatusServiceUnavailable {
+		t.Fatalf("readyz before initialize: status = %d, want 503", code)
+	}
+	for name, handler := range map[string]http.HandlerFunc{"livez": g.HandleLivez, "health": g.HandleHealth} {
+		if code := probe(handler); code != http.StatusOK {
+			t.Fatalf("%s before initialize: status = %d, want 200", name, code)
+		}
+	}
+	if err := g.WaitForReady(5 * time.Second); err != nil {
+		t.Fatalf("WaitForReady: %v", err)
+	}
+	if code := probe(g.HandleReadyz); code != http.StatusOK {
+		t.Fatalf("readyz after initialize: status = %d, want 200", code)
+	}
+
+	recorder := httptest.NewRecorder()
+	g.HandleStatus(recorder, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var status gatewayStatus
+	if err := json.Unmarshal(recorder.Body.Bytes(), &status); err != nil {
+		t.Fatalf("decode status: %v", err)
+	}
+	if !status.Ready || status.ProtocolVersion != "2025-03-26" || !strings.Contains(string(status.ServerInfo), "test-server") || !strings.Contains(string(status.Capabilities), "tools") {
+		t.Fatalf("status = %s", recorder.Body.String())
+	}
+
+	g.restarting.Store(true)
+	if code := probe(g.HandleReadyz); code != http.StatusServiceUnavailable {
+		t.Fatalf("readyz while restarting: status = %d, want 503", code)
+	}
+	g.restarting.Store(false)
+	g.draining.Store(true)
+	if code := probe(g.HandleReadyz); code != http.StatusServiceUnavailable {
+		t.Fatalf("readyz while draining: status = %d, want 503", code)
+	}
+	if code := probe(g.HandleHealth); code != http.StatusOK {
+		t.Fatalf("health while draining: status = %d, want 200", code)
+	}
+}
+
+func TestReadinessRecoversAfterFailedHandshake(t *testing.T) {
+	var attempts atomic.Int32
+	g := newTestGateway(t, func(msg JSONRPCMessage) []string {
+		// The first initialize goes unanswered, as by a server still starting
+		if msg.Method != "initialize" || attempts.Add(1) == 1 {
+			return nil
+		}
+		return []string{fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":{"protocolVersion":"2025-03-26","capabilities":{}}}`, msg.ID)}
+	})
+	g.handshake.Timeout = 100 * time.Millisecond
+
+	if err := g.WaitForReady(g.handshake.Timeout); err == nil {
+		t.Fatal("WaitForReady succeeded without an initialize reply")
+	}
+	if code := probe(g.HandleReadyz); code != http.StatusServiceUnavailable {
+		t.Fatalf("readyz after a failed handshake: status = %d, want 503", code)
+	}
+
+	go g.retryHandshake(g.child, 10*time.Millisecond)
+	waitForStatus(t, g.HandleReadyz, http.StatusOK)
+}
+
+func TestLivenessFailsAfterGivingUp(t *testing.T) {
+	g := NewGateway()
+	g.restartPolicy.Mode = restartNever
+	g.restarts = newRestartTracker(g.restartPolicy)
+	g.cmdParts = []string{"sh", "-c", "exit 2"}
+	child, err := g.startChild("")
+	if err != nil {
+		t.Fatalf("startChild: %v", err)
+	}
+	g.cmdMu.Lock()
+	g.child = child
+	g.cmdMu.Unlock()
+
+	waitForStatus(t, g.HandleLivez, http.StatusServiceUnavailable)
+	if code := probe(g.HandleHealth); code != http.StatusServiceUnavailable {
+		t.Fatalf("health after giving up: status = %d, want 503", code)
+	}
+	if code := probe(g.HandleReadyz); code != http.StatusServiceUnavailable {
+		t.Fatalf("readyz after giving up: status = %d, want 503", code)
+	}
+	status := g.status()
+	if status.Live || status.LastError == nil || !strings.Contains(status.LastError.Message, "Not restarting") {
+		t.Fatalf("status = %+v, want the restart refusal recorded", status)
+	}
+}
+
+func TestReadinessProbe(t *testing.T) {
+	var unresponsive atomic.Bool
+	g := newTestGateway(t, func(msg JSONRPCMessage) []string {
+		if msg.Method == "ping" && unresponsive.Load() {
+			return nil
+		}
+		return echoReply(msg)
+	})
+	g.child.markInitialized(nil)
+	t.Cleanup(func() { g.draining.Store(true) })
+	go g.RunReadinessProbe(20 * time.Millisecond)
+
+	waitForStatus(t, g.HandleReadyz, http.StatusOK)
+	unresponsive.Store(true)
+	waitForStatus(t, g.HandleReadyz, http.StatusServiceUnavailable)
+	if lastError := g.lastError.Load(); lastError == nil || !strings.Contains(lastError.Message, "Readiness probe failed") {
+		t.Fatalf("last error = %+v, want the probe failure", lastError)
+	}
+	unresponsive.Store(false)
+	waitForStatus(t, g.HandleReadyz, http.StatusOK)
+}
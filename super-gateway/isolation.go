@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Session modes: in shared mode every client talks to one child, in isolated
+// mode each MCP session gets a child of its own
+const (
+	sessionModeShared   = "shared"
+	sessionModeIsolated = "isolated"
+)
+
+// Defaults for per-session children in isolated mode
+const (
+	defaultMaxProcesses       = 0 // unlimited
+	defaultProcessIdleTimeout = 10 * time.Minute
+	sessionChildInitTimeout   = 30 * time.Second
+)
+
+var (
+	// errTooManyProcesses is returned when the child process cap has been reached
+	errTooManyProcesses = errors.New("too many child processes")
+	// errNoSessionProcess is returned for clients that own no child in isolated mode
+	errNoSessionProcess = errors.New("no child process for session")
+)
+
+// sessionProcess is the child owned by a session in isolated mode. The child
+// is stopped when idle and may crash; either way a new one is started on the
+// session's next message and re-initialized with the session's own
+// initialize params.
+type sessionProcess struct {
+	SessionID string
+	// mu serializes starting, stopping and replacing the child
+	mu    sync.Mutex
+	child *childProcess
+	// initParams are the params of the session's initialize request
+	initParams json.RawMessage
+	// restarts applies the restart policy to the session's children
+	restarts *restartTracker
+	closed   bool
+}
+
+// processPool tracks the children of isolated sessions and enforces the cap
+// on concurrently running processes
+type processPool struct {
+	mu           sync.Mutex
+	sessions     map[string]*sessionProcess
+	running      int
+	maxProcesses int
+	idleTimeout  time.Duration
+}
+
+// newProcessPool creates a process pool. A zero maxProcesses disables the cap
+// and a zero idleTimeout keeps idle children running.
+func newProcessPool(maxProcesses int, idleTimeout time.Duration) *processPool {
+	return &processPool{
+		sessions:     make(map[string]*sessionProcess),
+		maxProcesses: maxProcesses,
+		idleTimeout:  idleTimeout,
+	}
+}
+
+func (p *processPool) add(sp *sessionProcess) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sessions[sp.SessionID] = sp
+}
+
+func (p *processPool) get(sessionID string) (*sessionProcess, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sp, ok := p.sessions[sessionID]
+	return sp, ok
+}
+
+func (p *processPool) remove(sessionID string) (*sessionProcess, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sp, ok := p.sessions[sessionID]
+	delete(p.sessions, sessionID)
+	return sp, ok
+}
+
+// list returns every session that owns a child, running or not
+func (p *processPool) list() []*sessionProcess {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	list := make([]*sessionProcess, 0, len(p.sessions))
+	for _, sp := range p.sessions {
+		list = append(list, sp)
+	}
+	return list
+}
+
+// child returns the running child of a session, or nil
+func (p *processPool) child(sessionID string) *childProcess {
+	sp, ok := p.get(sessionID)
+	if !ok {
+		return nil
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.child
+}
+
+// reserve claims a slot for a new child and reports whether one was free
+func (p *processPool) reserve() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.maxProcesses > 0 && p.running >= p.maxProcesses {
+		return false
+	}
+	p.running++
+	return true
+}
+
+// release frees the slot of a child that exited or failed to start
+func (p *processPool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.running > 0 {
+		p.running--
+	}
+}
+
+// Running returns the number of running session children
+func (p *processPool) Running() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.running
+}
+
+// recordInitialize remembers a session's initialize params so replacement
+// children can be initialized the same way; unknown sessions are ignored
+func (p *processPool) recordInitialize(sessionID string, params json.RawMessage) {
+	sp, ok := p.get(sessionID)
+	if !ok {
+		return
+	}
+	sp.mu.Lock()
+	sp.initParams = params
+	sp.mu.Unlock()
+}
+
+// startSessionProcess starts the child of a new session
+func (g *Gateway) startSessionProcess(sessionID string) error {
+	sp := &sessionProcess{SessionID: sessionID, restarts: newRestartTracker(g.restartPolicy)}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	// Registered first so an immediate exit finds the session
+	g.processes.add(sp)
+	if err := g.spawnSessionChild(sp); err != nil {
+		g.processes.remove(sessionID)
+		return err
+	}
+	return nil
+}
+
+// spawnSessionChild starts a child for sp, which must be locked
+func (g *Gateway) spawnSessionChild(sp *sessionProcess) error {
+	if !g.processes.reserve() {
+		return errTooManyProcesses
+	}
+	child, err := g.startChild(sp.SessionID)
+	if err != nil {
+		g.processes.release()
+		return err
+	}
+	sp.child = child
+	return nil
+}
+
+// sessionChild returns the child of a session in isolated mode. A session
+// whose child was reaped or crashed gets a new one, initialized with the
+// params of the session's initialize request before it is handed out.
+func (g *Gateway) sessionChild(sessionID string) (*childProcess, error) {
+	sp, ok := g.processes.get(sessionID)
+	if !ok {
+		return nil, errNoSessionProcess
+	}
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	if sp.child != nil {
+		return sp.child, nil
+	}
+	if sp.closed {
+		return nil, errNoSessionProcess
+	}
+
+	if err := g.spawnSessionChild(sp); err != nil {
+		return nil, err
+	}
+	if sp.initParams != nil {
+		if _, err := sp.child.initialize(sp.initParams, sessionChildInitTimeout); err != nil {
+			sp.child.stop()
+			sp.child = nil
+			return nil, fmt.Errorf("failed to initialize MCP server for session %s: %w", sessionID, err)
+		}
+	}
+	return sp.child, nil
+}
+
+// handleSessionChildExit accounts for the exit of a session's child. A child
+// the gateway stopped itself is simply forgotten. Otherwise the requests in
+// flight are failed, and a session whose child the restart policy does not
+// allow to restart is closed. The next child is only started when the
+// session sends its next message.
+func (g *Gateway) handleSessionChildExit(c *childProcess) {
+	g.processes.release()
+
+	sp, ok := g.processes.get(c.SessionID)
+	if !ok {
+		return
+	}
+
+	sp.mu.Lock()
+	if sp.child == c {
+		sp.child = nil
+	}
+	if c.stopped.Load() {
+		sp.mu.Unlock()
+		return
+	}
+	sp.mu.Unlock()
+
+	if c.exitErr != nil {
+		g.recordError("MCP server for session %s exited: %v", c.SessionID, c.exitErr)
+	}
+	g.failRequests(g.routes.RemoveClient(c.SessionID), "MCP server exited")
+	if _, ok, reason := sp.restarts.next(time.Now(), time.Since(c.startedAt), c.exitErr != nil); !ok {
+		log.Printf("MCP server for session %s exited, closing session: %s", c.SessionID, reason)
+		g.closeSession(c.SessionID)
+		return
+	}
+	g.metrics.childRestarted()
+	log.Printf("MCP server for session %s crashed, restarting on next message", c.SessionID)
+}
+
+// stopSessionProcess stops the child of a session that has ended
+func (g *Gateway) stopSessionProcess(sessionID string) {
+	sp, ok := g.processes.remove(sessionID)
+	if !ok {
+		return
+	}
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.closed = true
+	if sp.child != nil {
+		sp.child.stop()
+		sp.child = nil
+	}
+}
+
+// closeSession ends an HTTP session or drops a WebSocket connection
+func (g *Gateway) closeSession(sessionID string) {
+	if g.terminateSession(sessionID) {
+		return
+	}
+	g.stopSessionProcess(sessionID)
+
+	g.clientsMu.RLock()
+	client, ok := g.clients[sessionID]
+	g.clientsMu.RUnlock()
+	if ok {
+		g.closeWebSocket(client, websocket.CloseInternalServerErr, "MCP server crashed")
+	}
+}
+
+// sendToSession delivers a message to a session's standalone SSE stream or
+// WebSocket connection
+func (g *Gateway) sendToSession(sessionID string, data []byte) bool {
+	if client, ok := g.webSocketClient(sessionID); ok {
+		return g.sendToWebSocket(client, data)
+	}
+	return g.sendToSessionStream(sessionID, standaloneStream, data)
+}
+
+// RunProcessReaper periodically stops session children that have been idle
+// past the idle timeout
+func (g *Gateway) RunProcessReaper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		g.reapIdleProcesses(now)
+	}
+}
+
+// reapIdleProcesses stops every session child with no request in flight and
+// no traffic since the idle timeout, and returns the affected session IDs.
+// The sessions stay alive and get a new child on their next message.
+func (g *Gateway) reapIdleProcesses(now time.Time) []string {
+	if g.processes.idleTimeout <= 0 {
+		return nil
+	}
+
+	var reaped []string
+	for _, sp := range g.processes.list() {
+		sp.mu.Lock()
+		if sp.child != nil && now.Sub(sp.child.LastActivity()) > g.processes.idleTimeout && !g.routes.HasClient(sp.SessionID) {
+			sp.child.stop()
+			sp.child = nil
+			reaped = append(reaped, sp.SessionID)
+			log.Printf("Stopped idle MCP server for session %s", sp.SessionID)
+		}
+		sp.mu.Unlock()
+	}
+	return reaped
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestHelperMCPServer is not a real test: it runs as the child MCP server of
+// the isolated mode tests. It answers initialize and then reports its PID on
+// every other request; requests before initialize get an error.
+func TestHelperMCPServer(t *testing.T) {
+	if os.Getenv("SUPER_GATEWAY_HELPER_MCP_SERVER") != "1" {
+		return
+	}
+
+	initialized := false
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var msg JSONRPCMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil || msg.ID == nil {
+			continue
+		}
+		switch {
+		case msg.Method == "initialize":
+			initialized = true
+			fmt.Printf(`{"jsonrpc":"2.0","id":%s,"result":{"protocolVersion":"2025-03-26"}}`+"\n", msg.ID)
+		case !initialized:
+			fmt.Printf(`{"jsonrpc":"2.0","id":%s,"error":{"code":-32002,"message":"not initialized"}}`+"\n", msg.ID)
+		default:
+			fmt.Printf(`{"jsonrpc":"2.0","id":%s,"result":{"pid":%d}}`+"\n", msg.ID, os.Getpid())
+		}
+	}
+	os.Exit(0)
+}
+
+func newIsolatedTestServer(t *testing.T, maxProcesses int) (*Gateway, *httptest.Server) {
+	t.Helper()
+	t.Setenv("SUPER_GATEWAY_HELPER_MCP_SERVER", "1")
+
+	g := NewGateway()
+	g.sessionMode = sessionModeIsolated
+	g.processes = newProcessPool(maxProcesses, time.Minute)
+	if err := g.prepareCommand([]string{os.Args[0], "-test.run=^TestHelperMCPServer$"}); err != nil {
+		t.Fatalf("prepareCommand: %v", err)
+	}
+	go g.Run()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		g.Shutdown(ctx, time.Second)
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			g.HandleHTTPMessage(w, r)
+		case http.MethodDelete:
+			g.HandleHTTPSessionDelete(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return g, server
+}
+
+// whoami returns the PID of the child serving sessionID
+func whoami(t *testing.T, url, sessionID string) int {
+	t.Helper()
+	resp := doSessionRequest(t, http.MethodPost, url, sessionID, `{"jsonrpc":"2.0","id":2,"method":"whoami"}`)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("whoami status = %d, want 200", resp.StatusCode)
+	}
+	var reply struct {
+		Result struct {
+			PID int `json:"pid"`
+		} `json:"result"`
+		Error interface{} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		t.Fatalf("decode whoami reply: %v", err)
+	}
+	if reply.Error != nil || reply.Result.PID == 0 {
+		t.Fatalf("whoami reply error = %v, pid = %d", reply.Error, reply.Result.PID)
+	}
+	return reply.Result.PID
+}
+
+func waitForRunning(t *testing.T, g *Gateway, want int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for g.processes.Running() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("running processes = %d, want %d", g.processes.Running(), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestIsolatedSessionsGetOwnChild(t *testing.T) {
+	g, server := newIsolatedTestServer(t, 0)
+	first := initializeSession(t, server.URL)
+	second := initializeSession(t, server.URL)
+
+	firstPID := whoami(t, server.URL, first)
+	secondPID := whoami(t, server.URL, second)
+	if firstPID == secondPID {
+		t.Fatalf("both sessions are served by PID %d", firstPID)
+	}
+	if n := g.processes.Running(); n != 2 {
+		t.Fatalf("running processes = %d, want 2", n)
+	}
+
+	resp := doSessionRequest(t, http.MethodDelete, server.URL, first, "")
+	_ = resp.Body.Close()
+	waitForRunning(t, g, 1)
+
+	// Shutting down stops the children of the remaining sessions
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	g.Shutdown(ctx, time.Second)
+	if err := syscall.Kill(secondPID, 0); err == nil {
+		t.Fatalf("child %d of session %s still running after shutdown", secondPID, second)
+	}
+}
+
+func TestIsolatedChildCannotAnswerAnotherSession(t *testing.T) {
+	g, server := newIsolatedTestServer(t, 0)
+	first := initializeSession(t, server.URL)
+	second := initializeSession(t, server.URL)
+	whoami(t, server.URL, first)
+	whoami(t, server.URL, second)
+	firstChild, secondChild := g.processes.child(first), g.processes.child(second)
+
+	reply := make(chan []byte, 1)
+	id := g.routes.Register(second, json.RawMessage(`7`), reply)
+	g.routes.Forward(id, secondChild)
+
+	// The first session's child guesses the second's gateway ID
+	g.handleChildLine(firstChild, fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"result":{"hijacked":true}}`, id))
+	select {
+	case data := <-reply:
+		t.Fatalf("reply from another session's child delivered: %s", data)
+	default:
+	}
+
+	g.handleChildLine(secondChild, fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"result":{}}`, id))
+	select {
+	case data := <-reply:
+		var msg JSONRPCMessage
+		if err := json.Unmarshal(data, &msg); err != nil || string(msg.ID) != "7" {
+			t.Fatalf("reply = %s", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reply from the session's own child not delivered")
+	}
+}
+
+func TestIsolatedModeRequiresSession(t *testing.T) {
+	_, server := newIsolatedTestServer(t, 0)
+
+	resp := doSessionRequest(t, http.MethodPost, server.URL, "", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestIsolatedModeEnforcesProcessCap(t *testing.T) {
+	g, server := newIsolatedTestServer(t, 1)
+	initializeSession(t, server.URL)
+
+	resp := doSessionRequest(t, http.MethodPost, server.URL, "", `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26"}}`)
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", resp.StatusCode)
+	}
+	if n := g.sessions.Len(); n != 1 {
+		t.Fatalf("sessions = %d, want the rejected one not to be kept", n)
+	}
+}
+
+func TestIsolatedIdleChildIsReapedAndReinitialized(t *testing.T) {
+	g, server := newIsolatedTestServer(t, 0)
+	sessionID := initializeSession(t, server.URL)
+	before := whoami(t, server.URL, sessionID)
+
+	reaped := g.reapIdleProcesses(time.Now().Add(time.Hour))
+	if len(reaped) != 1 || reaped[0] != sessionID {
+		t.Fatalf("reaped = %v, want [%s]", reaped, sessionID)
+	}
+	waitForRunning(t, g, 0)
+
+	// The replacement child must have been initialized, or whoami fails
+	after := whoami(t, server.URL, sessionID)
+	if after == before {
+		t.Fatalf("session still served by reaped PID %d", before)
+	}
+}
+
+func TestIsolatedCrashCountsAgainstSessionRestarts(t *testing.T) {
+	g, server := newIsolatedTestServer(t, 0)
+	g.restartPolicy.MaxRestarts = 1
+	sessionID := initializeSession(t, server.URL)
+
+	crash := func() {
+		_ = g.processes.child(sessionID).cmd.Process.Kill()
+		waitForRunning(t, g, 0)
+	}
+
+	crash()
+	whoami(t, server.URL, sessionID)
+
+	crash()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := g.sessions.Get(sessionID); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("session survived more crashes than its restart budget")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	resp := doSessionRequest(t, http.MethodPost, server.URL, sessionID, `{"jsonrpc":"2.0","id":3,"method":"whoami"}`)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", resp.StatusCode)
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// redactedValue replaces every masked value in the logs
+const redactedValue = "[REDACTED]"
+
+// minSecretLength keeps short values such as "true" or "1" of env vars with
+// secret-looking names from being masked all over the logs
+const minSecretLength = 6
+
+// Log formats selectable with --log-format
+const (
+	logFormatJSON = "json"
+	logFormatText = "text"
+)
+
+var (
+	// secretEnvName matches the names of env vars whose values are masked
+	// without being listed explicitly, such as the API keys injected through
+	// a hub artifact's entrypoint env
+	secretEnvName = regexp.MustCompile(`(?i)(KEY|TOKEN|SECRET|PASSWORD|PASSWD|CREDENTIAL|AUTH|PRIVATE)`)
+	// bearerToken matches the credentials of an Authorization header value
+	bearerToken = regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9\-._~+/]+=*`)
+)
+
+// payloadLogging enables logging of full JSON-RPC payloads, off by default
+// because they carry tool arguments and results
+var payloadLogging atomic.Bool
+
+// parseLogLevel parses a --log-level value
+func parseLogLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q: must be debug, info, warn or error", value)
+	}
+	return level, nil
+}
+
+// redactor masks secrets in log output: values of secret env vars, bearer
+// and basic credentials, and values at configured JSON paths in payloads
+type redactor struct {
+	secrets []string
+	paths   [][]string
+}
+
+// newRedactor collects the values to mask from the environment. Env vars
+// named in envNames are always masked; others are masked when their name
+// looks like it holds a secret. paths are dot-separated JSON paths, where "*"
+// matches any object key or array index.
+func newRedactor(environ []string, envNames []string, paths []string) *redactor {
+	listed := make(map[string]bool)
+	for _, name := range envNames {
+		listed[strings.TrimSpace(name)] = true
+	}
+
+	r := &redactor{}
+	seen := make(map[string]bool)
+	for _, entry := range environ {
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok || len(value) < minSecretLength || seen[value] {
+			continue
+		}
+		if listed[name] || secretEnvName.MatchString(name) {
+			seen[value] = true
+			r.secrets = append(r.secrets, value)
+		}
+	}
+	// Longer secrets first, so a secret containing another is masked whole
+	sort.Slice(r.secrets, func(i, j int) bool { return len(r.secrets[i]) > len(r.secrets[j]) })
+
+	for _, path := range paths {
+		if path = strings.TrimSpace(path); path != "" {
+			r.paths = append(r.paths, strings.Split(path, "."))
+		}
+	}
+	return r
+}
+
+// String masks secrets and credentials in s
+func (r *redactor) String(s string) string {
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, redactedValue)
+	}
+	return bearerToken.ReplaceAllString(s, "${1} "+redactedValue)
+}
+
+// JSON masks the values at the configured paths of a JSON document, then
+// the secrets anywhere in it
+func (r *redactor) JSON(s string) string {
+	if len(r.paths) > 0 {
+		var doc interface{}
+		if err := json.Unmarshal([]byte(s), &doc); err == nil {
+			for _, path := range r.paths {
+				doc = maskPath(doc, path)
+			}
+			if masked, err := json.Marshal(doc); err == nil {
+				s = string(masked)
+			}
+		}
+	}
+	return r.String(s)
+}
+
+// maskPath replaces the values at path within doc
+func maskPath(doc interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return redactedValue
+	}
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		for key, value := range node {
+			if path[0] == "*" || path[0] == key {
+				node[key] = maskPath(value, path[1:])
+			}
+		}
+	case []interface{}:
+		for i, value := range node {
+			if path[0] == "*" || path[0] == strconv.Itoa(i) {
+				node[i] = maskPath(value, path[1:])
+			}
+		}
+	}
+	return doc
+}
+
+// redactingHandler is a slog.Handler that masks secrets in the message and
+// string attributes of every record before passing it on. The "payload"
+// attribute is treated as JSON so configured paths can be masked.
+type redactingHandler struct {
+	next     slog.Handler
+	redactor *redactor
+}
+
+func newRedactingHandler(next slog.Handler, r *redactor) *redactingHandler {
+	return &redactingHandler{next: next, redactor: r}
+}
+
+func (h *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, h.redactor.String(record.Message), record.PC)
+	record.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(h.redactAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, redacted)
+}
+
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = h.redactAttr(a)
+	}
+	return &redactingHandler{next: h.next.WithAttrs(redacted), redactor: h.redactor}
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{next: h.next.WithGroup(name), redactor: h.redactor}
+}
+
+func (h *redactingHandler) redactAttr(a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString:
+		if a.Key == "payload" {
+			return slog.String(a.Key, h.redactor.JSON(a.Value.String()))
+		}
+		return slog.String(a.Key, h.redactor.String(a.Value.String()))
+	case slog.KindGroup:
+		group := a.Value.Group()
+		redacted := make([]any, len(group))
+		for i, member := range group {
+			redacted[i] = h.redactAttr(member)
+		}
+		return slog.Group(a.Key, redacted...)
+	}
+	return a
+}
+
+// setupLogging makes a redacting slog handler the default logger. Output of
+// the log package goes through it as well, at info level.
+func setupLogging(w io.Writer, format string, level slog.Level, payloads bool, r *redactor) error {
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch format {
+	case logFormatJSON:
+		handler = slog.NewJSONHandler(w, options)
+	case logFormatText:
+		handler = slog.NewTextHandler(w, options)
+	default:
+		return fmt.Errorf("invalid log format %q: must be json or text", format)
+	}
+	slog.SetDefault(slog.New(newRedactingHandler(handler, r)))
+	payloadLogging.Store(payloads)
+	return nil
+}
+
+// logMessage records a JSON-RPC message passing between the gateway and a
+// child at debug level. The payload itself is only included when payload
+// logging is enabled.
+func logMessage(direction, sessionID string, msg JSONRPCMessage, payload []byte) {
+	if !slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	attrs := []any{
+		"direction", direction,
+		"bytes", len(payload),
+	}
+	if sessionID != "" {
+		attrs = append(attrs, "session", sessionID)
+	}
+	if msg.Method != "" {
+		attrs = append(attrs, "method", msg.Method)
+	}
+	if msg.ID != nil {
+		attrs = append(attrs, "request_id", string(msg.ID))
+	}
+	if payloadLogging.Load() {
+		attrs = append(attrs, "payload", string(payload))
+	}
+	slog.Debug("JSON-RPC message", attrs...)
+}
+
+// logRequest records the outcome of a forwarded request
+func logRequest(route *requestRoute, outcome string) {
+	level := slog.LevelInfo
+	if outcome == outcomeTimeout {
+		level = slog.LevelWarn
+	}
+	attrs := []any{
+		"session", route.ClientID,
+		"method", route.Method,
+		"request_id", string(route.OriginalID),
+		"outcome", outcome,
+		"duration", time.Since(route.CreatedAt),
+	}
+	if route.Tool != "" {
+		attrs = append(attrs, "tool", route.Tool)
+	}
+	slog.Log(context.Background(), level, "Request finished", attrs...)
+}
+
+// splitList splits a comma-separated flag value, ignoring empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -1,10 +1,11 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
@@ -13,11 +14,11 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"os"
-	"os/exec"
 	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -30,10 +31,13 @@ import (
 // exceed bufio.Scanner's 64KB default, so we allow up to 16MB per line.
 const maxScannerTokenSize = 16 * 1024 * 1024
 
+// readinessCheckID is the JSON-RPC ID of the gateway's own readiness probe
+const readinessCheckID = `"readiness-check"`
+
 // JSONRPCMessage represents a JSON-RPC 2.0 message
 type JSONRPCMessage struct {
 	JSONRPC string          `json:"jsonrpc"`
-	ID      interface{}     `json:"id,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
 	Method  string          `json:"method,omitempty"`
 	Params  json.RawMessage `json:"params,omitempty"`
 	Result  json.RawMessage `json:"result,omitempty"`
@@ -45,41 +49,69 @@ type Client struct {
 	ID   string
 	Conn *websocket.Conn
 	Send chan []byte
+	// Done is closed when the client is removed and its writer stops
+	Done chan struct{}
+	// TraceContext is the trace context of the upgrade request, the parent of
+	// every request the client sends
+	TraceContext traceContext
 }
 
 // SSEClient represents a connected HTTP stream (SSE) client
 type SSEClient struct {
 	ID   string
-	Send chan []byte
+	Send chan sseEvent
+	Done chan struct{}
+	// Transport is transportSSE for legacy /sse sessions and
+	// transportHTTPStream for GET /mcp streams
+	Transport string
 }
 
 // Gateway manages the MCP server subprocess and WebSocket connections
 type Gateway struct {
-	cmd                *exec.Cmd
-	cmdParts           []string
-	cmdMu              sync.Mutex
-	clients            map[string]*Client
-	clientsMu          sync.RWMutex
-	readinessReply     chan JSONRPCMessage
-	readinessReplyMu   sync.Mutex
-	sseClients         map[string]*SSEClient
-	sseClientsMu       sync.RWMutex
-	defaultSSEClientID string
-	waiters            map[string]chan []byte
-	waitersMu          sync.RWMutex
-	sessions           map[string]Session
-	sessionsMu         sync.RWMutex
-	register           chan *Client
-	unregister         chan *Client
-	broadcast          chan []byte
-	upgrader           websocket.Upgrader
-	stdinWriter        *bufio.Writer
-	stdinMu            sync.Mutex
-	stdoutScanner      *bufio.Scanner
-	stderrScanner      *bufio.Scanner
-	restartCount       int
-	maxRestarts        int
-	shouldRestart      bool
+	child          *childProcess
+	cmdParts       []string
+	cmdMu          sync.Mutex
+	sessionMode    string
+	processes      *processPool
+	clients        map[string]*Client
+	clientsMu      sync.RWMutex
+	sseClients     map[string]*SSEClient
+	sseClientsMu   sync.RWMutex
+	routes         *routeTable
+	metrics        *gatewayMetrics
+	tracer         *tracer
+	tools          *toolFilter
+	limiter        *limiter
+	outbound       outboundConfig
+	ws             wsConfig
+	serverRequests *serverRequestTable
+	streams        map[string]*requestStream
+	streamsMu      sync.RWMutex
+	sessions       *SessionManager
+	register       chan *Client
+	unregister     chan *Client
+	upgrader       websocket.Upgrader
+	restartPolicy  restartPolicy
+	restarts       *restartTracker
+	handshake      handshakeConfig
+	// responseTimeout is how long to wait for the child's reply to a request.
+	// Tools that call external APIs (e.g. web search) can take significant
+	// time under concurrent load.
+	responseTimeout time.Duration
+	// recorder records the traffic with MCP servers to a cassette, and replay
+	// is the cassette MCP servers are replayed from instead of running the
+	// command; redact masks secrets in both
+	recorder *cassetteRecorder
+	replay   *cassette
+	redact   *redactor
+	// draining is set once shutdown begins, refusing new sessions
+	draining atomic.Bool
+	// restarting is set while the shared child is being restarted, and
+	// gaveUp once the restart policy refused to restart it
+	restarting atomic.Bool
+	gaveUp     atomic.Bool
+	startedAt  time.Time
+	lastError  atomic.Pointer[gatewayError]
 }
 
 // rewriteOAuthURL replaces http://localhost:12849 in log messages with the appropriate public URL
@@ -119,11 +151,6 @@ func rewriteOAuthURL(line string) string {
 	return result
 }
 
-type Session struct {
-	ProtocolVersion string
-	CreatedAt       time.Time
-}
-
 type HTTPUpstreamConfig struct {
 	URL        *url.URL
 	PublicPath string
@@ -206,407 +233,500 @@ func dialLoopbackOnly(ctx context.Context, network, address string) (net.Conn, e
 }
 
 func NewGateway() *Gateway {
-	return &Gateway{
-		clients:       make(map[string]*Client),
-		sseClients:    make(map[string]*SSEClient),
-		waiters:       make(map[string]chan []byte),
-		sessions:      make(map[string]Session),
-		register:      make(chan *Client),
-		unregister:    make(chan *Client),
-		broadcast:     make(chan []byte),
-		maxRestarts:   5,
-		shouldRestart: true,
+	g := &Gateway{
+		clients:         make(map[string]*Client),
+		sseClients:      make(map[string]*SSEClient),
+		routes:          newRouteTable(),
+		metrics:         newGatewayMetrics(),
+		serverRequests:  newServerRequestTable(),
+		streams:         make(map[string]*requestStream),
+		sessions:        NewSessionManager(defaultSessionIdleTimeout, defaultMaxSessions),
+		sessionMode:     sessionModeShared,
+		outbound:        outboundConfig{QueueSize: defaultSendQueueSize, Policy: overflowBlock, Timeout: defaultSendTimeout},
+		ws:              wsConfig{PingInterval: defaultWSPingInterval, PongTimeout: defaultWSPongTimeout, MaxMessageSize: maxScannerTokenSize},
+		processes:       newProcessPool(defaultMaxProcesses, defaultProcessIdleTimeout),
+		register:        make(chan *Client),
+		unregister:      make(chan *Client),
+		restartPolicy:   defaultRestartPolicy(),
+		restarts:        newRestartTracker(defaultRestartPolicy()),
+		handshake:       defaultHandshakeConfig(),
+		responseTimeout: defaultResponseTimeout,
+		startedAt:       time.Now(),
 		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				return true // Allow all origins for simplicity
-			},
 			Subprotocols: []string{"mcp"}, // Add MCP subprotocol support
 		},
 	}
+	g.upgrader.CheckOrigin = func(r *http.Request) bool {
+		return g.ws.checkOrigin(r)
+	}
+	return g
 }
 
-// StartMCPServer starts the MCP server subprocess
+// StartMCPServer starts the shared MCP server subprocess
 func (g *Gateway) StartMCPServer(cmdParts []string) error {
+	if err := g.prepareCommand(cmdParts); err != nil {
+		return err
+	}
+
+	child, err := g.startChild("")
+	if err != nil {
+		return err
+	}
+
+	g.cmdMu.Lock()
+	g.child = child
+	g.cmdMu.Unlock()
+	return nil
+}
+
+// prepareCommand normalizes and stores the MCP server command. Only the first
+// call has an effect, so restarted children run the same command.
+func (g *Gateway) prepareCommand(cmdParts []string) error {
 	g.cmdMu.Lock()
 	defer g.cmdMu.Unlock()
 
+	// A replayed MCP server needs no command
+	if g.cmdParts != nil || (len(cmdParts) == 0 && g.replay != nil) {
+		return nil
+	}
 	if len(cmdParts) == 0 {
 		return fmt.Errorf("empty command")
 	}
 
-	// Store cmdParts for restart (only on first call)
-	if g.cmdParts == nil {
-		// Debug: Log exactly what we received
-		log.Printf("Received %d command parts:", len(cmdParts))
-		for i, part := range cmdParts {
-			log.Printf("  [%d]: %q", i, part)
-		}
-
-		// Handle the case where the entire command is passed as a single string
-		// This happens when Docker CMD is injected as a single argument
-		if len(cmdParts) == 1 && strings.Contains(cmdParts[0], " ") {
-			// Split the single string into command and arguments
-			// This handles cases like "node /app/build/index.js --tools=..."
-			cmdParts = strings.Fields(cmdParts[0])
-			log.Printf("Detected single string command, split into: %v", cmdParts)
-		} else if len(cmdParts) > 1 {
-			// Check if any argument (except the first) contains spaces and should be split
-			// This handles cases where arguments are incorrectly concatenated
-			newCmdParts := []string{cmdParts[0]} // Keep the command as-is
-			for i := 1; i < len(cmdParts); i++ {
-				if strings.Contains(cmdParts[i], " ") && !strings.HasPrefix(cmdParts[i], "--") {
-					// This argument contains spaces and isn't a flag, split it
-					log.Printf("Splitting argument [%d]: %q", i, cmdParts[i])
-					splitArgs := strings.Fields(cmdParts[i])
-					newCmdParts = append(newCmdParts, splitArgs...)
-				} else {
-					newCmdParts = append(newCmdParts, cmdParts[i])
-				}
-			}
-			if len(newCmdParts) != len(cmdParts) {
-				log.Printf("Arguments were split from %d to %d parts", len(cmdParts), len(newCmdParts))
-				cmdParts = newCmdParts
+	// Debug: Log exactly what we received
+	log.Printf("Received %d command parts:", len(cmdParts))
+	for i, part := range cmdParts {
+		log.Printf("  [%d]: %q", i, part)
+	}
+
+	// Handle the case where the entire command is passed as a single string
+	// This happens when Docker CMD is injected as a single argument, and for
+	// the command given by the env var or as a string in a config file
+	if len(cmdParts) == 1 && strings.Contains(cmdParts[0], " ") {
+		// Split the single string into command and arguments like a shell
+		// This handles cases like "node /app/build/index.js --name 'My Server'"
+		split, err := splitCommandLine(cmdParts[0])
+		if err != nil {
+			return err
+		}
+		cmdParts = split
+		log.Printf("Detected single string command, split into: %v", cmdParts)
+	} else if len(cmdParts) > 1 {
+		// Check if any argument (except the first) contains spaces and should be split
+		// This handles cases where arguments are incorrectly concatenated
+		newCmdParts := []string{cmdParts[0]} // Keep the command as-is
+		for i := 1; i < len(cmdParts); i++ {
+			if strings.Contains(cmdParts[i], " ") && !strings.HasPrefix(cmdParts[i], "--") {
+				// This argument contains spaces and isn't a flag, split it
+				log.Printf("Splitting argument [%d]: %q", i, cmdParts[i])
+				splitArgs := strings.Fields(cmdParts[i])
+				newCmdParts = append(newCmdParts, splitArgs...)
+			} else {
+				newCmdParts = append(newCmdParts, cmdParts[i])
 			}
 		}
-
-		g.cmdParts = cmdParts
-	} else {
-		// Use stored cmdParts for restart
-		cmdParts = g.cmdParts
+		if len(newCmdParts) != len(cmdParts) {
+			log.Printf("Arguments were split from %d to %d parts", len(cmdParts), len(newCmdParts))
+			cmdParts = newCmdParts
+		}
 	}
 
+	g.cmdParts = cmdParts
+
 	log.Printf("Final command parts: %v", cmdParts)
 	log.Printf("Command executable: %s", cmdParts[0])
 	log.Printf("Command arguments: %v", cmdParts[1:])
+	return nil
+}
 
-	g.cmd = exec.Command(cmdParts[0], cmdParts[1:]...)
-	g.cmd.Env = os.Environ()
-
-	// Set up pipes
-	stdin, err := g.cmd.StdinPipe()
-	if err != nil {
-		return fmt.Errorf("failed to create stdin pipe: %w", err)
+// splitCommandLine splits a command line into words like a POSIX shell does,
+// honoring single and double quotes and backslash escapes. Variables and
+// globs are not expanded.
+func splitCommandLine(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(line); i++ {
+		switch ch := line[i]; {
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case ch == '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote in command %q", line)
+			}
+			word.WriteString(line[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case ch == '"':
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				// Inside double quotes a backslash only escapes these
+				if line[i] == '\\' && i+1 < len(line) && strings.IndexByte("\"\\$`", line[i+1]) >= 0 {
+					i++
+				}
+				word.WriteByte(line[i])
+			}
+			if i == len(line) {
+				return nil, fmt.Errorf("unterminated double quote in command %q", line)
+			}
+			inWord = true
+		case ch == '\\':
+			if i+1 == len(line) {
+				return nil, fmt.Errorf("trailing backslash in command %q", line)
+			}
+			i++
+			word.WriteByte(line[i])
+			inWord = true
+		default:
+			word.WriteByte(ch)
+			inWord = true
+		}
 	}
-	g.stdinWriter = bufio.NewWriter(stdin)
-
-	stdout, err := g.cmd.StdoutPipe()
-	if err != nil {
-		return fmt.Errorf("failed to create stdout pipe: %w", err)
+	if inWord {
+		words = append(words, word.String())
 	}
-	g.stdoutScanner = bufio.NewScanner(stdout)
-	// The default bufio.Scanner buffer caps lines at 64KB, which is too small for
-	// large JSON-RPC payloads such as tools/list responses from some MCP servers
-	// (e.g. SigNoz). Raise the max token size so those lines are not dropped with
-	// a "token too long" error.
-	g.stdoutScanner.Buffer(make([]byte, 0, 1024*1024), maxScannerTokenSize)
+	return words, nil
+}
 
-	stderr, err := g.cmd.StderrPipe()
-	if err != nil {
-		return fmt.Errorf("failed to create stderr pipe: %w", err)
+// sharedChild returns the child serving every client in shared mode
+func (g *Gateway) sharedChild() *childProcess {
+	g.cmdMu.Lock()
+	defer g.cmdMu.Unlock()
+	return g.child
+}
+
+// handleChildExit fails the requests a child that exited was working on and
+// restarts it. Session children are handled by handleSessionChildExit; the
+// shared child is restarted according to the restart policy.
+func (g *Gateway) handleChildExit(c *childProcess) {
+	if c.SessionID != "" {
+		g.handleSessionChildExit(c)
+		return
+	}
+	if c.stopped.Load() {
+		// Stopped on purpose during shutdown
+		return
 	}
-	g.stderrScanner = bufio.NewScanner(stderr)
-	g.stderrScanner.Buffer(make([]byte, 0, 1024*1024), maxScannerTokenSize)
 
-	// Start the process
-	if err := g.cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start command: %w", err)
+	if c.exitErr != nil {
+		g.recordError("MCP server exited: %v", c.exitErr)
 	}
 
-	log.Printf("Started MCP server with PID: %d", g.cmd.Process.Pid)
+	// Mark the child as restarting first, so no new request is forwarded to
+	// it; every request already forwarded went to it and will not be
+	// answered now
+	g.restarting.Store(true)
+	g.failRequests(g.routes.RemoveAll(), "MCP server exited")
+	g.restartSharedChild(c)
+}
 
-	// Create channels to signal when stdout/stderr reading is complete
-	stdoutDone := make(chan struct{})
-	stderrDone := make(chan struct{})
+// childFor returns the child that serves clientID's messages, starting a
+// session's child again if it was reaped or crashed
+func (g *Gateway) childFor(clientID string) (*childProcess, error) {
+	if g.sessionMode == sessionModeIsolated {
+		return g.sessionChild(clientID)
+	}
+	if c := g.sharedChild(); c != nil {
+		return c, nil
+	}
+	return nil, fmt.Errorf("MCP server not started")
+}
 
-	// Handle stdout
-	go func() {
-		defer close(stdoutDone)
-		for g.stdoutScanner.Scan() {
-			line := g.stdoutScanner.Text()
-			if strings.TrimSpace(line) == "" {
-				continue
-			}
+// runningChild returns the child currently serving clientID, or nil when
+// there is none; unlike childFor it never starts one
+func (g *Gateway) runningChild(clientID string) *childProcess {
+	if g.sessionMode == sessionModeIsolated {
+		return g.processes.child(clientID)
+	}
+	return g.sharedChild()
+}
 
-			var msg JSONRPCMessage
-			if err := json.Unmarshal([]byte(line), &msg); err != nil {
-				// Rewrite OAuth URLs in non-JSON output
-				rewrittenLine := rewriteOAuthURL(line)
-				log.Printf("Failed to parse JSON from child: %s", rewrittenLine)
-				continue
-			}
+// handleChildLine routes a single line read from a child's stdout to the
+// readiness check, a pending HTTP waiter, a connected client or a broadcast.
+func (g *Gateway) handleChildLine(c *childProcess, line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	c.touch()
 
-			if !strings.Contains(line, "readiness-check") {
-				log.Printf("Child → Gateway: %s", line)
-			}
+	var msg JSONRPCMessage
+	if err := json.Unmarshal([]byte(line), &msg); err != nil {
+		// Rewrite OAuth URLs in non-JSON output
+		rewrittenLine := rewriteOAuthURL(line)
+		log.Printf("Failed to parse JSON from child: %s", rewrittenLine)
+		return
+	}
 
-			// Check if this is a response to our readiness check
-			if msg.ID != nil {
-				if idStr, ok := msg.ID.(string); ok && idStr == "readiness-check" {
-					g.readinessReplyMu.Lock()
-					if g.readinessReply != nil {
-						select {
-						case g.readinessReply <- msg:
-						default:
-						}
-					}
-					g.readinessReplyMu.Unlock()
-					continue
-				}
-			}
+	c.recorder.record(directionFromChild, c.SessionID, []byte(line))
+	if string(msg.ID) != readinessCheckID {
+		logMessage("child_to_gateway", c.SessionID, msg, []byte(line))
+	}
 
-			// In the Node.js version, it sends using wsTransport?.send(jsonMsg, jsonMsg.id)
-			// This means it uses the message's own ID to route back to the correct client
-			if msg.ID != nil {
-				if idStr, ok := msg.ID.(string); ok && strings.Contains(idStr, ":") {
-					parts := strings.SplitN(idStr, ":", 2)
-					clientID := parts[0]
-					originalID := parts[1]
-
-					// Parse the original ID back to int if it was a number
-					var restoredID interface{} = originalID
-					if num, err := strconv.Atoi(originalID); err == nil {
-						restoredID = num
-					}
-					msg.ID = restoredID
-
-					// First, try to fulfill a pending HTTP waiter for this clientID:originalID
-					key := clientID + ":" + originalID
-					g.waitersMu.RLock()
-					ch, hasWaiter := g.waiters[key]
-					g.waitersMu.RUnlock()
-					if hasWaiter {
-						if data, err := json.Marshal(msg); err == nil {
-							select {
-							case ch <- data:
-							default:
-							}
-						}
-						// Clean up the waiter
-						g.waitersMu.Lock()
-						delete(g.waiters, key)
-						g.waitersMu.Unlock()
-						continue
-					}
+	// Check if this is a response to our readiness check
+	if string(msg.ID) == readinessCheckID {
+		c.deliverReadinessReply(msg)
+		return
+	}
+	if c.deliverCallReply(msg) {
+		return
+	}
 
-					// If no waiter, forward to connected clients (WS/SSE)
-					g.clientsMu.RLock()
-					if client, ok := g.clients[clientID]; ok {
-						if data, err := json.Marshal(msg); err == nil {
-							select {
-							case client.Send <- data:
-							default:
-								// Client's send channel is full, close it
-								g.clientsMu.RUnlock()
-								g.unregister <- client
-								continue
-							}
-						}
-					}
-					g.clientsMu.RUnlock()
-
-					g.sseClientsMu.RLock()
-					if sseClient, ok := g.sseClients[clientID]; ok {
-						if data, err := json.Marshal(msg); err == nil {
-							select {
-							case sseClient.Send <- data:
-							default:
-								// Drop if channel full
-							}
-						}
-					}
-					g.sseClientsMu.RUnlock()
-					continue
-				}
+	// Replies carry the gateway ID their request was forwarded under; look up
+	// the route to restore the client's original ID and deliver the reply
+	if msg.Method == "" && msg.ID != nil {
+		if id, ok := parseGatewayID(msg.ID); ok {
+			route, err := g.routes.ResolveReply(id, c)
+			if err != nil {
+				log.Printf("Dropping reply for request %d: %v", id, err)
+				return
 			}
-
-			// If no ID or not a routed message, broadcast to all clients
-			if data, err := json.Marshal(msg); err == nil {
-				g.broadcast <- data
+			if route.Method == "tools/list" && msg.Result != nil {
+				msg.Result = g.tools.filterList(msg.Result)
 			}
+			g.deliverReply(route, msg)
+			return
 		}
+	}
 
-		// Check for scanner errors
-		if err := g.stdoutScanner.Err(); err != nil {
-			log.Printf("stdout scanner error: %v", err)
-		}
-	}()
+	// Requests initiated by the child go to a single client, never a broadcast
+	if msg.Method != "" && msg.ID != nil {
+		g.routeServerRequest(c, msg)
+		return
+	}
 
-	// Handle stderr
-	go func() {
-		defer close(stderrDone)
-		for g.stderrScanner.Scan() {
-			line := g.stderrScanner.Text()
-			// Rewrite OAuth URLs for proper routing
-			rewrittenLine := rewriteOAuthURL(line)
-			log.Printf("Child stderr: %s", rewrittenLine)
-		}
-	}()
+	// A change of the child's tool list only matters to clients when it
+	// changes the filtered list they see
+	if msg.Method == "notifications/tools/list_changed" && g.tools != nil {
+		go g.forwardToolsListChanged(c, msg)
+		return
+	}
 
-	// Monitor process exit - wait for stdout/stderr to finish before calling Wait()
-	go func() {
-		// Wait for both stdout and stderr goroutines to finish reading
-		<-stdoutDone
-		<-stderrDone
+	g.deliverNotification(c, msg)
+}
 
-		if err := g.cmd.Wait(); err != nil {
-			log.Printf("MCP server exited with error: %v", err)
-		} else {
-			log.Printf("MCP server exited normally")
-		}
+// deliverNotification sends a notification from a child to the request
+// stream it belongs to, the child's session, or every client
+func (g *Gateway) deliverNotification(c *childProcess, msg JSONRPCMessage) {
+	// Progress for a streamed request, and logging while it is the child's
+	// only streamed request, belong on that request's SSE response
+	if g.routeToRequestStream(c, msg) {
+		return
+	}
 
-		// Check if we should restart
-		if !g.shouldRestart {
-			log.Printf("Restart disabled, exiting...")
-			os.Exit(1)
-		}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
 
-		// Check restart limit
-		if g.restartCount >= g.maxRestarts {
-			log.Printf("Max restart attempts (%d) reached, exiting...", g.maxRestarts)
-			os.Exit(1)
+	// A session's own child only ever talks to that session
+	if c.SessionID != "" {
+		if !g.sendToSession(c.SessionID, data) {
+			log.Printf("Dropping notification for session %s: no open stream", c.SessionID)
 		}
+		return
+	}
 
-		// Restart with exponential backoff
-		g.restartCount++
-		backoff := time.Duration(g.restartCount) * time.Second
-		if backoff > 30*time.Second {
-			backoff = 30 * time.Second
-		}
+	// If no ID or not a routed message, broadcast to all clients
+	g.broadcastMessage(data)
+}
 
-		log.Printf("Restarting MCP server in %v (attempt %d/%d)...", backoff, g.restartCount, g.maxRestarts)
-		time.Sleep(backoff)
+// broadcastMessage queues data for every WebSocket client and standalone SSE
+// stream. It runs on the child's stdout reader, which the block policy holds
+// up while a client's queue is full.
+func (g *Gateway) broadcastMessage(data []byte) {
+	// Queue outside the locks: a slow client may hold up the send
+	g.clientsMu.RLock()
+	clients := make([]*Client, 0, len(g.clients))
+	for _, client := range g.clients {
+		clients = append(clients, client)
+	}
+	g.clientsMu.RUnlock()
+	for _, client := range clients {
+		g.sendToWebSocket(client, data)
+	}
 
-		if err := g.StartMCPServer(nil); err != nil {
-			log.Printf("Failed to restart MCP server: %v", err)
-			os.Exit(1)
-		}
+	// Each SSE client is the standalone stream of a distinct session, so
+	// every session receives exactly one copy
+	g.sseClientsMu.RLock()
+	sseClients := make([]*SSEClient, 0, len(g.sseClients))
+	for _, sseClient := range g.sseClients {
+		sseClients = append(sseClients, sseClient)
+	}
+	g.sseClientsMu.RUnlock()
+	for _, sseClient := range sseClients {
+		g.sendToSSE(sseClient, data)
+	}
+}
 
-		log.Printf("MCP server restarted successfully")
+// deliverReply hands the reply to a forwarded request back to the client
+// that sent it, under the client's original ID
+func (g *Gateway) deliverReply(route *requestRoute, msg JSONRPCMessage) {
+	if msg.Error != nil {
+		g.finishRequest(route, outcomeError)
+	} else {
+		g.finishRequest(route, outcomeSuccess)
+	}
+	if route.Method == "initialize" && msg.Result != nil {
+		g.recordProtocolVersion(route.ClientID, msg.Result)
+	}
+	msg.ID = route.OriginalID
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
 
-		// Wait for the restarted server to be ready
-		if os.Getenv("SKIP_READINESS_CHECK") != "true" {
-			if err := g.WaitForReady(30 * time.Second); err != nil {
-				log.Printf("Warning: Restarted MCP server readiness check failed: %v", err)
-				log.Printf("Continuing anyway - server may not be fully initialized")
-			}
+	// First, try to fulfill a pending HTTP waiter
+	if route.Reply != nil {
+		select {
+		case route.Reply <- data:
+		default:
 		}
-	}()
+		return
+	}
 
-	return nil
+	// If no waiter, forward to connected clients (WS/SSE)
+	if client, ok := g.webSocketClient(route.ClientID); ok {
+		g.sendToWebSocket(client, data)
+	}
+	g.sendToSessionStream(route.ClientID, route.Stream, data)
 }
 
-// SendToMCP sends a message to the MCP server
+// SendToMCP sends a message to the MCP server. Requests are forwarded under a
+// fresh gateway ID whose reply is routed back to clientID's connection.
 func (g *Gateway) SendToMCP(msg JSONRPCMessage, clientID string) error {
-	// Modify the ID to include the client ID
-	if msg.ID != nil {
-		msg.ID = fmt.Sprintf("%s:%v", clientID, msg.ID)
+	if msg.Method == "notifications/initialized" && g.cachedInitialize() != nil {
+		// The shared server was told by the gateway's own handshake already
+		return nil
+	}
+	if msg.Method == "notifications/cancelled" {
+		translated, ok := g.translateCancellation(msg, clientID)
+		if !ok {
+			log.Printf("Ignoring cancellation from client %s for a request that is not in flight", clientID)
+			return nil
+		}
+		msg = translated
+	}
+	if msg.Method != "" && msg.ID != nil {
+		id := g.routes.Register(clientID, msg.ID, nil)
+		err := g.sendRequest(msg, clientID, id, g.clientTraceContext(clientID))
+		var limitErr *rateLimitError
+		if errors.As(err, &limitErr) {
+			g.rejectRequest(id, msg, jsonRPCRateLimited, limitErr.Error())
+			return nil
+		}
+		if err != nil {
+			g.routes.Remove(id)
+			return err
+		}
+		return nil
+	}
+	if msg.Method == "" && msg.ID != nil {
+		// A response to a server-initiated request goes back under the child's
+		// ID, to the child that asked
+		request, ok := g.serverRequests.Resolve(clientID, msg.ID)
+		if !ok {
+			log.Printf("Ignoring response from client %s to unknown server request %s", clientID, msg.ID)
+			return nil
+		}
+		msg.ID = request.ChildID
+		return request.Child.write(msg)
 	}
 
-	data, err := json.Marshal(msg)
+	child, err := g.childFor(clientID)
 	if err != nil {
-		return fmt.Errorf("failed to marshal message: %w", err)
+		return err
 	}
+	return child.write(msg)
+}
 
-	log.Printf("Gateway → Child: %s", string(data))
+// sendRequest forwards a request from clientID to the MCP server under its
+// gateway ID, traced as a child of parent
+func (g *Gateway) sendRequest(msg JSONRPCMessage, clientID string, id int64, parent traceContext) error {
+	if strings.EqualFold(msg.Method, "initialize") {
+		if g.answerInitialize(id, msg) {
+			return nil
+		}
+		g.processes.recordInitialize(clientID, msg.Params)
+	}
 
-	g.stdinMu.Lock()
-	defer g.stdinMu.Unlock()
+	// Calls to tools hidden by the filter are answered by the gateway itself
+	if msg.Method == "tools/call" {
+		rewritten, ok := g.tools.rewriteCall(msg)
+		if !ok {
+			g.rejectRequest(id, msg, jsonRPCInvalidParams, fmt.Sprintf("Unknown tool: %s", toolName(msg)))
+			return nil
+		}
+		msg = rewritten
+	}
 
-	if _, err := g.stdinWriter.Write(append(data, '\n')); err != nil {
-		return fmt.Errorf("failed to write to stdin: %w", err)
+	// The shared child is down until the restart completes
+	if g.sessionMode != sessionModeIsolated && g.restarting.Load() {
+		g.rejectRequest(id, msg, jsonRPCServerRestarting, "MCP server restarting")
+		return nil
 	}
 
-	return g.stdinWriter.Flush()
+	release, err := g.admitRequest(msg, clientID, id)
+	if err != nil || release == nil {
+		return err
+	}
+
+	child, err := g.childFor(clientID)
+	if err != nil {
+		release()
+		return err
+	}
+	msg, span := g.startRequestSpan(msg, clientID, parent)
+	g.routes.Describe(id, msg.Method, toolName(msg), span)
+	g.routes.Forward(id, child)
+	msg.ID = gatewayID(id)
+	if err := child.write(msg); err != nil {
+		release()
+		return err
+	}
+	return nil
 }
 
-// WaitForReady waits for the MCP server to be ready by sending a tools/list request
+// WaitForReady performs the initialize handshake with the shared MCP server,
+// sending a single initialize request and waiting up to timeout for its reply
 func (g *Gateway) WaitForReady(timeout time.Duration) error {
 	log.Printf("Waiting for MCP server to be ready (timeout: %v)...", timeout)
 
-	// Create a channel to receive the readiness reply
-	g.readinessReplyMu.Lock()
-	g.readinessReply = make(chan JSONRPCMessage, 1)
-	g.readinessReplyMu.Unlock()
-
-	defer func() {
-		g.readinessReplyMu.Lock()
-		close(g.readinessReply)
-		g.readinessReply = nil
-		g.readinessReplyMu.Unlock()
-	}()
-
-	// Prepare the initialize request
-	readinessMsg := JSONRPCMessage{
-		JSONRPC: "2.0",
-		ID:      "readiness-check",
-		Method:  "initialize",
-		Params:  json.RawMessage(`{"protocolVersion":"2024-11-05","capabilities":{},"clientInfo":{"name":"super-gateway","version":"1.0.0"}}`),
+	child := g.sharedChild()
+	if child == nil {
+		return fmt.Errorf("MCP server not started")
 	}
+	return g.initializeChild(child, timeout)
+}
 
-	data, err := json.Marshal(readinessMsg)
+// initializeChild performs the gateway's initialize handshake with child
+func (g *Gateway) initializeChild(child *childProcess, timeout time.Duration) error {
+	result, err := child.initialize(g.handshake.initializeParams(), timeout)
 	if err != nil {
-		return fmt.Errorf("failed to marshal readiness check message: %w", err)
-	}
-
-	// Create a ticker for retries every 1 second
-	retryTicker := time.NewTicker(1 * time.Second)
-	defer retryTicker.Stop()
-
-	// Create a timeout timer
-	timeoutTimer := time.NewTimer(timeout)
-	defer timeoutTimer.Stop()
-
-	// Send the first request immediately
-	log.Printf("Sending readiness check: %s", string(data))
-	g.stdinMu.Lock()
-	_, writeErr := g.stdinWriter.Write(append(data, '\n'))
-	if writeErr == nil {
-		writeErr = g.stdinWriter.Flush()
+		return err
 	}
-	g.stdinMu.Unlock()
-	if writeErr != nil {
-		return fmt.Errorf("failed to write readiness check: %w", writeErr)
-	}
-
-	attempt := 1
-
-	for {
-		select {
-		case reply := <-g.readinessReply:
-			if reply.Error != nil {
-				return fmt.Errorf("MCP server returned error during readiness check: %v", reply.Error)
-			}
-			log.Printf("MCP server is ready! Received initialize response after %d attempt(s)", attempt)
-			return nil
-
-		case <-retryTicker.C:
-			// Retry sending the request
-			attempt++
-			log.Printf("Retrying readiness check (attempt %d)...", attempt)
-			g.stdinMu.Lock()
-			_, writeErr := g.stdinWriter.Write(append(data, '\n'))
-			if writeErr == nil {
-				writeErr = g.stdinWriter.Flush()
-			}
-			g.stdinMu.Unlock()
-			if writeErr != nil {
-				return fmt.Errorf("failed to write readiness check: %w", writeErr)
-			}
-
-		case <-timeoutTimer.C:
-			return fmt.Errorf("timeout waiting for MCP server to be ready after %d attempt(s)", attempt)
-		}
+	if err := g.acceptInitializeResult(child, result); err != nil {
+		return err
 	}
+	log.Printf("MCP server is ready, protocol version %s", child.initResult.Load().ProtocolVersion)
+	return nil
 }
 
+// WaitForHTTPUpstreamReady performs the initialize handshake with an HTTP
+// upstream. The request is only retried while the upstream does not accept it,
+// so the server sees a single successful initialize.
 func (g *Gateway) WaitForHTTPUpstreamReady(upstream *url.URL, timeout time.Duration) error {
 	log.Printf("Waiting for HTTP upstream MCP server to be ready (timeout: %v)...", timeout)
 
 	readinessMsg := JSONRPCMessage{
 		JSONRPC: "2.0",
-		ID:      "readiness-check",
+		ID:      json.RawMessage(readinessCheckID),
 		Method:  "initialize",
-		Params:  json.RawMessage(`{"protocolVersion":"2024-11-05","capabilities":{},"clientInfo":{"name":"super-gateway","version":"1.0.0"}}`),
+		Params:  g.handshake.initializeParams(),
 	}
 	data, err := json.Marshal(readinessMsg)
 	if err != nil {
@@ -628,16 +748,22 @@ func (g *Gateway) WaitForHTTPUpstreamReady(upstream *url.URL, timeout time.Durat
 		}
 		request.Header.Set("Content-Type", "application/json")
 		request.Header.Set("Accept", "application/json, text/event-stream")
-		request.Header.Set("MCP-Protocol-Version", "2024-11-05")
 
 		response, err := client.Do(request) // #nosec G704 -- request uses a loopback-only URL and transport refuses non-loopback dials.
 		if err == nil {
-			_, _ = io.Copy(io.Discard, response.Body)
-			_ = response.Body.Close()
 			if response.StatusCode >= 200 && response.StatusCode < 300 {
-				log.Printf("HTTP upstream MCP server is ready after %d attempt(s)", attempt)
-				return nil
+				reply, err := readInitializeReply(response)
+				_ = response.Body.Close()
+				if err != nil {
+					return err
+				}
+				if reply.Error != nil {
+					return fmt.Errorf("MCP server returned error during initialize: %v", reply.Error)
+				}
+				return g.finishHTTPUpstreamHandshake(client, upstream, response.Header.Get("Mcp-Session-Id"), reply.Result, attempt)
 			}
+			_, _ = io.Copy(io.Discard, response.Body)
+			_ = response.Body.Close()
 			log.Printf("HTTP upstream readiness attempt %d returned non-2xx status", attempt)
 		} else {
 			log.Printf("HTTP upstream readiness attempt %d failed: %v", attempt, err)
@@ -652,6 +778,64 @@ func (g *Gateway) WaitForHTTPUpstreamReady(upstream *url.URL, timeout time.Durat
 	}
 }
 
+// readInitializeReply reads the reply to the gateway's initialize request
+// from a JSON or SSE response
+func readInitializeReply(response *http.Response) (JSONRPCMessage, error) {
+	var reply JSONRPCMessage
+	if !strings.HasPrefix(response.Header.Get("Content-Type"), "text/event-stream") {
+		if err := json.NewDecoder(io.LimitReader(response.Body, maxScannerTokenSize)).Decode(&reply); err != nil {
+			return reply, fmt.Errorf("invalid initialize response: %w", err)
+		}
+		return reply, nil
+	}
+
+	found := false
+	_ = readSSE(response.Body, func(id, event string, data []byte) {
+		var msg JSONRPCMessage
+		if !found && json.Unmarshal(data, &msg) == nil && string(msg.ID) == readinessCheckID {
+			reply, found = msg, true
+		}
+	})
+	if !found {
+		return reply, fmt.Errorf("initialize response stream ended without a reply")
+	}
+	return reply, nil
+}
+
+// finishHTTPUpstreamHandshake checks the upstream's initialize result and
+// sends notifications/initialized in the session it created
+func (g *Gateway) finishHTTPUpstreamHandshake(client *http.Client, upstream *url.URL, sessionID string, result json.RawMessage, attempt int) error {
+	child := g.sharedChild()
+	if child == nil {
+		return fmt.Errorf("MCP server not started")
+	}
+	if err := g.acceptInitializeResult(child, result); err != nil {
+		return err
+	}
+	protocolVersion := child.initResult.Load().ProtocolVersion
+
+	data, _ := json.Marshal(JSONRPCMessage{JSONRPC: "2.0", Method: "notifications/initialized"})
+	request, err := http.NewRequest(http.MethodPost, upstream.String(), bytes.NewReader(data)) // #nosec G704 -- ParseHTTPUpstreamConfig and newLoopbackHTTPTransport enforce loopback-only HTTP with no proxy.
+	if err != nil {
+		return fmt.Errorf("failed to create initialized notification: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/json, text/event-stream")
+	request.Header.Set("MCP-Protocol-Version", protocolVersion)
+	if sessionID != "" {
+		request.Header.Set("Mcp-Session-Id", sessionID)
+	}
+	response, err := client.Do(request) // #nosec G704 -- request uses a loopback-only URL and transport refuses non-loopback dials.
+	if err != nil {
+		return fmt.Errorf("failed to send initialized notification: %w", err)
+	}
+	_, _ = io.Copy(io.Discard, response.Body)
+	_ = response.Body.Close()
+
+	log.Printf("HTTP upstream MCP server is ready after %d attempt(s), protocol version %s", attempt, protocolVersion)
+	return nil
+}
+
 // Run starts the gateway's main loop
 func (g *Gateway) Run() {
 	for {
@@ -663,141 +847,105 @@ func (g *Gateway) Run() {
 			log.Printf("New WebSocket connection: %s", client.ID)
 
 		case client := <-g.unregister:
-			g.clientsMu.Lock()
-			if _, ok := g.clients[client.ID]; ok {
-				delete(g.clients, client.ID)
-				close(client.Send)
-				g.clientsMu.Unlock()
-				log.Printf("WebSocket connection closed: %s", client.ID)
-			} else {
-				g.clientsMu.Unlock()
-			}
-
-		case message := <-g.broadcast:
-			// Broadcast to WebSocket clients
-			g.clientsMu.RLock()
-			for _, client := range g.clients {
-				select {
-				case client.Send <- message:
-				default:
-					// Client's send channel is full, close it
-					g.clientsMu.RUnlock()
-					g.unregister <- client
-					g.clientsMu.RLock()
-				}
-			}
-			g.clientsMu.RUnlock()
-
-			// Send to only one SSE stream to avoid duplicate delivery across streams
-			g.sseClientsMu.RLock()
-			if g.defaultSSEClientID != "" {
-				if sseClient, ok := g.sseClients[g.defaultSSEClientID]; ok {
-					select {
-					case sseClient.Send <- message:
-					default:
-						// Drop if channel full
-					}
-				}
-			}
-			g.sseClientsMu.RUnlock()
+			g.removeClient(client)
 		}
 	}
 }
 
 // HandleWebSocket handles WebSocket connections
 func (g *Gateway) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if g.rejectWhileDraining(w) {
+		return
+	}
+	clientID := uuid.New().String()
+
+	// In isolated mode each connection is a session with a child of its own
+	if g.sessionMode == sessionModeIsolated {
+		if err := g.startSessionProcess(clientID); err != nil {
+			log.Printf("Rejecting WebSocket connection: %v", err)
+			if errors.Is(err, errTooManyProcesses) {
+				http.Error(w, "Too many processes", http.StatusServiceUnavailable)
+			} else {
+				http.Error(w, "Failed to start MCP server", http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+
 	conn, err := g.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("Failed to upgrade connection: %v", err)
+		g.stopSessionProcess(clientID)
 		return
 	}
 
 	client := &Client{
-		ID:   uuid.New().String(),
-		Conn: conn,
-		Send: make(chan []byte, 256),
+		ID:           clientID,
+		Conn:         conn,
+		Send:         make(chan []byte, g.outbound.QueueSize),
+		Done:         make(chan struct{}),
+		TraceContext: traceContextFromHeaders(r.Header),
 	}
 
 	g.register <- client
 
-	go client.writePump()
+	go client.writePump(g)
 	go client.readPump(g)
 }
 
-// HandleHTTPStream handles SSE connections for HTTP streaming transport
-func (g *Gateway) HandleHTTPStream(w http.ResponseWriter, r *http.Request) {
-	// Check if client ID is provided in header or query param
-	clientID := r.Header.Get("X-Client-ID")
-	if clientID == "" {
-		clientID = r.URL.Query().Get("clientId")
-	}
-	if clientID == "" {
-		clientID = uuid.New().String()
-	}
-
-	// Set SSE headers
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("X-Client-ID", clientID)
-
-	// Ensure we can flush to the client
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
-		return
+// registerSSEClient registers a new SSE stream of transport for clientID,
+// closing any stream previously registered under the same ID
+func (g *Gateway) registerSSEClient(clientID, transport string) *SSEClient {
+	sseClient := &SSEClient{ID: clientID, Send: make(chan sseEvent, g.outbound.QueueSize), Done: make(chan struct{}), Transport: transport}
+	g.sseClientsMu.Lock()
+	if previous, ok := g.sseClients[clientID]; ok {
+		close(previous.Done)
 	}
+	g.sseClients[clientID] = sseClient
+	g.sseClientsMu.Unlock()
+	return sseClient
+}
 
-	// Register SSE client
+// unregisterSSEClient removes sseClient unless it has already been replaced
+// or closed
+func (g *Gateway) unregisterSSEClient(sseClient *SSEClient) {
 	g.sseClientsMu.Lock()
-	g.sseClients[clientID] = &SSEClient{ID: clientID, Send: make(chan []byte, 256)}
-	sseClient := g.sseClients[clientID]
-	if g.defaultSSEClientID == "" {
-		g.defaultSSEClientID = clientID
+	if current, ok := g.sseClients[sseClient.ID]; ok && current == sseClient {
+		delete(g.sseClients, sseClient.ID)
+		close(sseClient.Done)
 	}
 	g.sseClientsMu.Unlock()
+}
 
-	// Send initial connection event
-	fmt.Fprintf(w, "event: connected\nid: %s\ndata: {\"clientId\":\"%s\"}\n\n", clientID, clientID)
-	flusher.Flush()
-
-	// Writer goroutine
-	done := make(chan struct{})
-	go func() {
-		ticker := time.NewTicker(30 * time.Second)
-		defer ticker.Stop()
-		for {
-			select {
-			case msg := <-sseClient.Send:
-				_, err := fmt.Fprintf(w, "data: %s\n\n", string(msg))
-				if err != nil {
-					log.Printf("SSE write error for client %s: %v", clientID, err)
-					close(done)
-					return
-				}
-				flusher.Flush()
-			case <-ticker.C:
-				_, err := fmt.Fprintf(w, ": ping\n\n")
-				if err != nil {
-					log.Printf("SSE ping error for client %s: %v", clientID, err)
-					close(done)
-					return
-				}
-				flusher.Flush()
-			case <-done:
+// streamSSEClient writes the messages queued for sseClient as SSE events,
+// with periodic pings, until the client disconnects or the stream is closed.
+// Events already replayed to the client are skipped.
+func (g *Gateway) streamSSEClient(w http.ResponseWriter, r *http.Request, flusher http.Flusher, sseClient *SSEClient, replayed map[uint64]bool) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case event := <-sseClient.Send:
+			if replayed[event.ID] {
+				continue
+			}
+			if err := writeSSEEvent(w, event); err != nil {
+				log.Printf("SSE write error for client %s: %v", sseClient.ID, err)
+				return
+			}
+			flusher.Flush()
+		case <-ticker.C:
+			if _, err := fmt.Fprintf(w, ": ping\n\n"); err != nil {
+				log.Printf("SSE ping error for client %s: %v", sseClient.ID, err)
 				return
 			}
+			flusher.Flush()
+		case <-sseClient.Done:
+			return
+		case <-r.Context().Done():
+			return
 		}
-	}()
-
-	// Keep the connection open until client disconnects
-	<-r.Context().Done()
-	close(done)
-
-	// Unregister SSE client
-	g.sseClientsMu.Lock()
-	delete(g.sseClients, clientID)
-	g.sseClientsMu.Unlock()
+	}
 }
 
 // HandleHTTPMessage handles incoming messages in HTTP streaming transport
@@ -807,33 +955,14 @@ func (g *Gateway) HandleHTTPMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Prefer session-based correlation if provided by client (per spec)
+	// Replies are routed and requests rate limited by the session, or by a
+	// per-request ID without one; IDs chosen by the client would let it share
+	// another client's routes and limits
 	clientID := r.Header.Get("Mcp-Session-Id")
-	if clientID == "" {
-		// Fallback to legacy X-Client-ID or query param if present
-		clientID = r.Header.Get("X-Client-ID")
-	}
-	if clientID == "" {
-		clientID = r.URL.Query().Get("clientId")
-	}
 	if clientID == "" {
 		clientID = uuid.New().String()
 	}
 
-	// If session exists, enforce protocol version header after initialization
-	g.sessionsMu.RLock()
-	session, hasSession := g.sessions[clientID]
-	g.sessionsMu.RUnlock()
-	if hasSession {
-		// The spec requires MCP-Protocol-Version on subsequent requests
-		pv := r.Header.Get("MCP-Protocol-Version")
-		if pv == "" {
-			// Back-compat: server SHOULD assume 2025-03-26 when header is missing
-			// We accept missing header, but can log
-			log.Printf("Warning: missing MCP-Protocol-Version header; assuming %s", session.ProtocolVersion)
-		}
-	}
-
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		http.Error(w, "Failed to read request body", http.StatusBadRequest)
@@ -841,85 +970,221 @@ func (g *Gateway) HandleHTTPMessage(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
+	if isBatch(body) {
+		g.handleHTTPBatch(w, r, clientID, body)
+		return
+	}
+
 	var msg JSONRPCMessage
 	if err := json.Unmarshal(body, &msg); err != nil {
 		http.Error(w, "Invalid JSON-RPC message", http.StatusBadRequest)
 		return
 	}
 
-	if err := g.SendToMCP(msg, clientID); err != nil {
-		log.Printf("Failed to send message to MCP from client %s: %v", clientID, err)
-		http.Error(w, "Failed to process message", http.StatusInternalServerError)
+	// Sessions are created by initialize with a server-generated ID; every
+	// other request naming a session must refer to a live one
+	var newSessionID string
+	if strings.EqualFold(msg.Method, "initialize") && msg.ID != nil {
+		if g.rejectWhileDraining(w) {
+			return
+		}
+		var params struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		_ = json.Unmarshal(msg.Params, &params)
+		session, err := g.sessions.Create(params.ProtocolVersion)
+		if err != nil {
+			log.Printf("Rejecting initialize: %v", err)
+			http.Error(w, "Too many sessions", http.StatusServiceUnavailable)
+			return
+		}
+		defer g.sessions.Release(session.ID)
+		newSessionID = session.ID
+		clientID = session.ID
+
+		// In isolated mode the new session gets a child of its own, which the
+		// client's initialize request is the first message to reach
+		if g.sessionMode == sessionModeIsolated {
+			if err := g.startSessionProcess(session.ID); err != nil {
+				g.terminateSession(session.ID)
+				log.Printf("Rejecting initialize: %v", err)
+				if errors.Is(err, errTooManyProcesses) {
+					http.Error(w, "Too many processes", http.StatusServiceUnavailable)
+				} else {
+					http.Error(w, "Failed to start MCP server", http.StatusInternalServerError)
+				}
+				return
+			}
+		}
+	} else if sessionID := r.Header.Get("Mcp-Session-Id"); sessionID == "" && g.sessionMode == sessionModeIsolated {
+		// Without a session there is no child to forward to
+		http.Error(w, "Missing Mcp-Session-Id header", http.StatusBadRequest)
 		return
+	} else if sessionID != "" {
+		session, ok := g.sessions.Acquire(sessionID)
+		if !ok {
+			// Per spec, requests for a terminated, expired or unknown session get
+			// 404 so the client starts a new session with initialize
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		defer g.sessions.Release(sessionID)
+
+		// The spec requires MCP-Protocol-Version on subsequent requests, but
+		// for back-compat a missing header means the session's version
+		if pv := r.Header.Get("MCP-Protocol-Version"); pv != "" && !g.sessionSpeaks(session, pv) {
+			http.Error(w, fmt.Sprintf("Unsupported MCP-Protocol-Version %q, the session negotiated %q", pv, session.ProtocolVersion), http.StatusBadRequest)
+			return
+		}
 	}
 
 	// Per spec: if input is response/notification, return 202 Accepted with no body
-	if msg.Method == "" && msg.ID != nil {
-		// JSON-RPC response: 202 with no body
-		w.WriteHeader(http.StatusAccepted)
-		return
-	}
-	if msg.Method != "" && msg.ID == nil {
-		// JSON-RPC notification: 202 with no body
+	if msg.Method == "" || msg.ID == nil {
+		if err := g.SendToMCP(msg, clientID); err != nil {
+			log.Printf("Failed to send message to MCP from client %s: %v", clientID, err)
+			http.Error(w, "Failed to process message", http.StatusInternalServerError)
+			return
+		}
 		w.WriteHeader(http.StatusAccepted)
 		return
 	}
 
-	// If input is a request (method+id), we must return either application/json or SSE stream
-	// For simplicity, we return application/json by waiting for the child's response.
-	// Create a waiter for this specific response
-	key := clientID + ":" + fmt.Sprintf("%v", msg.ID)
+	// If input is a request (method+id), we must return either application/json or SSE stream.
+	// Create a waiter for this specific response before forwarding so a fast reply is not missed.
 	ch := make(chan []byte, 1)
-	g.waitersMu.Lock()
-	g.waiters[key] = ch
-	g.waitersMu.Unlock()
-
-	// Determine timeout: use MCP_RESPONSE_TIMEOUT env var if set, otherwise default to 5 minutes.
-	// Tools that call external APIs (e.g. web search) can take significant time under concurrent load.
-	responseTimeout := 5 * time.Minute
-	if envTimeout := os.Getenv("MCP_RESPONSE_TIMEOUT"); envTimeout != "" {
-		if parsed, err := time.ParseDuration(envTimeout); err == nil {
-			responseTimeout = parsed
+	id := g.routes.Register(clientID, msg.ID, ch)
+	key := strconv.FormatInt(id, 10)
+
+	// When the client accepts SSE, collect the messages the child emits for this
+	// request; the response is upgraded to a stream only once one arrives, so
+	// quick calls still get a plain application/json reply.
+	var streamed <-chan []byte
+	if acceptsEventStream(r.Header.Get("Accept")) {
+		stream := g.openRequestStream(key, clientID, &msg)
+		defer g.closeRequestStream(key)
+		streamed = stream.Send
+	}
+
+	if err := g.sendRequest(msg, clientID, id, traceContextFromHeaders(r.Header)); err != nil {
+		g.routes.Remove(id)
+		if newSessionID != "" {
+			g.terminateSession(newSessionID)
 		}
+		var limitErr *rateLimitError
+		if errors.As(err, &limitErr) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", strconv.Itoa(limitErr.retryAfterSeconds()))
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write(newErrorResponse(msg.ID, jsonRPCRateLimited, limitErr.Error()))
+			return
+		}
+		log.Printf("Failed to send message to MCP from client %s: %v", clientID, err)
+		if errors.Is(err, errTooManyProcesses) {
+			http.Error(w, "Too many processes", http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, "Failed to process message", http.StatusInternalServerError)
+		return
 	}
 
 	// Wait with a timeout to avoid hanging forever.
 	// Use time.NewTimer so we can stop it early and avoid leaking timers under high concurrency.
-	timer := time.NewTimer(responseTimeout)
+	timer := time.NewTimer(g.responseTimeout)
 	defer timer.Stop()
 
-	select {
-	case data := <-ch:
-		w.Header().Set("Content-Type", "application/json")
-		// On initialize, generate and attach a session id (if not already present)
-		if strings.EqualFold(msg.Method, "initialize") {
-			// Attach session and protocol version for future requests
-			sessionId := clientID
-			if sessionId == "" {
-				sessionId = uuid.New().String()
+	// startStream switches the response to SSE the first time a correlated
+	// message has to be delivered to the client
+	var flusher http.Flusher
+	startStream := func() bool {
+		if flusher != nil {
+			return true
+		}
+		f, ok := w.(http.Flusher)
+		if !ok {
+			return false
+		}
+		flusher = f
+		if newSessionID != "" {
+			w.Header().Set("Mcp-Session-Id", newSessionID)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		return true
+	}
+
+	for {
+		select {
+		case data := <-streamed:
+			if !startStream() {
+				// Streaming not supported, fall back to waiting for the final response
+				streamed = nil
+				continue
+			}
+			if err := writeSSEEvent(w, g.recordEvent(clientID, key, data)); err != nil {
+				log.Printf("SSE write error for client %s: %v", clientID, err)
+				if !g.detachRequest(id, key, clientID, ch, streamed) {
+					g.cancelRequest(id, "Client connection lost")
+				}
+				return
+			}
+			flusher.Flush()
+		case data := <-ch:
+			if data == nil {
+				// The session was terminated while the request was in flight
+				if flusher == nil {
+					http.Error(w, "Session not found", http.StatusNotFound)
+				}
+				return
+			}
+			if len(streamed) > 0 && startStream() || flusher != nil {
+				// Flush anything emitted before the response so ordering is preserved
+				for drained := false; !drained; {
+					select {
+					case pending := <-streamed:
+						_ = writeSSEEvent(w, g.recordEvent(clientID, key, pending))
+					default:
+						drained = true
+					}
+				}
+				_ = writeSSEEvent(w, g.recordEvent(clientID, key, data))
+				flusher.Flush()
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if newSessionID != "" {
+				// A failed initialize does not leave a session behind
+				var reply JSONRPCMessage
+				if err := json.Unmarshal(data, &reply); err == nil && reply.Error != nil {
+					g.terminateSession(newSessionID)
+				} else {
+					w.Header().Set("Mcp-Session-Id", newSessionID)
+				}
 			}
-			// Extract protocolVersion from request params
-			var params struct {
-				ProtocolVersion string `json:"protocolVersion"`
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(data)
+			return
+		case <-timer.C:
+			g.expireRequest(id)
+			if flusher != nil {
+				// Headers are already sent, report the timeout as a JSON-RPC error on the stream
+				_ = writeSSEEvent(w, g.recordEvent(clientID, key, newErrorResponse(msg.ID, jsonRPCRequestTimeout, "Timeout waiting for response")))
+				flusher.Flush()
+				return
 			}
-			_ = json.Unmarshal(msg.Params, &params)
-			g.sessionsMu.Lock()
-			g.sessions[sessionId] = Session{ProtocolVersion: params.ProtocolVersion, CreatedAt: time.Now()}
-			g.sessionsMu.Unlock()
-			w.Header().Set("Mcp-Session-Id", sessionId)
+			http.Error(w, "Timeout waiting for response", http.StatusGatewayTimeout)
+			return
+		case <-r.Context().Done():
+			// A dropped response stream of a session can be resumed, so the
+			// request keeps running. Otherwise the client is gone — clean up the
+			// route so we don't leak resources, and stop the child from working
+			// on a reply nobody will read.
+			if flusher != nil && g.detachRequest(id, key, clientID, ch, streamed) {
+				return
+			}
+			g.cancelRequest(id, "Client disconnected")
+			return
 		}
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write(data)
-	case <-timer.C:
-		g.waitersMu.Lock()
-		delete(g.waiters, key)
-		g.waitersMu.Unlock()
-		http.Error(w, "Timeout waiting for response", http.StatusGatewayTimeout)
-	case <-r.Context().Done():
-		// Client disconnected — clean up the waiter so we don't leak goroutines/resources.
-		g.waitersMu.Lock()
-		delete(g.waiters, key)
-		g.waitersMu.Unlock()
 	}
 }
 
@@ -996,18 +1261,45 @@ func (c *Client) readPump(g *Gateway) {
 	defer func() {
 		g.unregister <- c
 		_ = c.Conn.Close()
+		// Replies to requests still in flight have nowhere to go
+		for _, route := range g.routes.RemoveClient(c.ID) {
+			g.finishRequest(route, outcomeCancelled)
+			g.notifyCancelled(route, "Client disconnected")
+		}
+		g.releaseServerRequests(c.ID)
+		g.stopSessionProcess(c.ID)
+		g.limiter.forget(c.ID)
 	}()
 
-	c.Conn.SetReadLimit(512 * 1024) // 512KB max message size
+	c.Conn.SetReadLimit(g.ws.MaxMessageSize)
+
+	// Pongs and messages both prove the peer is alive; without either within
+	// a ping interval and the pong timeout the connection is dropped
+	_ = c.Conn.SetReadDeadline(g.ws.readDeadline())
+	c.Conn.SetPongHandler(func(string) error {
+		return c.Conn.SetReadDeadline(g.ws.readDeadline())
+	})
 
 	for {
 		_, message, err := c.Conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			var netErr net.Error
+			switch {
+			case errors.Is(err, websocket.ErrReadLimit):
+				log.Printf("Closing WebSocket for client %s: message exceeds %d bytes", c.ID, g.ws.MaxMessageSize)
+			case errors.As(err, &netErr) && netErr.Timeout():
+				log.Printf("Closing WebSocket for client %s: no pong within %s", c.ID, g.ws.PingInterval+g.ws.PongTimeout)
+			case websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure):
 				log.Printf("WebSocket error for client %s: %v", c.ID, err)
 			}
 			break
 		}
+		_ = c.Conn.SetReadDeadline(g.ws.readDeadline())
+
+		if isBatch(message) {
+			g.handleWebSocketBatch(c, message)
+			continue
+		}
 
 		var msg JSONRPCMessage
 		if err := json.Unmarshal(message, &msg); err != nil {
@@ -1021,13 +1313,32 @@ func (c *Client) readPump(g *Gateway) {
 	}
 }
 
-// writePump writes messages to the WebSocket connection
-func (c *Client) writePump() {
+// writePump writes messages to the WebSocket connection, pinging the client
+// while it is idle, until the client is removed
+func (c *Client) writePump(g *Gateway) {
 	defer func() { _ = c.Conn.Close() }()
 
-	for message := range c.Send {
-		if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
-			log.Printf("Write error for client %s: %v", c.ID, err)
+	var ping <-chan time.Time
+	if g.ws.PingInterval > 0 {
+		ticker := time.NewTicker(g.ws.PingInterval)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+
+	for {
+		select {
+		case message := <-c.Send:
+			_ = c.Conn.SetWriteDeadline(g.ws.writeDeadline())
+			if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				log.Printf("Write error for client %s: %v", c.ID, err)
+				return
+			}
+		case <-ping:
+			if err := c.Conn.WriteControl(websocket.PingMessage, nil, g.ws.writeDeadline()); err != nil {
+				log.Printf("Ping error for client %s: %v", c.ID, err)
+				return
+			}
+		case <-c.Done:
 			return
 		}
 	}
@@ -1035,244 +1346,319 @@ func (c *Client) writePump() {
 
 // HandleHealth provides a health check endpoint
 func (g *Gateway) HandleHealth(w http.ResponseWriter, r *http.Request) {
-	if g.cmd == nil || g.cmd.ProcessState != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte("MCP server not running"))
-		return
-	}
-
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte("OK"))
+	// Kept for existing probes, which restart the gateway when it fails, so it
+	// means the same as /livez
+	g.HandleLivez(w, r)
 }
 
 func main() {
-	var (
-		stdioCmd         []string
-		port             int
-		transport        string
-		authentication   bool
-		httpUpstreamRaw  string
-		httpUpstreamPath string
-	)
-
-	// Show help if no arguments
-	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "Usage: %s --port <port> --transport <transport> [--authentication] --stdio <command> [args...]\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "\nOptions:\n")
-		fmt.Fprintf(os.Stderr, "  --port <port>         Port to listen on (default: 8000)\n")
-		fmt.Fprintf(os.Stderr, "  --transport <transport> Connection transport: 'websocket' or 'http-stream' (default: websocket)\n")
-		fmt.Fprintf(os.Stderr, "  --authentication      Enable OAuth callback proxy (forwards non-MCP requests to port 12849)\n")
-		fmt.Fprintf(os.Stderr, "  --http-upstream <url> Fixed loopback HTTP MCP upstream URL to proxy instead of stdio JSON-RPC\n")
-		fmt.Fprintf(os.Stderr, "  --http-upstream-path <path> Public MCP path to allow for HTTP upstream mode (default: upstream path)\n")
-		fmt.Fprintf(os.Stderr, "  --stdio <command>     MCP server command to run\n")
-		fmt.Fprintf(os.Stderr, "\nExamples:\n")
-		fmt.Fprintf(os.Stderr, "  WebSocket transport:\n")
-		fmt.Fprintf(os.Stderr, "    %s --port 8000 --transport websocket --stdio npx -y @modelcontextprotocol/server-filesystem /path\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "  HTTP streaming transport:\n")
-		fmt.Fprintf(os.Stderr, "    %s --port 8000 --transport http-stream --stdio npx -y @modelcontextprotocol/server-filesystem /path\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "  With OAuth authentication (mcp-remote):\n")
-		fmt.Fprintf(os.Stderr, "    %s --port 8000 --transport http-stream --authentication --stdio mcp-remote https://example.com/mcp\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "  Existing HTTP MCP upstream on loopback:\n")
-		fmt.Fprintf(os.Stderr, "    %s --port 8000 --transport http-stream --http-upstream http://127.0.0.1:8081/mcp --stdio go run ./cmd/dummy_mcp\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "\nNote: Everything after --stdio is passed to the subprocess\n")
+	cfg, err := loadConfig(os.Args[1:], os.Getenv)
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		writeUsage(os.Stdout, os.Args[0])
+		return
+	case errors.Is(err, errPrintConfig):
+		if err := cfg.writeJSON(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case err != nil && len(os.Args) < 2:
+		// Show help if no arguments
+		writeUsage(os.Stderr, os.Args[0])
+		os.Exit(1)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "Error: %v\nRun %s --help for usage\n", err, os.Args[0])
 		os.Exit(1)
 	}
 
-	// Custom flag parsing to handle everything after --stdio as subprocess args
-	args := os.Args[1:]
-	portStr := "8000"
-	transport = "websocket"
-	authentication = false
-
-	// Find --port flag if present
-	for i := 0; i < len(args); i++ {
-		if args[i] == "--port" && i+1 < len(args) {
-			portStr = args[i+1]
-			break
-		}
+	level, _ := parseLogLevel(cfg.LogLevel)
+	logRedactor := newRedactor(os.Environ(), cfg.RedactEnv, cfg.RedactPaths)
+	if err := setupLogging(os.Stderr, cfg.LogFormat, level, cfg.LogPayloads, logRedactor); err != nil {
+		log.Fatal(err)
 	}
-
-	// Find --transport flag if present
-	for i := 0; i < len(args); i++ {
-		if args[i] == "--transport" && i+1 < len(args) {
-			transport = args[i+1]
-			break
-		}
+	for _, warning := range cfg.warnings {
+		log.Printf("Warning: %s", warning)
 	}
 
-	// Find --authentication flag if present
-	for i := 0; i < len(args); i++ {
-		if args[i] == "--authentication" {
-			authentication = true
-			break
+	// In client mode the remote server is exposed on stdin/stdout
+	if cfg.Connect != "" {
+		headers, err := parseConnectHeaders(cfg.Headers)
+		if err != nil {
+			log.Fatal(err)
 		}
-	}
-
-	// Find --http-upstream flag if present
-	for i := 0; i < len(args); i++ {
-		if args[i] == "--http-upstream" && i+1 < len(args) {
-			httpUpstreamRaw = args[i+1]
-			break
+		if cfg.BearerToken != "" {
+			headers.Set("Authorization", "Bearer "+cfg.BearerToken)
 		}
-	}
-
-	// Find --http-upstream-path flag if present
-	for i := 0; i < len(args); i++ {
-		if args[i] == "--http-upstream-path" && i+1 < len(args) {
-			httpUpstreamPath = args[i+1]
-			break
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		log.Printf("Connecting stdio to %s", cfg.Connect)
+		if err := runConnect(ctx, connectConfig{URL: cfg.Connect, Headers: headers}, os.Stdin, os.Stdout); err != nil && !errors.Is(err, context.Canceled) {
+			log.Fatalf("Client mode failed: %v", err)
 		}
+		return
 	}
 
-	if transport != "websocket" && transport != "http-stream" {
-		log.Fatalf("Invalid transport: %s. Must be 'websocket' or 'http-stream'", transport)
-	}
-
-	// Parse port
-	_, _ = fmt.Sscanf(portStr, "%d", &port)
+	// The options were validated while loading, so parsing them again cannot fail
+	transports, _ := parseTransports(cfg.Transport)
+	httpUpstreamConfig, _ := ParseHTTPUpstreamConfig(cfg.HTTPUpstream, cfg.HTTPUpstreamPath)
 
-	// Override with environment variable if set
-	if envPort := os.Getenv("PORT"); envPort != "" {
-		_, _ = fmt.Sscanf(envPort, "%d", &port)
+	authenticator, err := newAuthenticator(authConfig{
+		Tokens:     cfg.AuthTokens,
+		TokensFile: cfg.AuthTokensFile,
+		JWKS:       cfg.AuthJWKS,
+		Issuer:     cfg.AuthIssuer,
+		Audience:   cfg.AuthAudience,
+		Scopes:     cfg.AuthScopes,
+		Resource:   cfg.AuthResource,
+	})
+	if err != nil {
+		log.Fatalf("Invalid authentication config: %v", err)
 	}
 
-	// Override transport with environment variable if set
-	if envMode := os.Getenv("TRANSPORT"); envMode != "" {
-		if envMode == "websocket" || envMode == "http-stream" {
-			transport = envMode
+	var toolFilterCfg toolFilterConfig
+	if cfg.ToolsConfig != "" {
+		if toolFilterCfg, err = loadToolFilterConfig(cfg.ToolsConfig); err != nil {
+			log.Fatal(err)
 		}
 	}
-
-	// Find --stdio flag and capture everything after it
-	stdioIndex := -1
-	for i := 0; i < len(args); i++ {
-		if args[i] == "--stdio" {
-			stdioIndex = i
-			break
+	toolFilterCfg.Allow = append(toolFilterCfg.Allow, cfg.ToolsAllow...)
+	toolFilterCfg.Deny = append(toolFilterCfg.Deny, cfg.ToolsDeny...)
+	if cfg.ToolsPrefix != "" {
+		toolFilterCfg.Prefix = cfg.ToolsPrefix
+	}
+	renames, _ := parseToolRenames(cfg.ToolsRename)
+	for original, exposed := range renames {
+		if toolFilterCfg.Rename == nil {
+			toolFilterCfg.Rename = make(map[string]string)
 		}
+		toolFilterCfg.Rename[original] = exposed
 	}
-
-	if stdioIndex == -1 || stdioIndex+1 >= len(args) {
-		log.Fatal("--stdio flag is required with at least one argument")
+	tools, err := newToolFilter(toolFilterCfg)
+	if err != nil {
+		log.Fatalf("Invalid tool filter config: %v", err)
+	}
+	if tools != nil && httpUpstreamConfig != nil {
+		log.Fatal("Tool filtering cannot be combined with --http-upstream")
 	}
 
-	// Everything after --stdio is the command and its arguments
-	stdioCmd = args[stdioIndex+1:]
+	outbound := outboundConfig{QueueSize: cfg.SendQueueSize, Policy: cfg.SendOverflow, Timeout: cfg.SendTimeout}
+	ws := wsConfig{PingInterval: cfg.WSPingInterval, PongTimeout: cfg.WSPongTimeout, MaxMessageSize: cfg.WSMaxMessageSize}
+	ws.AllowedOrigins, _ = parseAllowedOrigins(cfg.WSAllowedOrigins)
+	restarts := restartPolicy{
+		Mode:         cfg.Restart,
+		MaxRestarts:  cfg.MaxRestarts,
+		Window:       cfg.RestartWindow,
+		Backoff:      cfg.RestartBackoff,
+		MaxBackoff:   cfg.MaxRestartBackoff,
+		HealthyAfter: cfg.RestartHealthyAfter,
+	}
+	handshake := handshakeConfig{InitializeMode: cfg.InitializeMode, Timeout: cfg.ReadinessTimeout, Skip: cfg.SkipReadinessCheck}
+	handshake.ProtocolVersions, _ = parseProtocolVersions(cfg.ProtocolVersions)
 
-	httpUpstreamConfig, err := ParseHTTPUpstreamConfig(httpUpstreamRaw, httpUpstreamPath)
+	gateway := NewGateway()
+	gateway.tools = tools
+	gateway.limiter = newLimiter(cfg.rateLimits())
+	gateway.outbound = outbound
+	gateway.ws = ws
+	gateway.restartPolicy = restarts
+	gateway.handshake = handshake
+	gateway.responseTimeout = cfg.ResponseTimeout
+	gateway.restarts = newRestartTracker(restarts)
+	gateway.upgrader.EnableCompression = cfg.WSCompression
+	gateway.sessions = NewSessionManager(cfg.SessionIdleTimeout, cfg.MaxSessions)
+	gateway.sessions.replaySize = cfg.ReplayBufferSize
+	gateway.sessionMode = cfg.SessionMode
+	gateway.processes = newProcessPool(cfg.MaxProcesses, cfg.ProcessIdleTimeout)
+	gateway.tracer, err = newTracerFromConfig(cfg.TraceExporter, cfg.TraceEndpoint, cfg.TraceFile)
 	if err != nil {
-		log.Fatalf("Invalid HTTP upstream config: %v", err)
+		log.Fatalf("Invalid tracing config: %v", err)
 	}
-	if httpUpstreamConfig != nil && transport != "http-stream" {
-		log.Fatal("--http-upstream requires --transport http-stream")
+	gateway.redact = logRedactor
+	if cfg.Record != "" {
+		if gateway.recorder, err = newCassetteRecorder(cfg.Record, logRedactor); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Recording MCP traffic to %s", cfg.Record)
 	}
-	if httpUpstreamConfig != nil && authentication {
-		log.Fatal("--http-upstream cannot be combined with --authentication")
+	if cfg.Replay != "" {
+		if gateway.replay, err = loadCassette(cfg.Replay); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Replaying MCP server from %s", cfg.Replay)
 	}
 
-	gateway := NewGateway()
+	if cfg.SessionMode == sessionModeIsolated {
+		// Children are started per session, so there is nothing to wait for yet
+		if err := gateway.prepareCommand(cfg.Stdio); err != nil {
+			log.Fatalf("Invalid MCP server command: %v", err)
+		}
+		log.Printf("Isolated session mode: each session starts its own MCP server")
+	} else {
+		// Start the MCP server
+		if err := gateway.StartMCPServer(cfg.Stdio); err != nil {
+			log.Fatalf("Failed to start MCP server: %v", err)
+		}
 
-	// Start the MCP server
-	if err := gateway.StartMCPServer(stdioCmd); err != nil {
-		log.Fatalf("Failed to start MCP server: %v", err)
+		// Wait for MCP server to be ready unless the check is skipped
+		if !handshake.Skip {
+			var err error
+			if httpUpstreamConfig != nil {
+				err = gateway.WaitForHTTPUpstreamReady(httpUpstreamConfig.URL, handshake.Timeout)
+			} else {
+				err = gateway.WaitForReady(handshake.Timeout)
+			}
+			if err != nil {
+				gateway.recordError("Warning: MCP server readiness check failed: %v", err)
+				log.Printf("Continuing anyway - server may not be fully initialized")
+				// Don't fail, just warn - some servers have issues with stdio responses
+				if httpUpstreamConfig == nil {
+					go gateway.retryHandshake(gateway.sharedChild(), defaultHandshakeRetry)
+				}
+			}
+		} else {
+			log.Printf("Skipping readiness check")
+			// Give the server a moment to initialize
+			time.Sleep(2 * time.Second)
+			gateway.sharedChild().markInitialized(nil)
+		}
 	}
 
-	// Wait for MCP server to be ready (30 second timeout)
-	// Skip readiness check if SKIP_READINESS_CHECK env var is set
-	if os.Getenv("SKIP_READINESS_CHECK") != "true" {
-		var err error
-		if httpUpstreamConfig != nil {
-			err = gateway.WaitForHTTPUpstreamReady(httpUpstreamConfig.URL, 30*time.Second)
+	// Start the gateway's main loop
+	go gateway.Run()
+
+	// Only a shared stdio child answers the gateway's own pings
+	if cfg.ReadinessProbeInterval > 0 {
+		if cfg.SessionMode == sessionModeIsolated || httpUpstreamConfig != nil {
+			log.Printf("Readiness probe is only supported for a shared stdio MCP server, ignoring --readiness-probe-interval")
 		} else {
-			err = gateway.WaitForReady(30 * time.Second)
+			log.Printf("Probing MCP server readiness every %s", cfg.ReadinessProbeInterval)
+			go gateway.RunReadinessProbe(cfg.ReadinessProbeInterval)
 		}
-		if err != nil {
-			log.Printf("Warning: MCP server readiness check failed: %v", err)
-			log.Printf("Continuing anyway - server may not be fully initialized")
-			// Don't fail, just warn - some servers have issues with stdio responses
+	}
+
+	// Expire idle HTTP sessions in the background
+	if cfg.SessionIdleTimeout > 0 {
+		sweepInterval := cfg.SessionIdleTimeout / 2
+		if sweepInterval > time.Minute {
+			sweepInterval = time.Minute
 		}
-	} else {
-		log.Printf("Skipping readiness check (SKIP_READINESS_CHECK=true)")
-		// Give the server a moment to initialize
-		time.Sleep(2 * time.Second)
+		go gateway.RunSessionSweeper(sweepInterval)
 	}
 
-	// Start the gateway's main loop
-	go gateway.Run()
+	// Stop idle per-session MCP servers in the background
+	if cfg.SessionMode == sessionModeIsolated && cfg.ProcessIdleTimeout > 0 {
+		reapInterval := cfg.ProcessIdleTimeout / 2
+		if reapInterval > time.Minute {
+			reapInterval = time.Minute
+		}
+		go gateway.RunProcessReaper(reapInterval)
+	}
 
-	// Start health check on separate port (port + 1) like Node.js version
+	// Start health check on a separate port, port + 1 by default like the Node.js version
+	healthMux := http.NewServeMux()
+	healthMux.HandleFunc("/health", gateway.HandleHealth)
+	healthMux.HandleFunc("/livez", gateway.HandleLivez)
+	healthMux.HandleFunc("/readyz", gateway.HandleReadyz)
+	healthMux.HandleFunc("/status", gateway.HandleStatus)
+	healthMux.HandleFunc("/metrics", gateway.HandleMetrics)
+	healthServer := &http.Server{Addr: fmt.Sprintf(":%d", cfg.healthPort()), Handler: healthMux}
 	go func() {
-		healthMux := http.NewServeMux()
-		healthMux.HandleFunc("/health", gateway.HandleHealth)
-		log.Printf("Health check endpoint listening on port %d", port+1)
-		if err := http.ListenAndServe(fmt.Sprintf(":%d", port+1), healthMux); err != nil {
+		log.Printf("Health check endpoint listening on port %d", cfg.healthPort())
+		log.Printf("Metrics endpoint: http://localhost:%d/metrics", cfg.healthPort())
+		if err := healthServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Printf("Failed to start health server: %v", err)
 		}
 	}()
 
-	// Handle graceful shutdown
+	// Handle graceful shutdown once the server is up
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-sigChan
-		log.Println("Shutting down...")
-		gateway.shouldRestart = false
-		if gateway.cmd != nil && gateway.cmd.Process != nil {
-			_ = gateway.cmd.Process.Kill()
-		}
-		os.Exit(0)
-	}()
 
 	log.Printf("Starting...")
-	log.Printf("  - port: %d", port)
-	log.Printf("  - transport: %s", transport)
+	log.Printf("  - port: %d", cfg.Port)
+	log.Printf("  - transport: %s", cfg.Transport)
+	log.Printf("  - session mode: %s", cfg.SessionMode)
+	log.Printf("  - trace exporter: %s", cfg.TraceExporter)
+	log.Printf("  - inbound auth: %t", authenticator != nil)
+	log.Printf("  - tool filter: %t", tools != nil)
+	log.Printf("  - rate limits: %t", gateway.limiter != nil)
+	log.Printf("  - send queue: %d messages, overflow policy %s", outbound.QueueSize, outbound.Policy)
 
 	var handler http.Handler
-	if transport == "websocket" {
-		log.Printf("WebSocket endpoint: ws://localhost:%d", port)
+	webSocket := authenticator.Wrap(http.HandlerFunc(gateway.HandleWebSocket))
+	if transports[transportWebSocket] {
+		log.Printf("WebSocket endpoint: ws://localhost:%d", cfg.Port)
+	}
+	if len(transports) == 1 && transports[transportWebSocket] {
 		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if r.Header.Get("Upgrade") == "websocket" {
-				gateway.HandleWebSocket(w, r)
+				webSocket.ServeHTTP(w, r)
+			} else if authenticator != nil && r.URL.Path == protectedResourcePath {
+				authenticator.HandleProtectedResourceMetadata("/")(w, r)
 			} else {
 				http.Error(w, "This server only accepts WebSocket connections", http.StatusBadRequest)
 			}
 		})
 	} else {
-		log.Printf("HTTP streaming endpoints:")
+		log.Printf("HTTP endpoints:")
 
 		mux := http.NewServeMux()
-		if httpUpstreamConfig != nil {
-			log.Printf("  - MCP endpoint: configured HTTP upstream path")
-			mux.Handle(httpUpstreamConfig.PublicPath, gateway.HandleHTTPUpstream(httpUpstreamConfig))
-		} else {
-			log.Printf("  - MCP endpoint: POST http://localhost:%d/mcp", port)
-			mux.HandleFunc("/mcp", func(w http.ResponseWriter, r *http.Request) {
-				accept := r.Header.Get("Accept")
-				// Require Accept to indicate support (also accept wildcard */* and empty Accept)
-				if accept != "" && !strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/event-stream") && !strings.Contains(accept, "*/*") && r.Method == http.MethodPost {
-					http.Error(w, "Unsupported Accept header", http.StatusNotAcceptable)
-					return
-				}
+		mcpEndpoint := "/mcp"
+		if transports[transportHTTPStream] {
+			if httpUpstreamConfig != nil {
+				log.Printf("  - MCP endpoint: configured HTTP upstream path")
+				mux.Handle(httpUpstreamConfig.PublicPath, authenticator.Wrap(gateway.HandleHTTPUpstream(httpUpstreamConfig)))
+				mcpEndpoint = httpUpstreamConfig.PublicPath
+			} else {
+				log.Printf("  - MCP endpoint: POST/GET/DELETE http://localhost:%d/mcp", cfg.Port)
+				mux.Handle("/mcp", authenticator.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					accept := r.Header.Get("Accept")
+					// Require Accept to indicate support (also accept wildcard */* and empty Accept)
+					if accept != "" && !strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/event-stream") && !strings.Contains(accept, "*/*") && r.Method == http.MethodPost {
+						http.Error(w, "Unsupported Accept header", http.StatusNotAcceptable)
+						return
+					}
 
-				if r.Method == http.MethodGet {
-					http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-					return
-				}
-				if r.Method == http.MethodPost {
-					gateway.HandleHTTPMessage(w, r)
-					return
-				}
-				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			})
+					switch r.Method {
+					case http.MethodPost:
+						gateway.HandleHTTPMessage(w, r)
+					case http.MethodGet:
+						gateway.HandleHTTPSessionStream(w, r)
+					case http.MethodDelete:
+						gateway.HandleHTTPSessionDelete(w, r)
+					default:
+						http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+					}
+				})))
+			}
+		}
+		if transports[transportSSE] {
+			log.Printf("  - SSE endpoint: GET http://localhost:%d%s", cfg.Port, legacySSEPath)
+			log.Printf("  - SSE messages endpoint: POST http://localhost:%d%s?sessionId=...", cfg.Port, legacyMessagesPath)
+			mux.Handle(legacySSEPath, authenticator.Wrap(http.HandlerFunc(gateway.HandleSSE)))
+			mux.Handle(legacyMessagesPath, authenticator.Wrap(http.HandlerFunc(gateway.HandleSSEMessage)))
+			if !transports[transportHTTPStream] {
+				mcpEndpoint = legacySSEPath
+			}
 		}
 
-		mcpEndpoint := "/mcp"
-		if httpUpstreamConfig != nil {
-			mcpEndpoint = httpUpstreamConfig.PublicPath
+		// The root path describes the endpoints being served
+		serverInfo := map[string]interface{}{
+			"transport": cfg.Transport,
+			"endpoint":  mcpEndpoint,
+		}
+		if transports[transportSSE] {
+			serverInfo["sseEndpoint"] = legacySSEPath
+			serverInfo["messagesEndpoint"] = legacyMessagesPath
+		}
+
+		if authenticator != nil {
+			mux.HandleFunc(protectedResourcePath, authenticator.HandleProtectedResourceMetadata(mcpEndpoint))
+			log.Printf("  - Protected resource metadata: http://localhost:%d%s", cfg.Port, protectedResourcePath)
 		}
 
 		// Set up OAuth proxy if authentication flag is enabled
-		if authentication {
+		if cfg.Authentication {
 			// Create a reverse proxy for OAuth callbacks (mcp-remote)
 			oauthProxyURL, _ := url.Parse("http://localhost:12849")
 			oauthProxy := httputil.NewSingleHostReverseProxy(oauthProxyURL)
@@ -1292,10 +1678,7 @@ func main() {
 				if r.URL.Path == "/" {
 					// Root path returns server info
 					w.Header().Set("Content-Type", "application/json")
-					json.NewEncoder(w).Encode(map[string]interface{}{
-						"transport": "http-stream",
-						"endpoint":  mcpEndpoint,
-					})
+					json.NewEncoder(w).Encode(serverInfo)
 					return
 				}
 
@@ -1303,7 +1686,7 @@ func main() {
 				oauthProxy.ServeHTTP(w, r)
 			})
 
-			log.Printf("  - OAuth callback proxy: http://localhost:%d/* -> http://localhost:12849/*", port)
+			log.Printf("  - OAuth callback proxy: http://localhost:%d/* -> http://localhost:12849/*", cfg.Port)
 		} else {
 			mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 				if r.URL.Path != "/" {
@@ -1311,19 +1694,40 @@ func main() {
 					return
 				}
 				w.Header().Set("Content-Type", "application/json")
-				json.NewEncoder(w).Encode(map[string]interface{}{
-					"transport": "http-stream",
-					"endpoint":  mcpEndpoint,
-				})
+				json.NewEncoder(w).Encode(serverInfo)
 			})
 		}
 
 		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// WebSocket upgrades are accepted on any path when enabled
+			if transports[transportWebSocket] && r.Header.Get("Upgrade") == "websocket" {
+				webSocket.ServeHTTP(w, r)
+				return
+			}
 			mux.ServeHTTP(w, r)
 		})
 	}
 
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), handler); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	server := &http.Server{Addr: fmt.Sprintf(":%d", cfg.Port), Handler: handler}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	<-sigChan
+	log.Printf("Shutting down, waiting up to %s for in-flight requests...", cfg.ShutdownTimeout)
+	go func() {
+		// A second signal skips the grace period
+		<-sigChan
+		log.Printf("Forced shutdown")
+		os.Exit(1)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	gateway.Shutdown(ctx, cfg.ChildStopTimeout, server, healthServer)
+	if err := gateway.recorder.Close(); err != nil {
+		log.Printf("Failed to close cassette: %v", err)
 	}
+	log.Printf("Shutdown complete")
 }
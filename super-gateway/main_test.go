@@ -1,7 +1,10 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -202,3 +205,72 @@ func TestDialLoopbackOnlyRejectsNonLoopback(t *testing.T) {
 		t.Fatalf("expected non-loopback error, got %v", err)
 	}
 }
+
+func TestSplitCommandLine(t *testing.T) {
+	for line, want := range map[string]string{
+		"node server.js":                    "node|server.js",
+		"  node\tserver.js  ":               "node|server.js",
+		`node server.js --name "My Server"`: "node|server.js|--name|My Server",
+		`echo 'it''s' "a \"b\" \c" x\ y ""`: `echo|its|a "b" \c|x y|`,
+		`--tools='a b',c`:                   "--tools=a b,c",
+	} {
+		got, err := splitCommandLine(line)
+		if err != nil || strings.Join(got, "|") != want {
+			t.Errorf("splitCommandLine(%q) = %q, %v, want %s", line, got, err, want)
+		}
+	}
+	for _, line := range []string{`node "server.js`, `node 'server.js`, `node server.js\`} {
+		if _, err := splitCommandLine(line); err == nil {
+			t.Errorf("splitCommandLine(%q) accepted", line)
+		}
+	}
+}
+
+// newTestGateway returns a running gateway whose child is emulated by reply,
+// which receives every message written to the child's stdin and returns the
+// lines the child prints in response.
+func newTestGateway(t *testing.T, reply func(msg JSONRPCMessage) []string) *Gateway {
+	t.Helper()
+	g := NewGateway()
+	stdinReader, stdinWriter := io.Pipe()
+	t.Cleanup(func() { _ = stdinWriter.Close() })
+	g.child = &childProcess{stdinWriter: bufio.NewWriter(stdinWriter)}
+
+	go g.Run()
+	go func() {
+		scanner := bufio.NewScanner(stdinReader)
+		for scanner.Scan() {
+			var msg JSONRPCMessage
+			if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+				continue
+			}
+			for _, line := range reply(msg) {
+				g.handleChildLine(g.child, line)
+			}
+		}
+	}()
+	return g
+}
+
+// echoReply emulates a child that answers every request with an empty result
+// and ignores notifications
+func echoReply(msg JSONRPCMessage) []string {
+	if msg.ID == nil {
+		return nil
+	}
+	return []string{fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":{}}`, msg.ID)}
+}
+
+// mcpHandler serves the gateway's Streamable HTTP endpoint like /mcp does
+func mcpHandler(g *Gateway) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			g.HandleHTTPMessage(w, r)
+		case http.MethodGet:
+			g.HandleHTTPSessionStream(w, r)
+		case http.MethodDelete:
+			g.HandleHTTPSessionDelete(w, r)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outcomes of a forwarded request as reported in mcp_gateway_requests_total
+const (
+	outcomeSuccess   = "success"
+	outcomeError     = "error"
+	outcomeTimeout   = "timeout"
+	outcomeCancelled = "cancelled"
+)
+
+// requestDurationBuckets are the upper bounds, in seconds, of the request
+// latency histogram. Tool calls that hit external APIs can take minutes, so
+// the buckets reach up to the default response timeout.
+var requestDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// knownMethods are the JSON-RPC methods reported under their own name. Any
+// other method is reported as "other" so clients cannot create series at will.
+var knownMethods = map[string]bool{
+	"initialize":               true,
+	"ping":                     true,
+	"tools/list":               true,
+	"tools/call":               true,
+	"resources/list":           true,
+	"resources/templates/list": true,
+	"resources/read":           true,
+	"resources/subscribe":      true,
+	"resources/unsubscribe":    true,
+	"prompts/list":             true,
+	"prompts/get":              true,
+	"completion/complete":      true,
+	"logging/setLevel":         true,
+}
+
+// procClockTicks is the kernel's USER_HZ, the unit of CPU times in
+// /proc/<pid>/stat. It is 100 on every architecture Linux supports.
+const procClockTicks = 100
+
+// requestSeries identifies the request metrics of one method and tool
+type requestSeries struct {
+	Method string
+	Tool   string
+}
+
+// histogram is a Prometheus histogram over requestDurationBuckets
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// gatewayMetrics holds the counters the gateway records as traffic flows.
+// Gauges such as the number of sessions are read from the gateway's own state
+// when metrics are scraped.
+type gatewayMetrics struct {
+	mu        sync.Mutex
+	requests  map[requestSeries]map[string]uint64 // outcome -> count
+	durations map[requestSeries]*histogram
+	dropped   map[string]uint64 // transport -> count
+	limited   map[string]uint64 // rate limit reason -> count
+	slow      map[string]uint64 // transport -> clients disconnected
+	restarts  uint64
+}
+
+func newGatewayMetrics() *gatewayMetrics {
+	return &gatewayMetrics{
+		requests:  make(map[requestSeries]map[string]uint64),
+		durations: make(map[requestSeries]*histogram),
+		dropped:   make(map[string]uint64),
+		limited:   make(map[string]uint64),
+		slow:      make(map[string]uint64),
+	}
+}
+
+// observeRequest records the outcome and latency of a forwarded request
+func (m *gatewayMetrics) observeRequest(route *requestRoute, outcome string) {
+	series := requestSeries{Method: metricMethod(route.Method), Tool: route.Tool}
+	seconds := time.Since(route.CreatedAt).Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	outcomes, ok := m.requests[series]
+	if !ok {
+		outcomes = make(map[string]uint64)
+		m.requests[series] = outcomes
+	}
+	outcomes[outcome]++
+
+	h, ok := m.durations[series]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(requestDurationBuckets))}
+		m.durations[series] = h
+	}
+	for i, bound := range requestDurationBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// droppedMessage records a message dropped because a client's send channel
+// was full
+func (m *gatewayMetrics) droppedMessage(transport string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dropped[transport]++
+}
+
+// slowClientDisconnected records a client disconnected because its send
+// queue was full
+func (m *gatewayMetrics) slowClientDisconnected(transport string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.slow[transport]++
+}
+
+// rateLimited records a request rejected by a rate limit
+func (m *gatewayMetrics) rateLimited(reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.limited[reason]++
+}
+
+// childRestarted records a restart of the shared child or a session's child
+func (m *gatewayMetrics) childRestarted() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.restarts++
+}
+
+// restartCount is the number of child restarts so far
+func (m *gatewayMetrics) restartCount() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.restarts
+}
+
+// metricMethod maps a JSON-RPC method to its label value
+func metricMethod(method string) string {
+	if knownMethods[method] {
+		return method
+	}
+	return "other"
+}
+
+// toolName returns the name of the tool a tools/call request invokes
+func toolName(msg JSONRPCMessage) string {
+	if msg.Method != "tools/call" {
+		return ""
+	}
+	var params struct {
+		Name string `json:"name"`
+	}
+	_ = json.Unmarshal(msg.Params, &params)
+	return params.Name
+}
+
+// HandleMetrics exposes the gateway's metrics in the Prometheus text format
+func (g *Gateway) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	g.writeMetrics(w)
+}
+
+func (g *Gateway) writeMetrics(w io.Writer) {
+	m := g.metrics
+	m.mu.Lock()
+
+	writeMetricHeader(w, "mcp_gateway_requests_total", "counter", "Requests forwarded to the MCP server by method, tool and outcome.")
+	for _, series := range sortedSeries(m.requests) {
+		outcomes := m.requests[series]
+		names := make([]string, 0, len(outcomes))
+		for outcome := range outcomes {
+			names = append(names, outcome)
+		}
+		sort.Strings(names)
+		for _, outcome := range names {
+			fmt.Fprintf(w, "mcp_gateway_requests_total{method=%s,tool=%s,outcome=%s} %d\n",
+				quoteLabel(series.Method), quoteLabel(series.Tool), quoteLabel(outcome), outcomes[outcome])
+		}
+	}
+
+	writeMetricHeader(w, "mcp_gateway_request_duration_seconds", "histogram", "Time from forwarding a request to its reply, timeout or cancellation.")
+	for _, series := range sortedSeries(m.durations) {
+		h := m.durations[series]
+		labels := fmt.Sprintf("method=%s,tool=%s", quoteLabel(series.Method), quoteLabel(series.Tool))
+		var cumulative uint64
+		for i, bound := range requestDurationBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "mcp_gateway_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(w, "mcp_gateway_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(w, "mcp_gateway_request_duration_seconds_sum{%s} %s\n", labels, formatFloat(h.sum))
+		fmt.Fprintf(w, "mcp_gateway_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	writeMetricHeader(w, "mcp_gateway_dropped_messages_total", "counter", "Messages dropped because a client's send queue was full, by transport.")
+	transports := make([]string, 0, len(m.dropped))
+	for transport := range m.dropped {
+		transports = append(transports, transport)
+	}
+	sort.Strings(transports)
+	for _, transport := range transports {
+		fmt.Fprintf(w, "mcp_gateway_dropped_messages_total{transport=%s} %d\n", quoteLabel(transport), m.dropped[transport])
+	}
+
+	writeMetricHeader(w, "mcp_gateway_slow_client_disconnects_total", "counter", "Clients disconnected because their send queue was full, by transport.")
+	transports = transports[:0]
+	for transport := range m.slow {
+		transports = append(transports, transport)
+	}
+	sort.Strings(transports)
+	for _, transport := range transports {
+		fmt.Fprintf(w, "mcp_gateway_slow_client_disconnects_total{transport=%s} %d\n", quoteLabel(transport), m.slow[transport])
+	}
+
+	writeMetricHeader(w, "mcp_gateway_rate_limited_requests_total", "counter", "Requests rejected by the gateway's rate limits, by limit.")
+	reasons := make([]string, 0, len(m.limited))
+	for reason := range m.limited {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "mcp_gateway_rate_limited_requests_total{reason=%s} %d\n", quoteLabel(reason), m.limited[reason])
+	}
+
+	writeMetricHeader(w, "mcp_gateway_child_restarts_total", "counter", "Restarts of MCP server processes after they exited unexpectedly.")
+	fmt.Fprintf(w, "mcp_gateway_child_restarts_total %d\n", m.restarts)
+	m.mu.Unlock()
+
+	writeGauge(w, "mcp_gateway_inflight_requests", "Requests waiting for a reply from the MCP server.", g.routes.Len())
+	writeGauge(w, "mcp_gateway_queued_requests", "Requests waiting for an in-flight slot.", g.limiter.Queued())
+	writeGauge(w, "mcp_gateway_sessions", "Active Streamable HTTP sessions.", g.sessions.Len())
+	g.clientsMu.RLock()
+	writeGauge(w, "mcp_gateway_websocket_clients", "Connected WebSocket clients.", len(g.clients))
+	g.clientsMu.RUnlock()
+	g.sseClientsMu.RLock()
+	writeGauge(w, "mcp_gateway_sse_clients", "Open standalone SSE streams.", len(g.sseClients))
+	g.sseClientsMu.RUnlock()
+	g.streamsMu.RLock()
+	writeGauge(w, "mcp_gateway_request_streams", "Requests currently answered as an SSE stream.", len(g.streams))
+	g.streamsMu.RUnlock()
+
+	// Resource usage is summed over every child, so isolated mode reports
+	// the footprint of all sessions together
+	children := g.runningChildren()
+	var rss, cpu float64
+	for _, child := range children {
+		if stats, err := readProcessStats(child.cmd.Process.Pid); err == nil {
+			rss += stats.ResidentBytes
+			cpu += stats.CPUSeconds
+		}
+	}
+	writeGauge(w, "mcp_gateway_child_processes", "Running MCP server processes.", len(children))
+	writeMetricHeader(w, "mcp_gateway_child_resident_memory_bytes", "gauge", "Resident memory of the MCP server processes.")
+	fmt.Fprintf(w, "mcp_gateway_child_resident_memory_bytes %s\n", formatFloat(rss))
+	writeMetricHeader(w, "mcp_gateway_child_cpu_seconds_total", "counter", "CPU time consumed by the running MCP server processes.")
+	fmt.Fprintf(w, "mcp_gateway_child_cpu_seconds_total %s\n", formatFloat(cpu))
+}
+
+// runningChildren returns every child process that has been started and not
+// exited
+func (g *Gateway) runningChildren() []*childProcess {
+	var children []*childProcess
+	if child := g.sharedChild(); child != nil && child.cmd != nil && child.running() {
+		children = append(children, child)
+	}
+	for _, sp := range g.processes.list() {
+		sp.mu.Lock()
+		if sp.child != nil && sp.child.running() {
+			children = append(children, sp.child)
+		}
+		sp.mu.Unlock()
+	}
+	return children
+}
+
+func sortedSeries[V any](m map[requestSeries]V) []requestSeries {
+	series := make([]requestSeries, 0, len(m))
+	for s := range m {
+		series = append(series, s)
+	}
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].Method != series[j].Method {
+			return series[i].Method < series[j].Method
+		}
+		return series[i].Tool < series[j].Tool
+	})
+	return series
+}
+
+func writeMetricHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeGauge(w io.Writer, name, help string, value int) {
+	writeMetricHeader(w, name, "gauge", help)
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
+
+// quoteLabel quotes a label value as the Prometheus text format requires
+func quoteLabel(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return `"` + value + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// processStats is the resource usage of a process
+type processStats struct {
+	ResidentBytes float64
+	CPUSeconds    float64
+}
+
+// readProcessStats reads a process's resident memory and CPU time from
+// /proc, which only exists on Linux; elsewhere it returns an error
+func readProcessStats(pid int) (processStats, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return processStats{}, err
+	}
+
+	// The command name in parentheses may contain spaces, so fields are
+	// counted from the closing parenthesis, which is followed by field 3
+	end := strings.LastIndexByte(string(data), ')')
+	if end < 0 {
+		return processStats{}, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 22 {
+		return processStats{}, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return processStats{}, err
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return processStats{}, err
+	}
+	rssPages, err := strconv.ParseUint(fields[21], 10, 64)
+	if err != nil {
+		return processStats{}, err
+	}
+
+	return processStats{
+		ResidentBytes: float64(rssPages) * float64(os.Getpagesize()),
+		CPUSeconds:    float64(utime+stime) / procClockTicks,
+	}, nil
+}
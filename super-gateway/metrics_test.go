@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func scrapeMetrics(t *testing.T, g *Gateway) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	g.HandleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	return rec.Body.String()
+}
+
+func TestMetricsCountRequestsByMethodAndTool(t *testing.T) {
+	g := newTestGateway(t, func(msg JSONRPCMessage) []string {
+		if toolName(msg) == "fail" {
+			return []string{fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"error":{"code":-32603,"message":"boom"}}`, msg.ID)}
+		}
+		return echoReply(msg)
+	})
+
+	for _, body := range []string{
+		`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"echo"}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"echo"}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"fail"}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"vendor/custom"}`,
+	} {
+		rec := httptest.NewRecorder()
+		g.HandleHTTPMessage(rec, httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", rec.Code)
+		}
+	}
+
+	metrics := scrapeMetrics(t, g)
+	for _, want := range []string{
+		`mcp_gateway_requests_total{method="tools/call",tool="echo",outcome="success"} 2`,
+		`mcp_gateway_requests_total{method="tools/call",tool="fail",outcome="error"} 1`,
+		`mcp_gateway_requests_total{method="other",tool="",outcome="success"} 1`,
+		`mcp_gateway_request_duration_seconds_bucket{method="tools/call",tool="echo",le="+Inf"} 2`,
+		`mcp_gateway_request_duration_seconds_count{method="tools/call",tool="echo"} 2`,
+		"mcp_gateway_inflight_requests 0",
+		"# TYPE mcp_gateway_request_duration_seconds histogram",
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics missing %q:\n%s", want, metrics)
+		}
+	}
+}
+
+func TestMetricsCountTimeouts(t *testing.T) {
+	g := newTestGateway(t, func(msg JSONRPCMessage) []string { return nil })
+	g.responseTimeout = 50 * time.Millisecond
+
+	rec := httptest.NewRecorder()
+	g.HandleHTTPMessage(rec, httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want 504", rec.Code)
+	}
+
+	want := `mcp_gateway_requests_total{method="tools/list",tool="",outcome="timeout"} 1`
+	if metrics := scrapeMetrics(t, g); !strings.Contains(metrics, want) {
+		t.Fatalf("metrics missing %q:\n%s", want, metrics)
+	}
+}
+
+func TestMetricsReportSessions(t *testing.T) {
+	g := newTestGateway(t, echoReply)
+	server := httptest.NewServer(mcpHandler(g))
+	t.Cleanup(server.Close)
+	initializeSession(t, server.URL)
+	initializeSession(t, server.URL)
+
+	if metrics := scrapeMetrics(t, g); !strings.Contains(metrics, "mcp_gateway_sessions 2\n") {
+		t.Fatalf("metrics do not report 2 sessions:\n%s", metrics)
+	}
+}
+
+func TestMetricsCountDroppedMessages(t *testing.T) {
+	g := NewGateway()
+	g.outbound.Timeout = 10 * time.Millisecond
+	client := &SSEClient{ID: "a", Send: make(chan sseEvent), Done: make(chan struct{})}
+	g.sseClients[client.ID] = client
+
+	g.broadcastMessage([]byte(`{"jsonrpc":"2.0","method":"notifications/tools/list_changed"}`))
+
+	var buf bytes.Buffer
+	g.writeMetrics(&buf)
+	if want := `mcp_gateway_dropped_messages_total{transport="sse"}`; !strings.Contains(buf.String(), want) {
+		t.Fatalf("metrics missing %q:\n%s", want, buf.String())
+	}
+}
+
+func TestQuoteLabel(t *testing.T) {
+	if got, want := quoteLabel("a\"b\\c\nd"), `"a\"b\\c\nd"`; got != want {
+		t.Fatalf("quoteLabel = %s, want %s", got, want)
+	}
+}
+
+func TestToolName(t *testing.T) {
+	msg := JSONRPCMessage{Method: "tools/call", Params: json.RawMessage(`{"name":"search","arguments":{}}`)}
+	if got := toolName(msg); got != "search" {
+		t.Fatalf("toolName = %q, want search", got)
+	}
+	msg.Method = "prompts/get"
+	if got := toolName(msg); got != "" {
+		t.Fatalf("toolName = %q for prompts/get, want empty", got)
+	}
+}
+
+func TestReadProcessStats(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("process stats are read from /proc")
+	}
+	stats, err := readProcessStats(os.Getpid())
+	if err != nil {
+		t.Fatalf("readProcessStats: %v", err)
+	}
+	if stats.ResidentBytes <= 0 {
+		t.Fatalf("ResidentBytes = %v, want > 0", stats.ResidentBytes)
+	}
+	if stats.CPUSeconds < 0 {
+		t.Fatalf("CPUSeconds = %v, want >= 0", stats.CPUSeconds)
+	}
+}
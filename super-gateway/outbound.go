@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Overflow policies for a client whose outbound queue is full
+const (
+	// overflowBlock makes the sender, ultimately the child's stdout reader,
+	// wait for room in the client's queue and drops a message that found no
+	// room within the send timeout
+	overflowBlock = "block"
+	// overflowDisconnect closes the client's connection or stream
+	overflowDisconnect = "disconnect"
+	// overflowDropOldest discards the oldest queued message to make room
+	overflowDropOldest = "drop-oldest"
+)
+
+const (
+	defaultSendQueueSize = 256
+	defaultSendTimeout   = 5 * time.Second
+)
+
+// outboundConfig sizes the per-client outbound queues and decides what
+// happens to messages for a client that does not keep up
+type outboundConfig struct {
+	QueueSize int
+	Policy    string
+	Timeout   time.Duration
+}
+
+// parseOverflowPolicy validates an overflow policy name
+func parseOverflowPolicy(value string) (string, error) {
+	switch value {
+	case overflowBlock, overflowDisconnect, overflowDropOldest:
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid overflow policy %q: must be 'block', 'disconnect' or 'drop-oldest'", value)
+}
+
+// enqueue queues data on a client's outbound queue, applying the overflow
+// policy of g when it is full, and reports whether the message was queued.
+// done is closed once nobody reads the queue anymore; disconnect, if not nil,
+// closes the client.
+func enqueue[T any](g *Gateway, send chan T, done <-chan struct{}, transport, clientID string, data T, disconnect func()) bool {
+	select {
+	case send <- data:
+		return true
+	case <-done:
+		return false
+	default:
+	}
+
+	switch g.outbound.Policy {
+	case overflowDisconnect:
+		g.metrics.droppedMessage(transport)
+		if disconnect == nil {
+			log.Printf("Dropped message for %s client %s: send queue full", transport, clientID)
+			return false
+		}
+		g.metrics.slowClientDisconnected(transport)
+		log.Printf("Disconnecting %s client %s: send queue full", transport, clientID)
+		disconnect()
+		return false
+	case overflowDropOldest:
+		// A full queue stays full while the writer is stuck, so one attempt
+		// to make room is enough
+		select {
+		case <-send:
+			g.metrics.droppedMessage(transport)
+			log.Printf("Dropped oldest queued message for %s client %s: send queue full", transport, clientID)
+		default:
+		}
+		select {
+		case send <- data:
+			return true
+		case <-done:
+			return false
+		default:
+		}
+		g.metrics.droppedMessage(transport)
+		log.Printf("Dropped message for %s client %s: send queue full", transport, clientID)
+		return false
+	}
+
+	// Block the sender, so a child whose output is not read fills its pipe
+	// and slows down instead of losing messages. Each child's output is
+	// delivered by a single reader, which keeps its messages in order.
+	timer := time.NewTimer(g.outbound.Timeout)
+	defer timer.Stop()
+	select {
+	case send <- data:
+		return true
+	case <-done:
+		return false
+	case <-timer.C:
+		g.metrics.droppedMessage(transport)
+		log.Printf("Dropped message for %s client %s: send queue full for %s", transport, clientID, g.outbound.Timeout)
+		return false
+	}
+}
+
+// sendToWebSocket queues data for a WebSocket client
+func (g *Gateway) sendToWebSocket(client *Client, data []byte) bool {
+	return enqueue(g, client.Send, client.Done, "websocket", client.ID, data, func() {
+		g.closeWebSocket(client, websocket.CloseTryAgainLater, "send queue full")
+	})
+}
+
+// sendToSSE queues data for an SSE stream as an event of the client's
+// standalone stream
+func (g *Gateway) sendToSSE(sseClient *SSEClient, data []byte) bool {
+	return g.queueSSE(sseClient, g.recordEvent(sseClient.ID, standaloneStream, data))
+}
+
+// queueSSE queues an event that has already been recorded for an SSE stream
+func (g *Gateway) queueSSE(sseClient *SSEClient, event sseEvent) bool {
+	return enqueue(g, sseClient.Send, sseClient.Done, "sse", sseClient.ID, event, func() { g.unregisterSSEClient(sseClient) })
+}
+
+// sendToStream queues data on the SSE response of a single request. The
+// request's handler owns the response, so under the disconnect policy the
+// message is dropped instead.
+func (g *Gateway) sendToStream(stream *requestStream, data []byte) bool {
+	return enqueue(g, stream.Send, stream.Done, "stream", stream.ClientID, data, nil)
+}
+
+// webSocketClient returns the connected WebSocket client with the given ID
+func (g *Gateway) webSocketClient(clientID string) (*Client, bool) {
+	g.clientsMu.RLock()
+	defer g.clientsMu.RUnlock()
+	client, ok := g.clients[clientID]
+	return client, ok
+}
+
+// sseClient returns the open SSE stream of the given client
+func (g *Gateway) sseClient(clientID string) (*SSEClient, bool) {
+	g.sseClientsMu.RLock()
+	defer g.sseClientsMu.RUnlock()
+	sseClient, ok := g.sseClients[clientID]
+	return sseClient, ok
+}
+
+// removeClient drops a WebSocket client, which stops its writer and with it
+// the connection
+func (g *Gateway) removeClient(client *Client) {
+	g.clientsMu.Lock()
+	defer g.clientsMu.Unlock()
+	if current, ok := g.clients[client.ID]; ok && current == client {
+		delete(g.clients, client.ID)
+		close(client.Done)
+		log.Printf("WebSocket connection closed: %s", client.ID)
+	}
+}
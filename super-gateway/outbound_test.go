@@ -0,0 +1,137 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func newOutboundTestGateway(policy string) (*Gateway, *SSEClient) {
+	g := NewGateway()
+	g.outbound = outboundConfig{QueueSize: 2, Policy: policy, Timeout: 20 * time.Millisecond}
+	sseClient := g.registerSSEClient("a", transportSSE)
+	return g, sseClient
+}
+
+func TestOverflowBlockWaitsForRoom(t *testing.T) {
+	g, sseClient := newOutboundTestGateway(overflowBlock)
+	g.outbound.Timeout = 5 * time.Second
+	g.sendToSSE(sseClient, []byte("1"))
+	g.sendToSSE(sseClient, []byte("2"))
+
+	// The sender waits for the client to make room instead of dropping
+	queued := make(chan bool, 1)
+	go func() { queued <- g.sendToSSE(sseClient, []byte("3")) }()
+	select {
+	case <-queued:
+		t.Fatal("message for a full queue did not wait for room")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	for _, want := range []string{"1", "2", "3"} {
+		select {
+		case event := <-sseClient.Send:
+			if string(event.Data) != want {
+				t.Fatalf("received %s, want %s", event.Data, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("message %s not delivered", want)
+		}
+	}
+	if !<-queued {
+		t.Fatal("message not queued once there was room")
+	}
+}
+
+func TestOverflowBlockDropsAfterTimeout(t *testing.T) {
+	g, sseClient := newOutboundTestGateway(overflowBlock)
+	for _, msg := range []string{"1", "2"} {
+		g.sendToSSE(sseClient, []byte(msg))
+	}
+	start := time.Now()
+	if g.sendToSSE(sseClient, []byte("3")) {
+		t.Fatal("message queued on a full queue")
+	}
+	if elapsed := time.Since(start); elapsed < g.outbound.Timeout {
+		t.Fatalf("sender waited %s, want at least %s", elapsed, g.outbound.Timeout)
+	}
+	if want := `mcp_gateway_dropped_messages_total{transport="sse"} 1`; !strings.Contains(scrapeMetrics(t, g), want) {
+		t.Fatalf("metrics missing %q", want)
+	}
+	if first, second := string((<-sseClient.Send).Data), string((<-sseClient.Send).Data); first != "1" || second != "2" {
+		t.Fatalf("queue holds %s, %s, want 1, 2", first, second)
+	}
+}
+
+func TestOverflowBlockStopsForClosedStream(t *testing.T) {
+	g := NewGateway()
+	g.outbound = outboundConfig{QueueSize: 1, Policy: overflowBlock, Timeout: time.Minute}
+	msg := JSONRPCMessage{}
+	stream := g.openRequestStream("1", "a", &msg)
+	g.sendToStream(stream, []byte("1"))
+
+	queued := make(chan bool, 1)
+	go func() { queued <- g.sendToStream(stream, []byte("2")) }()
+	time.Sleep(20 * time.Millisecond)
+	g.closeRequestStream("1")
+	select {
+	case ok := <-queued:
+		if ok {
+			t.Fatal("message queued for a closed stream")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("sender still waiting on a closed stream")
+	}
+}
+
+func TestOverflowDropOldest(t *testing.T) {
+	g, sseClient := newOutboundTestGateway(overflowDropOldest)
+	for _, msg := range []string{"1", "2", "3"} {
+		if !g.sendToSSE(sseClient, []byte(msg)) {
+			t.Fatalf("message %s not queued", msg)
+		}
+	}
+	if first, second := string((<-sseClient.Send).Data), string((<-sseClient.Send).Data); first != "2" || second != "3" {
+		t.Fatalf("queue holds %s, %s, want 2, 3", first, second)
+	}
+	if want := `mcp_gateway_dropped_messages_total{transport="sse"} 1`; !strings.Contains(scrapeMetrics(t, g), want) {
+		t.Fatalf("metrics missing %q", want)
+	}
+}
+
+func TestOverflowDisconnect(t *testing.T) {
+	g, sseClient := newOutboundTestGateway(overflowDisconnect)
+	g.sendToSSE(sseClient, []byte("1"))
+	g.sendToSSE(sseClient, []byte("2"))
+	if g.sendToSSE(sseClient, []byte("3")) {
+		t.Fatal("message queued on a full queue")
+	}
+
+	select {
+	case <-sseClient.Done:
+	default:
+		t.Fatal("slow SSE client was not disconnected")
+	}
+	if _, ok := g.sseClient("a"); ok {
+		t.Fatal("slow SSE client still registered")
+	}
+	if want := `mcp_gateway_slow_client_disconnects_total{transport="sse"} 1`; !strings.Contains(scrapeMetrics(t, g), want) {
+		t.Fatalf("metrics missing %q", want)
+	}
+
+	// Nobody reads the queue of a closed client anymore
+	if g.sendToSSE(sseClient, []byte("4")) {
+		t.Fatal("message queued for a closed client")
+	}
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	for _, policy := range []string{overflowBlock, overflowDisconnect, overflowDropOldest} {
+		if got, err := parseOverflowPolicy(policy); err != nil || got != policy {
+			t.Fatalf("parseOverflowPolicy(%q) = %q, %v", policy, got, err)
+		}
+	}
+	if _, err := parseOverflowPolicy("drop"); err == nil {
+		t.Fatal("unknown policy accepted")
+	}
+}
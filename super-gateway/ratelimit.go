@@ -0,0 +1,376 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jsonRPCRateLimited is the JSON-RPC error code of requests rejected by the
+// gateway's rate limits
+const jsonRPCRateLimited = -32029
+
+const (
+	// defaultMaxQueue is the number of requests that may wait for an
+	// in-flight slot
+	defaultMaxQueue = 100
+	// defaultQueueTimeout bounds how long a request waits for an in-flight slot
+	defaultQueueTimeout = 30 * time.Second
+	// maxIdleSessionBuckets is the number of per-session buckets kept before
+	// the ones that have refilled completely are dropped
+	maxIdleSessionBuckets = 1024
+)
+
+// rateLimit is a token bucket rate in requests per second with a burst size.
+// A zero rate means unlimited.
+type rateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// rateLimitConfig limits the requests forwarded to the child. Overrides are
+// keyed by method, or by "tools/call:<tool>" for a single tool, and replace
+// the global limit for matching requests; the per-session limit applies to
+// every request.
+type rateLimitConfig struct {
+	Global       rateLimit
+	Session      rateLimit
+	MaxInFlight  int
+	MaxQueue     int
+	QueueTimeout time.Duration
+	Overrides    map[string]rateLimit
+}
+
+// parseRateLimit parses a limit written as "rate" or "rate/burst"
+func parseRateLimit(value string) (rateLimit, error) {
+	rateStr, burstStr, hasBurst := strings.Cut(value, "/")
+	rate, err := strconv.ParseFloat(rateStr, 64)
+	if err != nil || rate < 0 || math.IsInf(rate, 0) || math.IsNaN(rate) {
+		return rateLimit{}, fmt.Errorf("invalid rate %q", value)
+	}
+	limit := rateLimit{Rate: rate}
+	if hasBurst {
+		if limit.Burst, err = strconv.Atoi(burstStr); err != nil || limit.Burst < 1 {
+			return rateLimit{}, fmt.Errorf("invalid burst in %q", value)
+		}
+	}
+	return limit, nil
+}
+
+// parseRateLimitOverrides parses a comma-separated list of key=rate[/burst]
+// overrides, e.g. "tools/call:search=2/5,ping=0"
+func parseRateLimitOverrides(value string) (map[string]rateLimit, error) {
+	overrides := make(map[string]rateLimit)
+	for _, item := range splitList(value) {
+		key, limitStr, ok := strings.Cut(item, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid rate limit override %q: want method[:tool]=rate[/burst]", item)
+		}
+		limit, err := parseRateLimit(limitStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate limit override %q: %w", item, err)
+		}
+		overrides[key] = limit
+	}
+	return overrides, nil
+}
+
+// rateLimitError rejects a request that exceeded a limit
+type rateLimitError struct {
+	// Reason names the limit: "global", "session", an override key,
+	// "queue_full" or "queue_timeout"
+	Reason     string
+	RetryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("Rate limit exceeded (%s), retry after %ds", e.Reason, e.retryAfterSeconds())
+}
+
+// retryAfterSeconds rounds RetryAfter up to whole seconds for the
+// Retry-After header
+func (e *rateLimitError) retryAfterSeconds() int {
+	seconds := int(math.Ceil(e.RetryAfter.Seconds()))
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
+
+// tokenBucket refills at rate tokens per second up to burst
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns nil for an unlimited rate. The burst defaults to
+// one second's worth of requests.
+func newTokenBucket(limit rateLimit, now time.Time) *tokenBucket {
+	if limit.Rate <= 0 {
+		return nil
+	}
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = math.Max(1, math.Ceil(limit.Rate))
+	}
+	return &tokenBucket{rate: limit.Rate, burst: burst, tokens: burst, last: now}
+}
+
+// refill adds the tokens accumulated since the last call and returns how
+// long until a token is available
+func (b *tokenBucket) refill(now time.Time) time.Duration {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// limiter enforces the rate limits and the cap on requests in flight. A nil
+// limiter lets every request through.
+type limiter struct {
+	mu        sync.Mutex
+	global    *tokenBucket
+	session   rateLimit
+	sessions  map[string]*tokenBucket
+	overrides map[string]*tokenBucket
+	// exempt marks override keys whose rate is unlimited
+	exempt map[string]bool
+
+	maxInFlight  int
+	maxQueue     int
+	queueTimeout time.Duration
+	inflight     int
+	// queue holds the requests waiting for a slot, oldest first
+	queue *list.List
+}
+
+// enabled reports whether cfg sets any limit
+func (cfg rateLimitConfig) enabled() bool {
+	return cfg.Global.Rate > 0 || cfg.Session.Rate > 0 || cfg.MaxInFlight > 0 || len(cfg.Overrides) > 0
+}
+
+// newLimiter returns nil when cfg sets no limit
+func newLimiter(cfg rateLimitConfig) *limiter {
+	if !cfg.enabled() {
+		return nil
+	}
+	now := time.Now()
+	l := &limiter{
+		global:       newTokenBucket(cfg.Global, now),
+		session:      cfg.Session,
+		sessions:     make(map[string]*tokenBucket),
+		overrides:    make(map[string]*tokenBucket),
+		exempt:       make(map[string]bool),
+		maxInFlight:  cfg.MaxInFlight,
+		maxQueue:     cfg.MaxQueue,
+		queueTimeout: cfg.QueueTimeout,
+		queue:        list.New(),
+	}
+	if l.queueTimeout <= 0 {
+		l.queueTimeout = defaultQueueTimeout
+	}
+	for key, limit := range cfg.Overrides {
+		if bucket := newTokenBucket(limit, now); bucket != nil {
+			l.overrides[key] = bucket
+		} else {
+			l.exempt[key] = true
+		}
+	}
+	return l
+}
+
+// overrideKey returns the most specific override configured for a request
+func (l *limiter) overrideKey(method, tool string) (string, bool) {
+	if tool != "" {
+		key := method + ":" + tool
+		if _, ok := l.overrides[key]; ok || l.exempt[key] {
+			return key, true
+		}
+	}
+	if _, ok := l.overrides[method]; ok || l.exempt[method] {
+		return method, true
+	}
+	return "", false
+}
+
+// allow takes a token from every bucket that applies to the request, or from
+// none of them when one is empty
+func (l *limiter) allow(clientID, method, tool string, now time.Time) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	type namedBucket struct {
+		reason string
+		bucket *tokenBucket
+	}
+	var buckets []namedBucket
+	if key, ok := l.overrideKey(method, tool); ok {
+		buckets = append(buckets, namedBucket{key, l.overrides[key]})
+	} else {
+		buckets = append(buckets, namedBucket{"global", l.global})
+	}
+	if l.session.Rate > 0 {
+		bucket, ok := l.sessions[clientID]
+		if !ok {
+			l.pruneSessionBuckets(now)
+			bucket = newTokenBucket(l.session, now)
+			l.sessions[clientID] = bucket
+		}
+		buckets = append(buckets, namedBucket{"session", bucket})
+	}
+
+	var rejected *rateLimitError
+	for _, b := range buckets {
+		if b.bucket == nil {
+			continue
+		}
+		if wait := b.bucket.refill(now); wait > 0 && (rejected == nil || wait > rejected.RetryAfter) {
+			rejected = &rateLimitError{Reason: b.reason, RetryAfter: wait}
+		}
+	}
+	if rejected != nil {
+		return rejected
+	}
+	for _, b := range buckets {
+		if b.bucket != nil {
+			b.bucket.tokens--
+		}
+	}
+	return nil
+}
+
+// pruneSessionBuckets drops the buckets of idle sessions once there are many;
+// a full bucket behaves exactly like a new one
+func (l *limiter) pruneSessionBuckets(now time.Time) {
+	if len(l.sessions) < maxIdleSessionBuckets {
+		return
+	}
+	for clientID, bucket := range l.sessions {
+		if bucket.refill(now); bucket.tokens >= bucket.burst {
+			delete(l.sessions, clientID)
+		}
+	}
+}
+
+// forget drops the per-session bucket of a session that has ended
+func (l *limiter) forget(clientID string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.sessions, clientID)
+}
+
+// acquire claims one of the in-flight slots, waiting in FIFO order behind
+// earlier requests while all are taken. The returned function frees the slot
+// and may be called more than once.
+func (l *limiter) acquire() (func(), error) {
+	if l == nil || l.maxInFlight <= 0 {
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	if l.inflight < l.maxInFlight && l.queue.Len() == 0 {
+		l.inflight++
+		l.mu.Unlock()
+		return l.releaser(), nil
+	}
+	if l.queue.Len() >= l.maxQueue {
+		l.mu.Unlock()
+		return nil, &rateLimitError{Reason: "queue_full", RetryAfter: time.Second}
+	}
+	ready := make(chan struct{})
+	waiter := l.queue.PushBack(ready)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	select {
+	case <-ready:
+		return l.releaser(), nil
+	case <-timer.C:
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		select {
+		case <-ready:
+			// The slot was handed over just as the wait timed out
+			return l.releaser(), nil
+		default:
+		}
+		l.queue.Remove(waiter)
+		return nil, &rateLimitError{Reason: "queue_timeout", RetryAfter: time.Second}
+	}
+}
+
+// releaser returns a function that frees one slot exactly once, handing it
+// to the oldest waiting request if there is one
+func (l *limiter) releaser() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if front := l.queue.Front(); front != nil {
+				l.queue.Remove(front)
+				close(front.Value.(chan struct{}))
+				return
+			}
+			l.inflight--
+		})
+	}
+}
+
+// Queued returns the number of requests waiting for an in-flight slot
+func (l *limiter) Queued() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.queue.Len()
+}
+
+// admitRequest applies the rate limits to a request and waits for an
+// in-flight slot, which is released when the request finishes. It returns a
+// nil release function when the request was abandoned while it waited.
+func (g *Gateway) admitRequest(msg JSONRPCMessage, clientID string, id int64) (func(), error) {
+	if err := g.limiter.allow(clientID, msg.Method, toolName(msg), time.Now()); err != nil {
+		g.rateLimited(err)
+		return nil, err
+	}
+	release, err := g.limiter.acquire()
+	if err != nil {
+		g.rateLimited(err)
+		return nil, err
+	}
+	if !g.routes.Hold(id, release) {
+		release()
+		return nil, nil
+	}
+	return release, nil
+}
+
+// rateLimited records a rejection by the rate limits
+func (g *Gateway) rateLimited(err error) {
+	var limitErr *rateLimitError
+	if errors.As(err, &limitErr) {
+		g.metrics.rateLimited(limitErr.Reason)
+		log.Printf("Rejecting request: %v", err)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	if limit, err := parseRateLimit("2.5/10"); err != nil || limit.Rate != 2.5 || limit.Burst != 10 {
+		t.Fatalf("parseRateLimit = %+v, %v", limit, err)
+	}
+	for _, value := range []string{"", "fast", "-1", "1/0", "1/x"} {
+		if _, err := parseRateLimit(value); err == nil {
+			t.Errorf("parseRateLimit(%q) accepted", value)
+		}
+	}
+
+	overrides, err := parseRateLimitOverrides("tools/call:search=2/5, ping=0")
+	if err != nil || overrides["tools/call:search"].Burst != 5 || overrides["ping"].Rate != 0 || len(overrides) != 2 {
+		t.Fatalf("parseRateLimitOverrides = %v, %v", overrides, err)
+	}
+	if _, err := parseRateLimitOverrides("ping"); err == nil {
+		t.Fatal("override without limit accepted")
+	}
+}
+
+func TestLimiterTokenBuckets(t *testing.T) {
+	l := newLimiter(rateLimitConfig{
+		Global:  rateLimit{Rate: 2, Burst: 2},
+		Session: rateLimit{Rate: 10, Burst: 3},
+		Overrides: map[string]rateLimit{
+			"ping":              {Rate: 0},
+			"tools/call:search": {Rate: 1, Burst: 1},
+		},
+	})
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if err := l.allow("a", "tools/list", "", now); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	err := l.allow("b", "tools/list", "", now)
+	var limitErr *rateLimitError
+	if !errors.As(err, &limitErr) || limitErr.Reason != "global" || limitErr.RetryAfter != 500*time.Millisecond {
+		t.Fatalf("third request = %v, want global limit with 500ms retry", err)
+	}
+	if err := l.allow("b", "tools/list", "", now.Add(500*time.Millisecond)); err != nil {
+		t.Fatalf("request after refill: %v", err)
+	}
+
+	// Overrides replace the global bucket; the session bucket still applies
+	if err := l.allow("a", "ping", "", now); err != nil {
+		t.Fatalf("exempt ping: %v", err)
+	}
+	if err := l.allow("c", "tools/call", "search", now); err != nil {
+		t.Fatalf("first search: %v", err)
+	}
+	if err := l.allow("c", "tools/call", "search", now); !errors.As(err, &limitErr) || limitErr.Reason != "tools/call:search" {
+		t.Fatalf("second search = %v, want override limit", err)
+	}
+	if err := l.allow("a", "ping", "", now); !errors.As(err, &limitErr) || limitErr.Reason != "session" {
+		t.Fatalf("fourth request of session a = %v, want session limit", err)
+	}
+
+	// A rejected request takes no token from the buckets that had one
+	l.forget("a")
+	if err := l.allow("a", "ping", "", now); err != nil {
+		t.Fatalf("ping after session was forgotten: %v", err)
+	}
+}
+
+func TestLimiterQueuesInFIFOOrder(t *testing.T) {
+	l := newLimiter(rateLimitConfig{MaxInFlight: 1, MaxQueue: 2, QueueTimeout: time.Second})
+
+	release, err := l.acquire()
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	order := make(chan int, 2)
+	for i := 1; i <= 2; i++ {
+		go func(i int) {
+			next, err := l.acquire()
+			if err != nil {
+				t.Errorf("queued acquire %d: %v", i, err)
+				return
+			}
+			order <- i
+			next()
+		}(i)
+		// Queue the waiters one after the other
+		for l.Queued() != i {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	var limitErr *rateLimitError
+	if _, err := l.acquire(); !errors.As(err, &limitErr) || limitErr.Reason != "queue_full" {
+		t.Fatalf("acquire with full queue = %v, want queue_full", err)
+	}
+
+	release()
+	release() // releasing twice frees the slot only once
+	if first, second := <-order, <-order; first != 1 || second != 2 {
+		t.Fatalf("slots handed out in order %d, %d, want 1, 2", first, second)
+	}
+
+	// The last waiter frees its slot right after reporting in
+	deadline := time.Now().Add(time.Second)
+	for {
+		l.mu.Lock()
+		inflight := l.inflight
+		l.mu.Unlock()
+		if inflight == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("inflight = %d after all released, want 0", inflight)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLimiterQueueTimeout(t *testing.T) {
+	l := newLimiter(rateLimitConfig{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: 20 * time.Millisecond})
+	if _, err := l.acquire(); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	var limitErr *rateLimitError
+	if _, err := l.acquire(); !errors.As(err, &limitErr) || limitErr.Reason != "queue_timeout" {
+		t.Fatalf("acquire = %v, want queue_timeout", err)
+	}
+	if l.Queued() != 0 {
+		t.Fatalf("queued = %d after timeout, want 0", l.Queued())
+	}
+}
+
+func TestHTTPRateLimitReturns429(t *testing.T) {
+	g := newTestGateway(t, echoReply)
+	g.limiter = newLimiter(rateLimitConfig{Global: rateLimit{Rate: 0.5, Burst: 1}})
+
+	postMCP(t, g, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+
+	rec := httptest.NewRecorder()
+	g.HandleHTTPMessage(rec, httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`)))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Fatalf("Retry-After = %q, want 2", got)
+	}
+	var reply struct {
+		ID    int `json:"id"`
+		Error struct {
+			Code int `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil || reply.ID != 2 || reply.Error.Code != jsonRPCRateLimited {
+		t.Fatalf("body = %s", rec.Body)
+	}
+
+	if want := `mcp_gateway_rate_limited_requests_total{reason="global"} 1`; !strings.Contains(scrapeMetrics(t, g), want) {
+		t.Fatalf("metrics missing %q", want)
+	}
+}
+
+func TestSessionRateLimitIgnoresClientChosenIDs(t *testing.T) {
+	g := newTestGateway(t, echoReply)
+	g.limiter = newLimiter(rateLimitConfig{Session: rateLimit{Rate: 0.5, Burst: 1}})
+
+	// Without a session each request is its own client, whatever it claims
+	for i := 1; i <= 2; i++ {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/mcp?clientId=shared", strings.NewReader(fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"tools/list"}`, i)))
+		req.Header.Set("X-Client-ID", "shared")
+		g.HandleHTTPMessage(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want 200", i, rec.Code)
+		}
+	}
+}
+
+func TestBatchRateLimitAnsweredInBatch(t *testing.T) {
+	g := newTestGateway(t, echoReply)
+	g.limiter = newLimiter(rateLimitConfig{Global: rateLimit{Rate: 0.5, Burst: 1}})
+
+	body := postMCP(t, g, `[{"jsonrpc":"2.0","id":1,"method":"tools/list"},{"jsonrpc":"2.0","id":2,"method":"tools/list"}]`)
+	var replies []struct {
+		ID    int `json:"id"`
+		Error *struct {
+			Code int `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &replies); err != nil || len(replies) != 2 {
+		t.Fatalf("batch reply = %s", body)
+	}
+	limited := 0
+	for _, reply := range replies {
+		if reply.Error != nil && reply.Error.Code == jsonRPCRateLimited {
+			limited++
+		}
+	}
+	if limited != 1 {
+		t.Fatalf("batch reply = %s, want one rate limited request", body)
+	}
+}
+
+func TestInFlightSlotReleasedOnTimeout(t *testing.T) {
+	g := newTestGateway(t, func(msg JSONRPCMessage) []string { return nil })
+	g.responseTimeout = 50 * time.Millisecond
+	g.limiter = newLimiter(rateLimitConfig{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: time.Second})
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		g.HandleHTTPMessage(rec, httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)))
+		if rec.Code != http.StatusGatewayTimeout {
+			t.Fatalf("request %d: status = %d, want 504", i, rec.Code)
+		}
+	}
+
+	g.limiter.mu.Lock()
+	defer g.limiter.mu.Unlock()
+	if g.limiter.inflight != 0 {
+		t.Fatalf("inflight = %d after timeouts, want 0", g.limiter.inflight)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"sync"
+)
+
+// defaultReplayBufferSize is the number of SSE events kept per session for
+// clients resuming a stream with Last-Event-ID
+const defaultReplayBufferSize = 100
+
+// standaloneStream names a session's GET stream in its event log; response
+// streams of POSTed requests are named by the request's gateway ID
+const standaloneStream = ""
+
+// sseEvent is a message queued for an SSE stream. ID is zero for events of
+// streams that cannot be resumed and is then left out of the stream.
+type sseEvent struct {
+	ID   uint64
+	Data []byte
+}
+
+// loggedEvent is an event recorded in a session's event log
+type loggedEvent struct {
+	sseEvent
+	Stream string
+}
+
+// eventLog keeps the most recent SSE events of a session. IDs increase across
+// all streams of the session, so the ID a client last saw identifies both
+// the stream it was reading and its position in that stream.
+type eventLog struct {
+	mu     sync.Mutex
+	size   int
+	lastID uint64
+	events []loggedEvent // oldest first
+}
+
+func newEventLog(size int) *eventLog {
+	return &eventLog{size: size}
+}
+
+// add records data as the next event of stream
+func (l *eventLog) add(stream string, data []byte) sseEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lastID++
+	event := loggedEvent{sseEvent: sseEvent{ID: l.lastID, Data: data}, Stream: stream}
+	if len(l.events) >= l.size {
+		l.events = append(l.events[:0], l.events[len(l.events)-l.size+1:]...)
+	}
+	l.events = append(l.events, event)
+	return event.sseEvent
+}
+
+// since returns the events recorded after lastID on the stream lastID belongs
+// to. It returns false when lastID is no longer in the log, in which case the
+// missed events cannot be replayed.
+func (l *eventLog) since(lastID uint64) ([]sseEvent, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i, event := range l.events {
+		if event.ID != lastID {
+			continue
+		}
+		var missed []sseEvent
+		for _, later := range l.events[i+1:] {
+			if later.Stream == event.Stream {
+				missed = append(missed, later.sseEvent)
+			}
+		}
+		return missed, true
+	}
+	return nil, false
+}
+
+// recordEvent numbers data as the next event of a session's stream and keeps
+// it for replay. Without an event log, i.e. for clients that are not
+// resumable sessions, the event is returned without an ID.
+func (g *Gateway) recordEvent(sessionID, stream string, data []byte) sseEvent {
+	if events := g.sessions.Events(sessionID); events != nil {
+		return events.add(stream, data)
+	}
+	return sseEvent{Data: data}
+}
+
+// sendToSessionStream records data for replay and queues it on the client's
+// open SSE stream, if any. It reports whether the message reached the stream
+// or was kept for the client to resume.
+func (g *Gateway) sendToSessionStream(clientID, stream string, data []byte) bool {
+	event := g.recordEvent(clientID, stream, data)
+	if sseClient, ok := g.sseClient(clientID); ok {
+		return g.queueSSE(sseClient, event)
+	}
+	return event.ID != 0
+}
+
+// replayEvents returns the events a client resuming a session's stream with
+// the given Last-Event-ID has missed
+func (g *Gateway) replayEvents(sessionID, lastEventID string) []sseEvent {
+	events := g.sessions.Events(sessionID)
+	if events == nil {
+		return nil
+	}
+	lastID, err := strconv.ParseUint(lastEventID, 10, 64)
+	if err != nil {
+		log.Printf("Ignoring invalid Last-Event-ID %q for session %s", lastEventID, sessionID)
+		return nil
+	}
+	missed, ok := events.since(lastID)
+	if !ok {
+		log.Printf("Cannot resume session %s after event %d: no longer buffered", sessionID, lastID)
+	}
+	return missed
+}
+
+// detachRequest keeps a request running after the response stream it was
+// answered on has been cut. Its pending messages and eventual reply are
+// recorded on the request's stream, where the client picks them up by
+// reconnecting with Last-Event-ID. It reports false when the client's session
+// cannot be resumed, in which case the request should be cancelled.
+func (g *Gateway) detachRequest(id int64, key, sessionID string, reply, streamed <-chan []byte) bool {
+	if g.sessions.Events(sessionID) == nil {
+		return false
+	}
+
+	g.closeRequestStream(key)
+	for drained := false; !drained; {
+		select {
+		case data := <-streamed:
+			g.sendToSessionStream(sessionID, key, data)
+		default:
+			drained = true
+		}
+	}
+
+	if !g.routes.Detach(id, key) {
+		// The reply arrived while the stream was being cut
+		select {
+		case data := <-reply:
+			if data != nil {
+				g.sendToSessionStream(sessionID, key, data)
+			}
+		default:
+		}
+	}
+	log.Printf("Response stream of request %d for session %s lost, keeping the request for resumption", id, sessionID)
+	return true
+}
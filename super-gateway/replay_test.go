@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readSSEMessage reads the next event from an SSE stream and returns its ID
+// and data
+func readSSEMessage(t *testing.T, reader *bufio.Reader) (string, string) {
+	t.Helper()
+	var id, data string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read event: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "" && data != "":
+			return id, data
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+// openSessionStream opens the standalone stream of a session, resuming after
+// lastEventID when it is not empty
+func openSessionStream(t *testing.T, ctx context.Context, server *httptest.Server, sessionID, lastEventID string) *bufio.Reader {
+	t.Helper()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Mcp-Session-Id", sessionID)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /mcp: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /mcp: status = %d, want 200", resp.StatusCode)
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+func TestSessionStreamResumesAfterLastEventID(t *testing.T) {
+	g := newTestGateway(t, echoReply)
+	server := httptest.NewServer(mcpHandler(g))
+	t.Cleanup(server.Close)
+	sessionID := initializeSession(t, server.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reader := openSessionStream(t, ctx, server, sessionID, "")
+	g.sendToSession(sessionID, []byte(`{"n":1}`))
+	lastID, data := readSSEMessage(t, reader)
+	if lastID == "" || data != `{"n":1}` {
+		t.Fatalf("first event = %q %s", lastID, data)
+	}
+	cancel()
+
+	// Wait for the stream to go away, then emit while the client is offline
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := g.sseClient(sessionID); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stream still registered after disconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	g.sendToSession(sessionID, []byte(`{"n":2}`))
+	g.sendToSession(sessionID, []byte(`{"n":3}`))
+
+	reader = openSessionStream(t, context.Background(), server, sessionID, lastID)
+	var ids []string
+	for _, want := range []string{`{"n":2}`, `{"n":3}`} {
+		id, data := readSSEMessage(t, reader)
+		if data != want {
+			t.Fatalf("replayed %s, want %s", data, want)
+		}
+		ids = append(ids, id)
+	}
+	if ids[0] <= lastID || ids[1] <= ids[0] {
+		t.Fatalf("event IDs %s then %v are not increasing", lastID, ids)
+	}
+
+	// The resumed stream stays live
+	g.sendToSession(sessionID, []byte(`{"n":4}`))
+	if _, data := readSSEMessage(t, reader); data != `{"n":4}` {
+		t.Fatalf("live event = %s", data)
+	}
+}
+
+func TestDroppedResponseStreamIsResumed(t *testing.T) {
+	release := make(chan struct{})
+	var g *Gateway
+	g = newTestGateway(t, func(msg JSONRPCMessage) []string {
+		if msg.Method != "tools/call" {
+			return echoReply(msg)
+		}
+		id, _ := json.Marshal(msg.ID)
+		go func() {
+			<-release
+			g.handleChildLine(g.child, fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":{"content":[]}}`, id))
+		}()
+		var params struct {
+			Meta struct {
+				ProgressToken string `json:"progressToken"`
+			} `json:"_meta"`
+		}
+		_ = json.Unmarshal(msg.Params, &params)
+		return []string{fmt.Sprintf(`{"jsonrpc":"2.0","method":"notifications/progress","params":{"progressToken":%q,"progress":1}}`, params.Meta.ProgressToken)}
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			g.HandleHTTPSessionStream(w, r)
+			return
+		}
+		g.HandleHTTPMessage(w, r)
+	}))
+	t.Cleanup(server.Close)
+	sessionID := initializeSession(t, server.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, server.URL, strings.NewReader(`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"slow","_meta":{"progressToken":"p"}}}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	req.Header.Set("Mcp-Session-Id", sessionID)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST tools/call: %v", err)
+	}
+	defer resp.Body.Close()
+	lastID, data := readSSEMessage(t, bufio.NewReader(resp.Body))
+	if lastID == "" || !strings.Contains(data, `"progressToken":"p"`) {
+		t.Fatalf("progress event = %q %s", lastID, data)
+	}
+	cancel()
+
+	// The request outlives its response stream
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		g.routes.mu.Lock()
+		detached := false
+		for _, route := range g.routes.routes {
+			detached = detached || route.Stream != ""
+		}
+		g.routes.mu.Unlock()
+		if detached {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("request was not kept after its stream dropped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+
+	reader := openSessionStream(t, context.Background(), server, sessionID, lastID)
+	if id, data := readSSEMessage(t, reader); id <= lastID || !strings.Contains(data, `"id":7`) || !strings.Contains(data, `"result"`) {
+		t.Fatalf("resumed event = %q %s, want the reply to request 7", id, data)
+	}
+}
+
+func TestEventLogSince(t *testing.T) {
+	log := newEventLog(3)
+	first := log.add(standaloneStream, []byte("a"))
+	log.add("7", []byte("b"))
+	log.add(standaloneStream, []byte("c"))
+
+	missed, ok := log.since(first.ID)
+	if !ok || len(missed) != 1 || string(missed[0].Data) != "c" {
+		t.Fatalf("since(%d) = %v, %v, want the later event of the same stream", first.ID, missed, ok)
+	}
+
+	log.add(standaloneStream, []byte("d"))
+	if _, ok := log.since(first.ID); ok {
+		t.Fatal("since accepted an evicted event ID")
+	}
+	if _, ok := log.since(99); ok {
+		t.Fatal("since accepted an unknown event ID")
+	}
+}
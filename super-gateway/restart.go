@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Restart modes for a child that exited without the gateway stopping it
+const (
+	restartNever     = "never"
+	restartOnFailure = "on-failure"
+	restartAlways    = "always"
+)
+
+// Defaults of the restart policy
+const (
+	defaultMaxRestarts    = 5
+	defaultRestartWindow  = 10 * time.Minute
+	defaultRestartBackoff = time.Second
+	defaultMaxBackoff     = 30 * time.Second
+	defaultHealthyAfter   = 5 * time.Minute
+)
+
+// jsonRPCServerRestarting is the error code of requests that arrive while the
+// shared child is being restarted
+const jsonRPCServerRestarting = -32000
+
+// restartPolicy decides whether and when an exited child is started again
+type restartPolicy struct {
+	Mode string
+	// MaxRestarts is the number of restarts allowed within Window, 0 for
+	// unlimited
+	MaxRestarts int
+	Window      time.Duration
+	// Backoff is the delay before the first restart, doubled for every
+	// further restart up to MaxBackoff, or defaultMaxBackoff when it is 0
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// HealthyAfter is how long a child must run for its crash to be treated
+	// as the first one again
+	HealthyAfter time.Duration
+}
+
+func defaultRestartPolicy() restartPolicy {
+	return restartPolicy{
+		Mode:         restartOnFailure,
+		MaxRestarts:  defaultMaxRestarts,
+		Window:       defaultRestartWindow,
+		Backoff:      defaultRestartBackoff,
+		MaxBackoff:   defaultMaxBackoff,
+		HealthyAfter: defaultHealthyAfter,
+	}
+}
+
+// parseRestartMode validates a restart mode name
+func parseRestartMode(value string) (string, error) {
+	switch value {
+	case restartNever, restartOnFailure, restartAlways:
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid restart mode %q: must be 'never', 'on-failure' or 'always'", value)
+}
+
+// restartTracker applies a restart policy to the exits of one child slot: the
+// shared child, or the child of one isolated session
+type restartTracker struct {
+	mu     sync.Mutex
+	policy restartPolicy
+	// restarts are the times of the restarts within the policy's window
+	restarts []time.Time
+	// attempt counts the restarts since the child was last healthy
+	attempt int
+}
+
+func newRestartTracker(policy restartPolicy) *restartTracker {
+	return &restartTracker{policy: policy}
+}
+
+// next records the exit of a child that ran for uptime and returns the delay
+// before it is restarted. When it must not be restarted, next returns false
+// and the reason.
+func (t *restartTracker) next(now time.Time, uptime time.Duration, failed bool) (time.Duration, bool, string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch {
+	case t.policy.Mode == restartNever:
+		return 0, false, "restarts are disabled"
+	case t.policy.Mode == restartOnFailure && !failed:
+		return 0, false, "it exited cleanly"
+	}
+
+	if t.policy.HealthyAfter > 0 && uptime >= t.policy.HealthyAfter {
+		t.attempt = 0
+		t.restarts = nil
+	}
+	recent := t.restarts[:0]
+	for _, at := range t.restarts {
+		if now.Sub(at) < t.policy.Window {
+			recent = append(recent, at)
+		}
+	}
+	t.restarts = recent
+	if t.policy.MaxRestarts > 0 && len(t.restarts) >= t.policy.MaxRestarts {
+		return 0, false, fmt.Sprintf("it was restarted %d times within %s", len(t.restarts), t.policy.Window)
+	}
+
+	t.restarts = append(t.restarts, now)
+	t.attempt++
+	return t.backoff(), true, ""
+}
+
+// backoff is the exponential delay before the current attempt with equal
+// jitter, so children crashing together do not restart in lockstep
+func (t *restartTracker) backoff() time.Duration {
+	maxBackoff := t.policy.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = max(defaultMaxBackoff, t.policy.Backoff)
+	}
+	delay := t.policy.Backoff
+	for i := 1; i < t.attempt && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxBackoff)
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
+
+// failRequests answers requests that were in flight with a child that exited,
+// so their clients do not wait for the response timeout
+func (g *Gateway) failRequests(routes []*requestRoute, reason string) {
+	for _, route := range routes {
+		g.deliverReply(route, JSONRPCMessage{
+			JSONRPC: "2.0",
+			Error:   map[string]interface{}{"code": jsonRPCInternalError, "message": reason},
+		})
+	}
+	if len(routes) > 0 {
+		log.Printf("Failed %d in-flight request(s): %s", len(routes), reason)
+	}
+}
+
+// restartSharedChild starts the shared child again after c exited, following
+// the restart policy. Requests are answered with an error until the new child
+// is ready. When the policy gives up the gateway keeps serving but reports
+// itself unhealthy.
+func (g *Gateway) restartSharedChild(c *childProcess) {
+	failed := c.exitErr != nil
+	uptime := time.Since(c.startedAt)
+	defer g.restarting.Store(false)
+	for {
+		delay, ok, reason := g.restarts.next(time.Now(), uptime, failed)
+		if !ok {
+			g.gaveUp.Store(true)
+			g.recordError("Not restarting MCP server: %s", reason)
+			g.closeWebSocketClients(websocket.CloseInternalServerErr, "MCP server exited")
+			return
+		}
+		// Connections initialized against the old process have to start over
+		g.closeWebSocketClients(websocket.CloseServiceRestart, "MCP server restarting")
+
+		g.metrics.childRestarted()
+		log.Printf("Restarting MCP server in %v...", delay.Round(time.Millisecond))
+		time.Sleep(delay)
+		if g.draining.Load() {
+			return
+		}
+
+		child, err := g.startChild("")
+		if err != nil {
+			g.recordError("Failed to restart MCP server: %v", err)
+			failed, uptime = true, 0
+			continue
+		}
+		g.cmdMu.Lock()
+		g.child = child
+		g.cmdMu.Unlock()
+		log.Printf("MCP server restarted successfully")
+
+		// Wait for the restarted server to be ready
+		if !g.handshake.Skip {
+			if err := g.WaitForReady(g.handshake.Timeout); err != nil {
+				g.recordError("Warning: Restarted MCP server readiness check failed: %v", err)
+				log.Printf("Continuing anyway - server may not be fully initialized")
+				go g.retryHandshake(child, defaultHandshakeRetry)
+			}
+		} else {
+			child.markInitialized(nil)
+		}
+		return
+	}
+}
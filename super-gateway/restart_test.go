@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestartTrackerModes(t *testing.T) {
+	for _, tc := range []struct {
+		mode   string
+		failed bool
+		want   bool
+	}{
+		{restartNever, true, false},
+		{restartOnFailure, true, true},
+		{restartOnFailure, false, false},
+		{restartAlways, false, true},
+	} {
+		policy := defaultRestartPolicy()
+		policy.Mode = tc.mode
+		if _, ok, _ := newRestartTracker(policy).next(time.Now(), time.Second, tc.failed); ok != tc.want {
+			t.Errorf("mode %s, failed %t: restart = %t, want %t", tc.mode, tc.failed, ok, tc.want)
+		}
+	}
+}
+
+func TestRestartTrackerWindow(t *testing.T) {
+	policy := defaultRestartPolicy()
+	policy.MaxRestarts = 2
+	policy.Window = time.Minute
+	tracker := newRestartTracker(policy)
+
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if _, ok, _ := tracker.next(now, time.Second, true); !ok {
+			t.Fatalf("restart %d refused", i+1)
+		}
+	}
+	if _, ok, reason := tracker.next(now, time.Second, true); ok || !strings.Contains(reason, "2 times") {
+		t.Fatalf("third restart within the window: ok = %t, reason %q", ok, reason)
+	}
+	if _, ok, _ := tracker.next(now.Add(time.Minute), time.Second, true); !ok {
+		t.Fatal("restart refused after the window passed")
+	}
+}
+
+func TestRestartTrackerBackoff(t *testing.T) {
+	policy := defaultRestartPolicy()
+	policy.MaxRestarts = 0
+	policy.Backoff = 100 * time.Millisecond
+	policy.MaxBackoff = time.Second
+	policy.HealthyAfter = time.Minute
+	tracker := newRestartTracker(policy)
+
+	now := time.Now()
+	for _, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		want *= time.Millisecond
+		delay, _, _ := tracker.next(now, time.Second, true)
+		if delay < want/2 || delay > want {
+			t.Fatalf("delay = %s, want between %s and %s", delay, want/2, want)
+		}
+	}
+
+	// A child that stayed up past the healthy period starts over
+	if delay, _, _ := tracker.next(now, time.Minute, true); delay > policy.Backoff {
+		t.Fatalf("delay after a healthy run = %s, want at most %s", delay, policy.Backoff)
+	}
+
+	// Without a cap the delay still grows, up to the default one
+	policy.MaxBackoff = 0
+	policy.Backoff = 10 * time.Second
+	tracker = newRestartTracker(policy)
+	for _, want := range []time.Duration{10, 20, 30, 30} {
+		want *= time.Second
+		delay, _, _ := tracker.next(now, time.Second, true)
+		if delay < want/2 || delay > want {
+			t.Fatalf("uncapped delay = %s, want between %s and %s", delay, want/2, want)
+		}
+	}
+}
+
+func TestChildCrashFailsInFlightRequests(t *testing.T) {
+	g := NewGateway()
+	g.restartPolicy.Mode = restartNever
+	g.restarts = newRestartTracker(g.restartPolicy)
+	g.cmdParts = []string{"sh", "-c", "read line; exit 3"}
+	child, err := g.startChild("")
+	if err != nil {
+		t.Fatalf("startChild: %v", err)
+	}
+	g.child = child
+
+	start := time.Now()
+	req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"crash"}}`))
+	recorder := httptest.NewRecorder()
+	g.HandleHTTPMessage(recorder, req)
+
+	body := recorder.Body.String()
+	if recorder.Code != http.StatusOK || !strings.Contains(body, `"id":5`) || !strings.Contains(body, "MCP server exited") {
+		t.Fatalf("reply = %d %s, want a JSON-RPC error", recorder.Code, body)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("request failed after %s, want right after the crash", elapsed)
+	}
+	if g.routes.Len() != 0 {
+		t.Fatalf("%d routes left after the crash", g.routes.Len())
+	}
+}
+
+func TestRequestsWhileRestartingGetAnError(t *testing.T) {
+	g := NewGateway()
+	g.restartPolicy.Backoff = 10 * time.Second
+	g.restarts = newRestartTracker(g.restartPolicy)
+	g.cmdParts = []string{"sh", "-c", "read line; exit 3"}
+	child, err := g.startChild("")
+	if err != nil {
+		t.Fatalf("startChild: %v", err)
+	}
+	g.child = child
+	// Keep the pending restart from starting a child after the test
+	t.Cleanup(func() { g.draining.Store(true) })
+
+	post := func(id int) string {
+		req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"tools/call","params":{"name":"crash"}}`, id)))
+		recorder := httptest.NewRecorder()
+		g.HandleHTTPMessage(recorder, req)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want 200", id, recorder.Code)
+		}
+		return recorder.Body.String()
+	}
+	if body := post(5); !strings.Contains(body, "MCP server exited") {
+		t.Fatalf("reply = %s, want the crash error", body)
+	}
+
+	// The dead child is waiting out the restart backoff
+	body := post(6)
+	if !strings.Contains(body, `"id":6`) || !strings.Contains(body, `"code":-32000`) || !strings.Contains(body, "MCP server restarting") {
+		t.Fatalf("reply = %s, want a restarting error", body)
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// requestRoute records where the child's reply to a forwarded request goes
+type requestRoute struct {
+	ID       int64
+	ClientID string
+	// OriginalID is the request ID exactly as the client sent it, restored
+	// verbatim on the reply so its JSON type is preserved
+	OriginalID json.RawMessage
+	// Reply receives the reply when a handler is waiting for it; when nil the
+	// reply is delivered to the client's WebSocket or SSE connection
+	Reply chan []byte
+	// Stream is the response stream a detached request's reply is recorded
+	// on, so a client that lost the stream can resume it
+	Stream string
+	// Child is the child the request was forwarded to, the only one whose
+	// reply is accepted for it
+	Child     *childProcess
+	CreatedAt time.Time
+	// Method and Tool describe the request for metrics
+	Method string
+	Tool   string
+	// Span traces the request while tracing is enabled
+	Span *span
+	// Release frees the request's in-flight slot once it is finished
+	Release func()
+}
+
+// routeTable allocates the gateway-unique integer IDs under which client
+// requests are forwarded to the shared child, so replies can be routed back
+// without encoding client information into the ID itself
+type routeTable struct {
+	mu     sync.Mutex
+	nextID int64
+	routes map[int64]*requestRoute
+}
+
+func newRouteTable() *routeTable {
+	return &routeTable{routes: make(map[int64]*requestRoute)}
+}
+
+// Register allocates a gateway ID for a request from clientID
+func (t *routeTable) Register(clientID string, originalID json.RawMessage, reply chan []byte) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.nextID++
+	t.routes[t.nextID] = &requestRoute{
+		ID:         t.nextID,
+		ClientID:   clientID,
+		OriginalID: originalID,
+		Reply:      reply,
+		CreatedAt:  time.Now(),
+	}
+	return t.nextID
+}
+
+// Describe records the method, tool name and span of a forwarded request
+func (t *routeTable) Describe(id int64, method, tool string, span *span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if route, ok := t.routes[id]; ok {
+		route.Method = method
+		route.Tool = tool
+		route.Span = span
+	}
+}
+
+// Forward records the child a request is forwarded to
+func (t *routeTable) Forward(id int64, child *childProcess) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if route, ok := t.routes[id]; ok {
+		route.Child = child
+	}
+}
+
+// ForwardedTo reports whether request id is in flight on child
+func (t *routeTable) ForwardedTo(id int64, child *childProcess) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	route, ok := t.routes[id]
+	return ok && route.Child == child
+}
+
+// errForeignReply is returned by ResolveReply for a reply from a child the
+// request was not forwarded to
+var errForeignReply = errors.New("reply from a child the request was not forwarded to")
+
+// ResolveReply removes and returns the route for a reply from child. Gateway
+// IDs are sequential, so without the check one session's child could answer
+// the requests of another's.
+func (t *routeTable) ResolveReply(id int64, child *childProcess) (*requestRoute, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	route, ok := t.routes[id]
+	if !ok {
+		return nil, errors.New("unknown or abandoned request")
+	}
+	if route.Child != child {
+		return nil, errForeignReply
+	}
+	delete(t.routes, id)
+	return route, nil
+}
+
+// Resolve removes and returns the route for a gateway ID
+func (t *routeTable) Resolve(id int64) (*requestRoute, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	route, ok := t.routes[id]
+	if ok {
+		delete(t.routes, id)
+	}
+	return route, ok
+}
+
+// Detach stops a handler from waiting for the reply to a request, which is
+// then delivered on stream, and reports whether the request was in flight
+func (t *routeTable) Detach(id int64, stream string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	route, ok := t.routes[id]
+	if ok {
+		route.Reply = nil
+		route.Stream = stream
+	}
+	return ok
+}
+
+// Remove drops the route for a gateway ID and reports whether it was pending
+func (t *routeTable) Remove(id int64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := t.routes[id]
+	delete(t.routes, id)
+	return ok
+}
+
+// RemoveClient drops every route belonging to clientID and returns them
+func (t *routeTable) RemoveClient(clientID string) []*requestRoute {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var removed []*requestRoute
+	for id, route := range t.routes {
+		if route.ClientID == clientID {
+			delete(t.routes, id)
+			removed = append(removed, route)
+		}
+	}
+	return removed
+}
+
+// RemoveAll drops every route and returns them
+func (t *routeTable) RemoveAll() []*requestRoute {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	removed := make([]*requestRoute, 0, len(t.routes))
+	for id, route := range t.routes {
+		delete(t.routes, id)
+		removed = append(removed, route)
+	}
+	return removed
+}
+
+// RemoveIDs drops the routes of the given gateway IDs that are still in flight
+// and returns them
+func (t *routeTable) RemoveIDs(ids []int64) []*requestRoute {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var removed []*requestRoute
+	for _, id := range ids {
+		if route, ok := t.routes[id]; ok {
+			delete(t.routes, id)
+			removed = append(removed, route)
+		}
+	}
+	return removed
+}
+
+// RemoveByOriginalID drops the route of clientID's in-flight request with
+// the given original ID and returns its gateway ID
+func (t *routeTable) RemoveByOriginalID(clientID string, originalID json.RawMessage) (int64, *requestRoute, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for id, route := range t.routes {
+		if route.ClientID == clientID && string(route.OriginalID) == string(originalID) {
+			delete(t.routes, id)
+			return id, route, true
+		}
+	}
+	return 0, nil, false
+}
+
+// SoleClient returns the most recently registered request in flight on child
+// when every request in flight on it comes from the same client
+func (t *routeTable) SoleClient(child *childProcess) (*requestRoute, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var latest *requestRoute
+	for _, route := range t.routes {
+		if route.Child != child {
+			continue
+		}
+		if latest != nil && route.ClientID != latest.ClientID {
+			return nil, false
+		}
+		if latest == nil || route.ID > latest.ID {
+			latest = route
+		}
+	}
+	return latest, latest != nil
+}
+
+// HasClient reports whether clientID has a request in flight
+func (t *routeTable) HasClient(clientID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, route := range t.routes {
+		if route.ClientID == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the number of requests awaiting a reply
+func (t *routeTable) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.routes)
+}
+
+// gatewayID encodes a gateway ID as a JSON-RPC ID
+func gatewayID(id int64) json.RawMessage {
+	return json.RawMessage(strconv.FormatInt(id, 10))
+}
+
+// parseGatewayID decodes a JSON-RPC ID allocated by the route table
+func parseGatewayID(raw json.RawMessage) (int64, bool) {
+	id, err := strconv.ParseInt(string(raw), 10, 64)
+	return id, err == nil && id > 0
+}
+
+// Hold attaches the release of an in-flight slot to a request, reporting
+// false when the request is no longer in flight
+func (t *routeTable) Hold(id int64, release func()) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	route, ok := t.routes[id]
+	if ok {
+		route.Release = release
+	}
+	return ok
+}
+
+// finishRequest records the outcome of a forwarded request whose route has
+// been removed from the table
+func (g *Gateway) finishRequest(route *requestRoute, outcome string) {
+	if route.Release != nil {
+		route.Release()
+	}
+	g.metrics.observeRequest(route, outcome)
+	logRequest(route, outcome)
+	g.tracer.finish(route.Span, outcome)
+}
+
+// rejectRequest answers a registered request with a JSON-RPC error from the
+// gateway instead of forwarding it to the child
+func (g *Gateway) rejectRequest(id int64, msg JSONRPCMessage, code int, message string) {
+	g.routes.Describe(id, msg.Method, toolName(msg), nil)
+	route, ok := g.routes.Resolve(id)
+	if !ok {
+		return
+	}
+	g.deliverReply(route, JSONRPCMessage{
+		JSONRPC: "2.0",
+		Error:   map[string]interface{}{"code": code, "message": message},
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDsAreRestoredVerbatim(t *testing.T) {
+	var forwarded []string
+	g := newTestGateway(t, func(msg JSONRPCMessage) []string {
+		forwarded = append(forwarded, string(msg.ID))
+		return echoReply(msg)
+	})
+
+	for _, id := range []string{`"42"`, `42`, `1.5`, `9007199254740993`, `"client:7"`} {
+		body := fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"method":"ping"}`, id)
+		recorder := httptest.NewRecorder()
+		g.HandleHTTPMessage(recorder, httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(body)))
+
+		var reply JSONRPCMessage
+		if err := json.Unmarshal(recorder.Body.Bytes(), &reply); err != nil {
+			t.Fatalf("id %s: invalid reply %q: %v", id, recorder.Body.String(), err)
+		}
+		if string(reply.ID) != id {
+			t.Fatalf("reply id = %s, want %s", reply.ID, id)
+		}
+	}
+
+	for _, id := range forwarded {
+		if _, ok := parseGatewayID(json.RawMessage(id)); !ok {
+			t.Fatalf("child received non-gateway id %s", id)
+		}
+	}
+	if n := g.routes.Len(); n != 0 {
+		t.Fatalf("%d routes left after all replies were delivered", n)
+	}
+}
+
+func TestRouteTableRemoveClient(t *testing.T) {
+	routes := newRouteTable()
+	first := routes.Register("a", json.RawMessage(`1`), nil)
+	routes.Register("a", json.RawMessage(`2`), nil)
+	other := routes.Register("b", json.RawMessage(`1`), nil)
+	if first == other {
+		t.Fatal("gateway IDs must be unique across clients")
+	}
+
+	if removed := routes.RemoveClient("a"); len(removed) != 2 {
+		t.Fatalf("removed %d routes, want 2", len(removed))
+	}
+	if _, ok := routes.Resolve(first); ok {
+		t.Fatal("route of removed client still resolves")
+	}
+	if route, ok := routes.Resolve(other); !ok || route.ClientID != "b" {
+		t.Fatalf("route of other client = %+v, %v", route, ok)
+	}
+}
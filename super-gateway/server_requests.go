@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+)
+
+// jsonRPCNoClient is the error code returned to the child when a
+// server-initiated request cannot be delivered to any client
+const jsonRPCNoClient = -32002
+
+// serverRequest is a request the child sent to a client (sampling,
+// elicitation, roots/list) that is waiting for the client's response
+type serverRequest struct {
+	ClientID string
+	// Child is the child that sent the request and gets the response
+	Child *childProcess
+	// ChildID is the ID the child used, restored on the client's response
+	ChildID json.RawMessage
+}
+
+// serverRequestTable maps the IDs under which server-initiated requests are
+// delivered to clients back to the child's own IDs
+type serverRequestTable struct {
+	mu       sync.Mutex
+	nextID   int64
+	requests map[int64]*serverRequest
+}
+
+func newServerRequestTable() *serverRequestTable {
+	return &serverRequestTable{requests: make(map[int64]*serverRequest)}
+}
+
+// Register allocates the client-facing ID for a server-initiated request
+func (t *serverRequestTable) Register(clientID string, child *childProcess, childID json.RawMessage) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.nextID++
+	t.requests[t.nextID] = &serverRequest{ClientID: clientID, Child: child, ChildID: childID}
+	return t.nextID
+}
+
+// Resolve removes and returns the request answered by clientID's response
+func (t *serverRequestTable) Resolve(clientID string, id json.RawMessage) (*serverRequest, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n, err := strconv.ParseInt(string(id), 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	request, ok := t.requests[n]
+	if !ok || request.ClientID != clientID {
+		return nil, false
+	}
+	delete(t.requests, n)
+	return request, true
+}
+
+// RemoveClient drops every request delivered to clientID and returns them
+func (t *serverRequestTable) RemoveClient(clientID string) []*serverRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var removed []*serverRequest
+	for id, request := range t.requests {
+		if request.ClientID == clientID {
+			delete(t.requests, id)
+			removed = append(removed, request)
+		}
+	}
+	return removed
+}
+
+// routeServerRequest delivers a request initiated by the child to the client
+// that caused it. A request carrying the progress token of a streamed request
+// belongs to that request. Otherwise a shared child gives no indication of
+// which client a request is for, so it only goes to a client when that client
+// is the only one with requests in flight on the child; a session's own child
+// only serves that session. The request is delivered under a gateway ID so
+// the response can be mapped back to the child's ID. If no client can take
+// it, the child gets an error reply instead of waiting forever.
+func (g *Gateway) routeServerRequest(c *childProcess, msg JSONRPCMessage) {
+	route, msg, ok := g.serverRequestRoute(c, msg)
+	if !ok {
+		g.rejectServerRequest(c, msg.ID, "No single client session to handle "+msg.Method)
+		return
+	}
+
+	childID := msg.ID
+	id := g.serverRequests.Register(route.ClientID, c, childID)
+	msg.ID = gatewayID(id)
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+
+	if g.deliverToClient(route, data) {
+		return
+	}
+	g.serverRequests.Resolve(route.ClientID, msg.ID)
+	g.rejectServerRequest(c, childID, "No open stream for client "+route.ClientID+" to deliver "+msg.Method)
+}
+
+// serverRequestRoute picks the request a server-initiated request from c
+// relates to, or just its client when no single request can be told apart
+func (g *Gateway) serverRequestRoute(c *childProcess, msg JSONRPCMessage) (*requestRoute, JSONRPCMessage, bool) {
+	if stream, msg, ok := g.requestStreamForServerRequest(msg); ok {
+		id, err := strconv.ParseInt(stream.Key, 10, 64)
+		if err == nil {
+			return &requestRoute{ID: id, ClientID: stream.ClientID}, msg, true
+		}
+	}
+	if route, ok := g.routes.SoleClient(c); ok {
+		return route, msg, true
+	}
+	if c.SessionID != "" {
+		return &requestRoute{ClientID: c.SessionID}, msg, true
+	}
+	return nil, msg, false
+}
+
+// deliverToClient sends data on the stream of the request described by route,
+// falling back to the client's standalone stream or WebSocket connection.
+// Streams of the client's other requests are never used: they end with those
+// requests and say nothing about this one.
+func (g *Gateway) deliverToClient(route *requestRoute, data []byte) bool {
+	g.streamsMu.RLock()
+	stream, ok := g.streams[strconv.FormatInt(route.ID, 10)]
+	g.streamsMu.RUnlock()
+	if ok && stream.ClientID == route.ClientID {
+		return g.sendToStream(stream, data)
+	}
+
+	if client, ok := g.webSocketClient(route.ClientID); ok {
+		return g.sendToWebSocket(client, data)
+	}
+	return g.sendToSessionStream(route.ClientID, standaloneStream, data)
+}
+
+// rejectServerRequest answers a server-initiated request with an error
+func (g *Gateway) rejectServerRequest(c *childProcess, childID json.RawMessage, reason string) {
+	log.Printf("Rejecting server-initiated request %s: %s", childID, reason)
+	var reply JSONRPCMessage
+	if err := json.Unmarshal(newErrorResponse(childID, jsonRPCNoClient, reason), &reply); err != nil {
+		return
+	}
+	if err := c.write(reply); err != nil {
+		log.Printf("Failed to reject server-initiated request %s: %v", childID, err)
+	}
+}
+
+// releaseServerRequests fails the server-initiated requests still waiting on
+// a client that went away
+func (g *Gateway) releaseServerRequests(clientID string) {
+	for _, request := range g.serverRequests.RemoveClient(clientID) {
+		g.rejectServerRequest(request.Child, request.ChildID, "Client "+clientID+" disconnected")
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServerRequestIsRoutedToOriginatingRequest(t *testing.T) {
+	var toolCallID json.RawMessage
+	sampled := make(chan JSONRPCMessage, 1)
+	g := newTestGateway(t, func(msg JSONRPCMessage) []string {
+		switch {
+		case msg.Method == "initialize":
+			return echoReply(msg)
+		case msg.Method == "tools/call":
+			toolCallID = msg.ID
+			return []string{`{"jsonrpc":"2.0","id":99,"method":"sampling/createMessage","params":{"messages":[]}}`}
+		case msg.Method == "" && msg.ID != nil:
+			sampled <- msg
+			return []string{fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":{"content":[]}}`, toolCallID)}
+		}
+		return nil
+	})
+	server := httptest.NewServer(http.HandlerFunc(g.HandleHTTPMessage))
+	defer server.Close()
+	sessionID := initializeSession(t, server.URL)
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(`{"jsonrpc":"2.0","id":"call-1","method":"tools/call","params":{"name":"summarize"}}`))
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	req.Header.Set("Mcp-Session-Id", sessionID)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	readEvent := func() JSONRPCMessage {
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("stream ended early: %v", err)
+			}
+			if data, ok := strings.CutPrefix(line, "data: "); ok {
+				var msg JSONRPCMessage
+				if err := json.Unmarshal([]byte(data), &msg); err != nil {
+					t.Fatalf("invalid event %q: %v", data, err)
+				}
+				return msg
+			}
+		}
+	}
+
+	request := readEvent()
+	if request.Method != "sampling/createMessage" {
+		t.Fatalf("first event = %+v, want sampling/createMessage", request)
+	}
+	if string(request.ID) == "99" {
+		t.Fatal("server request was delivered under the child's own ID")
+	}
+
+	// The client answers on a separate POST, as a JSON-RPC response
+	answer := httptest.NewRecorder()
+	answerReq := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":{"role":"assistant"}}`, request.ID)))
+	answerReq.Header.Set("Mcp-Session-Id", sessionID)
+	g.HandleHTTPMessage(answer, answerReq)
+	if answer.Code != http.StatusAccepted {
+		t.Fatalf("response POST status = %d, want 202", answer.Code)
+	}
+
+	if got := <-sampled; string(got.ID) != "99" {
+		t.Fatalf("child received response id %s, want 99", got.ID)
+	}
+	if final := readEvent(); string(final.ID) != `"call-1"` {
+		t.Fatalf("final response id = %s, want \"call-1\"", final.ID)
+	}
+}
+
+func TestServerRequestWithoutClientIsRejected(t *testing.T) {
+	replies := make(chan JSONRPCMessage, 1)
+	g := newTestGateway(t, func(msg JSONRPCMessage) []string {
+		replies <- msg
+		return nil
+	})
+
+	g.handleChildLine(g.child, `{"jsonrpc":"2.0","id":5,"method":"roots/list"}`)
+
+	reply := <-replies
+	if string(reply.ID) != "5" || reply.Error == nil {
+		t.Fatalf("child received %+v, want an error reply for id 5", reply)
+	}
+}
+
+func TestServerRequestWithSeveralClientsInFlightIsRejected(t *testing.T) {
+	replies := make(chan JSONRPCMessage, 1)
+	g := newTestGateway(t, func(msg JSONRPCMessage) []string {
+		replies <- msg
+		return nil
+	})
+	for _, clientID := range []string{"tenant-a", "tenant-b"} {
+		id := g.routes.Register(clientID, json.RawMessage(`1`), make(chan []byte, 1))
+		g.routes.Forward(id, g.child)
+	}
+
+	g.handleChildLine(g.child, `{"jsonrpc":"2.0","id":5,"method":"roots/list"}`)
+
+	reply := <-replies
+	if string(reply.ID) != "5" || reply.Error == nil {
+		t.Fatalf("child received %+v, want an error reply for id 5", reply)
+	}
+}
+
+func TestServerRequestFollowsProgressToken(t *testing.T) {
+	g := newTestGateway(t, func(msg JSONRPCMessage) []string { return nil })
+	other := g.routes.Register("tenant-a", json.RawMessage(`1`), make(chan []byte, 1))
+	g.routes.Forward(other, g.child)
+	id := g.routes.Register("tenant-b", json.RawMessage(`2`), make(chan []byte, 1))
+	g.routes.Forward(id, g.child)
+
+	key := fmt.Sprint(id)
+	call := JSONRPCMessage{Method: "tools/call", Params: json.RawMessage(`{"_meta":{"progressToken":"mine"}}`)}
+	stream := g.openRequestStream(key, "tenant-b", &call)
+	defer g.closeRequestStream(key)
+
+	g.handleChildLine(g.child, fmt.Sprintf(`{"jsonrpc":"2.0","id":5,"method":"sampling/createMessage","params":{"_meta":{"progressToken":%q}}}`, key))
+
+	var request JSONRPCMessage
+	if err := json.Unmarshal(<-stream.Send, &request); err != nil {
+		t.Fatalf("invalid message: %v", err)
+	}
+	if request.Method != "sampling/createMessage" || !strings.Contains(string(request.Params), `"mine"`) {
+		t.Fatalf("stream received %+v, want the request with the client's progress token", request)
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Defaults for session lifecycle management
+const (
+	defaultSessionIdleTimeout = 30 * time.Minute
+	defaultMaxSessions        = 0 // unlimited
+)
+
+// errTooManySessions is returned when the session cap has been reached
+var errTooManySessions = errors.New("too many sessions")
+
+// Session is a Streamable HTTP session created by an initialize request
+type Session struct {
+	ID              string
+	ProtocolVersion string
+	CreatedAt       time.Time
+	LastActivity    time.Time
+	// Events keeps the session's recent SSE events for clients resuming a
+	// stream; nil when resumption is disabled
+	Events *eventLog
+	// active counts in-flight requests and open streams; a session with
+	// activity in progress is never considered idle
+	active int
+}
+
+// SessionManager tracks live sessions and expires those idle for longer than
+// the configured timeout
+type SessionManager struct {
+	mu          sync.Mutex
+	sessions    map[string]*Session
+	idleTimeout time.Duration
+	maxSessions int
+	// replaySize is the number of SSE events kept per session, 0 to disable
+	// resumable streams
+	replaySize int
+}
+
+// NewSessionManager creates a session manager. A zero idleTimeout disables
+// expiry and a zero maxSessions disables the session cap.
+func NewSessionManager(idleTimeout time.Duration, maxSessions int) *SessionManager {
+	return &SessionManager{
+		sessions:    make(map[string]*Session),
+		idleTimeout: idleTimeout,
+		maxSessions: maxSessions,
+		replaySize:  defaultReplayBufferSize,
+	}
+}
+
+// Create starts a new session with a server-generated ID. The session is
+// returned acquired; callers must Release it when their request completes.
+func (m *SessionManager) Create(protocolVersion string) (Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if m.maxSessions > 0 && len(m.sessions) >= m.maxSessions {
+		m.expireLocked(now)
+		if len(m.sessions) >= m.maxSessions {
+			return Session{}, errTooManySessions
+		}
+	}
+
+	session := &Session{
+		ID:              uuid.New().String(),
+		ProtocolVersion: protocolVersion,
+		CreatedAt:       now,
+		LastActivity:    now,
+		active:          1,
+	}
+	if m.replaySize > 0 {
+		session.Events = newEventLog(m.replaySize)
+	}
+	m.sessions[session.ID] = session
+	return *session, nil
+}
+
+// Acquire marks a session as in use and records activity. It returns false
+// for unknown sessions and for sessions that have been idle past the timeout.
+func (m *SessionManager) Acquire(id string) (Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[id]
+	if !ok {
+		return Session{}, false
+	}
+	now := time.Now()
+	if m.isExpired(session, now) {
+		delete(m.sessions, id)
+		return Session{}, false
+	}
+	session.active++
+	session.LastActivity = now
+	return *session, true
+}
+
+// Release ends a use started by Create or Acquire and records activity
+func (m *SessionManager) Release(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if session, ok := m.sessions[id]; ok {
+		if session.active > 0 {
+			session.active--
+		}
+		session.LastActivity = time.Now()
+	}
+}
+
+// Events returns the event log of a session, or nil if there is none
+func (m *SessionManager) Events(id string) *eventLog {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if session, ok := m.sessions[id]; ok {
+		return session.Events
+	}
+	return nil
+}
+
+// SetProtocolVersion records the protocol version negotiated for a session
+func (m *SessionManager) SetProtocolVersion(id, protocolVersion string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if session, ok := m.sessions[id]; ok {
+		session.ProtocolVersion = protocolVersion
+	}
+}
+
+// Get returns a live session without recording activity
+func (m *SessionManager) Get(id string) (Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[id]
+	if !ok || m.isExpired(session, time.Now()) {
+		return Session{}, false
+	}
+	return *session, true
+}
+
+// Remove deletes a session and reports whether it existed
+func (m *SessionManager) Remove(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.sessions[id]; !ok {
+		return false
+	}
+	delete(m.sessions, id)
+	return true
+}
+
+// Len returns the number of tracked sessions
+func (m *SessionManager) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.sessions)
+}
+
+// Expire removes every session idle past the timeout and returns their IDs
+func (m *SessionManager) Expire(now time.Time) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.expireLocked(now)
+}
+
+func (m *SessionManager) expireLocked(now time.Time) []string {
+	var expired []string
+	for id, session := range m.sessions {
+		if m.isExpired(session, now) {
+			delete(m.sessions, id)
+			expired = append(expired, id)
+		}
+	}
+	return expired
+}
+
+func (m *SessionManager) isExpired(session *Session, now time.Time) bool {
+	return m.idleTimeout > 0 && session.active == 0 && now.Sub(session.LastActivity) > m.idleTimeout
+}
+
+// RunSessionSweeper periodically expires idle sessions and releases the
+// waiters and streams they still hold
+func (g *Gateway) RunSessionSweeper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		for _, id := range g.sessions.Expire(now) {
+			g.releaseSession(id)
+			log.Printf("Session expired: %s", id)
+		}
+	}
+}
+
+// HandleHTTPSessionStream opens the standalone SSE stream (GET /mcp) on which
+// a session receives server-initiated notifications and requests that are not
+// tied to one of its POSTed requests
+func (g *Gateway) HandleHTTPSessionStream(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.Header.Get("Mcp-Session-Id")
+	if sessionID == "" {
+		http.Error(w, "Missing Mcp-Session-Id header", http.StatusBadRequest)
+		return
+	}
+	if !acceptsEventStream(r.Header.Get("Accept")) {
+		http.Error(w, "Accept header must include text/event-stream", http.StatusNotAcceptable)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// An open stream keeps the session from being considered idle
+	if _, ok := g.sessions.Acquire(sessionID); !ok {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	defer g.sessions.Release(sessionID)
+
+	// Register before looking up missed events so nothing emitted in between
+	// is lost; events both replayed and queued are written once
+	sseClient := g.registerSSEClient(sessionID, transportHTTPStream)
+	defer g.unregisterSSEClient(sseClient)
+	var missed []sseEvent
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		missed = g.replayEvents(sessionID, lastEventID)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	// A client resuming a request's response stream continues on this
+	// stream, which receives the request's remaining messages and reply
+	replayed := make(map[uint64]bool, len(missed))
+	for _, event := range missed {
+		if err := writeSSEEvent(w, event); err != nil {
+			log.Printf("SSE write error for session %s: %v", sessionID, err)
+			return
+		}
+		replayed[event.ID] = true
+	}
+	flusher.Flush()
+	if len(missed) > 0 {
+		log.Printf("Replayed %d missed event(s) to session %s", len(missed), sessionID)
+	}
+
+	log.Printf("SSE stream opened for session %s", sessionID)
+	g.streamSSEClient(w, r, flusher, sseClient, replayed)
+	log.Printf("SSE stream closed for session %s", sessionID)
+}
+
+// HandleHTTPSessionDelete terminates a session on client request (DELETE /mcp)
+func (g *Gateway) HandleHTTPSessionDelete(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.Header.Get("Mcp-Session-Id")
+	if sessionID == "" {
+		http.Error(w, "Missing Mcp-Session-Id header", http.StatusBadRequest)
+		return
+	}
+	if !g.terminateSession(sessionID) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// terminateSession removes a session together with its pending waiters and
+// standalone SSE stream
+func (g *Gateway) terminateSession(sessionID string) bool {
+	if !g.sessions.Remove(sessionID) {
+		return false
+	}
+	g.releaseSession(sessionID)
+	log.Printf("Session terminated: %s", sessionID)
+	return true
+}
+
+// releaseSession drops the in-flight requests, SSE stream and, in isolated
+// mode, the child of a removed session. The child is told to cancel those
+// requests, and handlers still waiting on a reply are released with a nil
+// message so they return instead of running into the timeout.
+func (g *Gateway) releaseSession(sessionID string) {
+	for _, route := range g.routes.RemoveClient(sessionID) {
+		g.finishRequest(route, outcomeCancelled)
+		g.notifyCancelled(route, "Session terminated")
+		if route.Reply != nil {
+			select {
+			case route.Reply <- nil:
+			default:
+			}
+		}
+	}
+
+	g.releaseServerRequests(sessionID)
+	g.stopSessionProcess(sessionID)
+	g.limiter.forget(sessionID)
+
+	g.sseClientsMu.Lock()
+	if sseClient, ok := g.sseClients[sessionID]; ok {
+		delete(g.sseClients, sessionID)
+		close(sseClient.Done)
+	}
+	g.sseClientsMu.Unlock()
+}
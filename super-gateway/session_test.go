@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func doSessionRequest(t *testing.T, method, url, sessionID, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	if sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", sessionID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	return resp
+}
+
+func initializeSession(t *testing.T, url string) string {
+	t.Helper()
+	resp := doSessionRequest(t, http.MethodPost, url, "", `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26"}}`)
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	sessionID := resp.Header.Get("Mcp-Session-Id")
+	if sessionID == "" {
+		t.Fatal("initialize did not return Mcp-Session-Id")
+	}
+	return sessionID
+}
+
+func TestSessionStreamReceivesServerNotifications(t *testing.T) {
+	g := newTestGateway(t, echoReply)
+	server := httptest.NewServer(mcpHandler(g))
+	t.Cleanup(server.Close)
+	sessionID := initializeSession(t, server.URL)
+
+	resp := doSessionRequest(t, http.MethodGet, server.URL, sessionID, "")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET status = %d, want 200", resp.StatusCode)
+	}
+
+	g.handleChildLine(g.child, `{"jsonrpc":"2.0","method":"notifications/tools/list_changed"}`)
+
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("stream ended before notification: %v", err)
+		}
+		if strings.HasPrefix(line, "data: ") {
+			if !strings.Contains(line, "notifications/tools/list_changed") {
+				t.Fatalf("unexpected event: %s", line)
+			}
+			break
+		}
+	}
+
+	deleteResp := doSessionRequest(t, http.MethodDelete, server.URL, sessionID, "")
+	_ = deleteResp.Body.Close()
+	if deleteResp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE status = %d, want 204", deleteResp.StatusCode)
+	}
+	if _, err := io.ReadAll(reader); err != nil {
+		t.Fatalf("stream did not end cleanly after DELETE: %v", err)
+	}
+}
+
+func TestTerminatedSessionReturnsNotFound(t *testing.T) {
+	g := newTestGateway(t, echoReply)
+	server := httptest.NewServer(mcpHandler(g))
+	t.Cleanup(server.Close)
+	sessionID := initializeSession(t, server.URL)
+
+	resp := doSessionRequest(t, http.MethodDelete, server.URL, sessionID, "")
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE status = %d, want 204", resp.StatusCode)
+	}
+
+	for _, method := range []string{http.MethodPost, http.MethodGet, http.MethodDelete} {
+		resp := doSessionRequest(t, method, server.URL, sessionID, `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`)
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("%s after DELETE status = %d, want 404", method, resp.StatusCode)
+		}
+	}
+}
+
+func TestInitializeIgnoresClientSuppliedSessionID(t *testing.T) {
+	g := newTestGateway(t, echoReply)
+	server := httptest.NewServer(mcpHandler(g))
+	t.Cleanup(server.Close)
+
+	resp := doSessionRequest(t, http.MethodPost, server.URL, "client-chosen", `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26"}}`)
+	_ = resp.Body.Close()
+	sessionID := resp.Header.Get("Mcp-Session-Id")
+	if sessionID == "" || sessionID == "client-chosen" {
+		t.Fatalf("Mcp-Session-Id = %q, want a server-generated ID", sessionID)
+	}
+
+	resp = doSessionRequest(t, http.MethodPost, server.URL, "client-chosen", `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unknown session status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestSessionManagerExpiresIdleSessions(t *testing.T) {
+	m := NewSessionManager(time.Minute, 0)
+	session, err := m.Create("2025-03-26")
+	if err != nil {
+		t.Fatalf("Create returned error: %v", err)
+	}
+
+	// Sessions in use are never idle
+	if expired := m.Expire(time.Now().Add(time.Hour)); len(expired) != 0 {
+		t.Fatalf("expired active session: %v", expired)
+	}
+
+	m.Release(session.ID)
+	if expired := m.Expire(time.Now().Add(30 * time.Second)); len(expired) != 0 {
+		t.Fatalf("expired session before idle timeout: %v", expired)
+	}
+	if expired := m.Expire(time.Now().Add(2 * time.Minute)); len(expired) != 1 || expired[0] != session.ID {
+		t.Fatalf("Expire = %v, want [%s]", expired, session.ID)
+	}
+	if _, ok := m.Acquire(session.ID); ok {
+		t.Fatal("expired session can still be acquired")
+	}
+}
+
+func TestSessionManagerEnforcesMaxSessions(t *testing.T) {
+	m := NewSessionManager(0, 2)
+	for i := 0; i < 2; i++ {
+		if _, err := m.Create(""); err != nil {
+			t.Fatalf("Create %d returned error: %v", i, err)
+		}
+	}
+	if _, err := m.Create(""); err != errTooManySessions {
+		t.Fatalf("Create over cap error = %v, want errTooManySessions", err)
+	}
+}
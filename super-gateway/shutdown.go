@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Defaults for graceful shutdown
+const (
+	defaultShutdownTimeout  = 20 * time.Second
+	defaultChildStopTimeout = 5 * time.Second
+)
+
+// rejectWhileDraining answers a request that would open a new session or
+// connection with 503 once shutdown has begun, and reports whether it did
+func (g *Gateway) rejectWhileDraining(w http.ResponseWriter) bool {
+	if !g.draining.Load() {
+		return false
+	}
+	w.Header().Set("Connection", "close")
+	http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+	return true
+}
+
+// Shutdown stops the gateway gracefully. New sessions and connections are
+// refused while requests in flight get until ctx is done to finish; then
+// WebSocket connections are closed with a going-away frame, SSE streams are
+// ended, the listeners are shut down and the MCP servers are stopped, each
+// given childTimeout to exit after SIGTERM before it is killed.
+func (g *Gateway) Shutdown(ctx context.Context, childTimeout time.Duration, servers ...*http.Server) {
+	g.draining.Store(true)
+
+	// Shutdown closes the listeners right away and then waits for handlers,
+	// which include the requests being drained
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				log.Printf("Forcing server on %s closed: %v", server.Addr, err)
+				_ = server.Close()
+			}
+		}(server)
+	}
+
+	if n := g.waitForRequests(ctx); n > 0 {
+		log.Printf("Shutdown grace period expired with %d request(s) in flight", n)
+	} else {
+		log.Printf("All in-flight requests finished")
+	}
+
+	// Streams never end on their own, so close them for Shutdown to return
+	g.closeWebSocketClients(websocket.CloseGoingAway, "Gateway shutting down")
+	g.closeSSEClients()
+	wg.Wait()
+
+	g.stopChildren(childTimeout)
+	g.tracer.Close()
+}
+
+// waitForRequests waits until no forwarded request awaits a reply or ctx is
+// done, and returns the number of requests still in flight
+func (g *Gateway) waitForRequests(ctx context.Context) int {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		n := g.routes.Len()
+		if n == 0 {
+			return 0
+		}
+		select {
+		case <-ctx.Done():
+			return n
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeSSEClients ends every open SSE stream
+func (g *Gateway) closeSSEClients() {
+	g.sseClientsMu.Lock()
+	defer g.sseClientsMu.Unlock()
+	for id, sseClient := range g.sseClients {
+		delete(g.sseClients, id)
+		close(sseClient.Done)
+	}
+}
+
+// stopChildren terminates the shared MCP server and every session's server
+// in parallel
+func (g *Gateway) stopChildren(timeout time.Duration) {
+	var children []*childProcess
+	if child := g.sharedChild(); child != nil {
+		children = append(children, child)
+	}
+	for _, sp := range g.processes.list() {
+		sp.mu.Lock()
+		sp.closed = true
+		if sp.child != nil {
+			children = append(children, sp.child)
+		}
+		sp.mu.Unlock()
+	}
+
+	var wg sync.WaitGroup
+	for _, child := range children {
+		wg.Add(1)
+		go func(child *childProcess) {
+			defer wg.Done()
+			child.terminate(timeout)
+		}(child)
+	}
+	wg.Wait()
+}
+
+// terminate asks the child to exit by closing its stdin and sending SIGTERM,
+// and kills it if it is still running after timeout. Its exit does not count
+// as a crash.
+func (c *childProcess) terminate(timeout time.Duration) {
+	c.stopped.Store(true)
+	if c.stdin != nil {
+		_ = c.stdin.Close()
+	}
+	if c.cmd == nil || c.cmd.Process == nil {
+		return
+	}
+	if err := c.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		// The process is gone already or cannot be signalled
+		_ = c.cmd.Process.Kill()
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-c.exited:
+		return
+	case <-timer.C:
+	}
+	log.Printf("MCP server with PID %d did not exit within %s, killing it", c.cmd.Process.Pid, timeout)
+	_ = c.cmd.Process.Kill()
+	timer.Reset(timeout)
+	select {
+	case <-c.exited:
+	case <-timer.C:
+		log.Printf("MCP server with PID %d still holds its output open after being killed", c.cmd.Process.Pid)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestShutdownDrainsInFlightRequests(t *testing.T) {
+	release := make(chan struct{})
+	var g *Gateway
+	g = newTestGateway(t, func(msg JSONRPCMessage) []string {
+		id, _ := json.Marshal(msg.ID)
+		go func() {
+			<-release
+			g.handleChildLine(g.child, fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":{}}`, id))
+		}()
+		return nil
+	})
+	server := httptest.NewServer(http.HandlerFunc(g.HandleHTTPMessage))
+	defer server.Close()
+
+	replied := make(chan int, 1)
+	go func() {
+		resp, err := http.Post(server.URL, "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"slow"}}`))
+		if err != nil {
+			replied <- 0
+			return
+		}
+		resp.Body.Close()
+		replied <- resp.StatusCode
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for g.routes.Len() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("request never reached the child")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		g.Shutdown(ctx, time.Second, server.Config)
+		close(done)
+	}()
+	for !g.draining.Load() {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// New sessions are refused while draining
+	recorder := httptest.NewRecorder()
+	g.HandleHTTPMessage(recorder, httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":2,"method":"initialize","params":{}}`)))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("initialize while draining: status = %d, want 503", recorder.Code)
+	}
+
+	select {
+	case <-done:
+		t.Fatal("shutdown finished with a request in flight")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	if status := <-replied; status != http.StatusOK {
+		t.Fatalf("in-flight request: status = %d, want 200", status)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not finish after the request completed")
+	}
+}
+
+func TestShutdownClosesWebSockets(t *testing.T) {
+	g := newTestGateway(t, echoReply)
+	server := httptest.NewServer(http.HandlerFunc(g.HandleWebSocket))
+	t.Cleanup(server.Close)
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	conn := dialWebSocket(t, url, nil)
+	waitForClients(t, g, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	g.Shutdown(ctx, time.Second)
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Fatalf("read after shutdown: %v, want close 1001", err)
+	}
+}
+
+func TestChildTerminate(t *testing.T) {
+	for name, command := range map[string][]string{
+		"exits on SIGTERM": {"sleep", "30"},
+		"ignores SIGTERM":  {"sh", "-c", `trap "" TERM; while :; do :; done`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			g := NewGateway()
+			g.cmdParts = command
+			c, err := g.startChild("")
+			if err != nil {
+				t.Fatalf("startChild: %v", err)
+			}
+
+			// Give the shell time to install its trap
+			time.Sleep(100 * time.Millisecond)
+			start := time.Now()
+			c.terminate(200 * time.Millisecond)
+			if c.running() {
+				t.Fatal("child still running after terminate")
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Fatalf("terminate took %s", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Paths of the legacy HTTP+SSE transport (protocol version 2024-11-05)
+const (
+	legacySSEPath      = "/sse"
+	legacyMessagesPath = "/messages"
+)
+
+// Transports the gateway can serve, possibly several at once on one port
+const (
+	transportWebSocket  = "websocket"
+	transportHTTPStream = "http-stream"
+	transportSSE        = "sse"
+)
+
+// parseTransports parses a comma-separated list of transports, e.g.
+// "http-stream,sse,websocket"
+func parseTransports(value string) (map[string]bool, error) {
+	transports := make(map[string]bool)
+	for _, name := range splitList(value) {
+		switch name {
+		case transportWebSocket, transportHTTPStream, transportSSE:
+			transports[name] = true
+		default:
+			return nil, fmt.Errorf("invalid transport: %s. Must be 'websocket', 'http-stream' or 'sse'", name)
+		}
+	}
+	if len(transports) == 0 {
+		return nil, errors.New("no transport given")
+	}
+	return transports, nil
+}
+
+// HandleSSE opens a legacy HTTP+SSE session (GET /sse). The first event names
+// the endpoint the client POSTs its messages to; replies and server messages
+// follow on the stream as "message" events until the client disconnects,
+// which ends the session.
+func (g *Gateway) HandleSSE(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	if g.rejectWhileDraining(w) {
+		return
+	}
+
+	sessionID := uuid.New().String()
+	if g.sessionMode == sessionModeIsolated {
+		if err := g.startSessionProcess(sessionID); err != nil {
+			log.Printf("Rejecting SSE connection: %v", err)
+			if errors.Is(err, errTooManyProcesses) {
+				http.Error(w, "Too many processes", http.StatusServiceUnavailable)
+			} else {
+				http.Error(w, "Failed to start MCP server", http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+
+	sseClient := g.registerSSEClient(sessionID, transportSSE)
+	defer func() {
+		g.unregisterSSEClient(sseClient)
+		g.releaseSession(sessionID)
+		log.Printf("SSE session closed: %s", sessionID)
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	endpoint := legacyMessagesPath + "?sessionId=" + url.QueryEscape(sessionID)
+	if _, err := fmt.Fprintf(w, "event: endpoint\ndata: %s\n\n", endpoint); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	log.Printf("SSE session opened: %s", sessionID)
+	g.streamSSEClient(w, r, flusher, sseClient, nil)
+}
+
+// HandleSSEMessage accepts a message POSTed by a legacy HTTP+SSE client
+// (POST /messages?sessionId=...). The reply is sent on the session's SSE
+// stream, so the POST itself is answered with 202 Accepted.
+func (g *Gateway) HandleSSEMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sessionID := r.URL.Query().Get("sessionId")
+	if sessionID == "" {
+		http.Error(w, "Missing sessionId parameter", http.StatusBadRequest)
+		return
+	}
+	// Streamable HTTP sessions have SSE streams too, which do not accept
+	// messages here
+	g.sseClientsMu.RLock()
+	stream, ok := g.sseClients[sessionID]
+	g.sseClientsMu.RUnlock()
+	if !ok || stream.Transport != transportSSE {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if contentType := r.Header.Get("Content-Type"); contentType != "" && !strings.HasPrefix(contentType, "application/json") {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if isBatch(body) {
+		if !g.forwardSSEBatch(stream, body, traceContextFromHeaders(r.Header)) {
+			http.Error(w, "Failed to send message to MCP server", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte("Accepted"))
+		return
+	}
+
+	var msg JSONRPCMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		http.Error(w, "Invalid JSON-RPC message", http.StatusBadRequest)
+		return
+	}
+	if err := g.SendToMCP(msg, sessionID); err != nil {
+		log.Printf("Failed to send message to MCP from SSE session %s: %v", sessionID, err)
+		http.Error(w, "Failed to send message to MCP server", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	_, _ = w.Write([]byte("Accepted"))
+}
+
+// forwardSSEBatch forwards a batch POSTed by a legacy HTTP+SSE client and
+// sends the replies on the session's stream as one array once all of them
+// have arrived. Requests still in flight when the stream closes are
+// cancelled.
+func (g *Gateway) forwardSSEBatch(stream *SSEClient, body []byte, parent traceContext) bool {
+	sessionID := stream.ID
+	msgs, results, err := parseBatch(body)
+	if err != nil {
+		log.Printf("Invalid batch from SSE session %s: %v", sessionID, err)
+		g.sendToSession(sessionID, newErrorResponse(nil, jsonRPCInvalidRequest, "Invalid Request"))
+		return true
+	}
+
+	batch, err := g.forwardBatch(sessionID, msgs, parent)
+	if err != nil {
+		log.Printf("Failed to send batch to MCP from SSE session %s: %v", sessionID, err)
+		return false
+	}
+
+	go func() {
+		replies, ok := batch.wait(g, g.responseTimeout, stream.Done)
+		if !ok {
+			return
+		}
+		results = append(results, replies...)
+		if len(results) == 0 {
+			return
+		}
+		data, err := json.Marshal(results)
+		if err != nil {
+			log.Printf("Failed to encode batch response for SSE session %s: %v", sessionID, err)
+			return
+		}
+		if !g.sendToSession(sessionID, data) {
+			log.Printf("Dropping batch response for SSE session %s: no open stream", sessionID)
+		}
+	}()
+	return true
+}
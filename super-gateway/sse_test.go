@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// readSSEEvent reads the next event from an SSE stream, skipping comments
+func readSSEEvent(t *testing.T, reader *bufio.Reader) (string, string) {
+	t.Helper()
+	var event, data string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read event: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "" && (event != "" || data != ""):
+			return event, data
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestLegacySSETransport(t *testing.T) {
+	g := newTestGateway(t, echoReply)
+	mux := http.NewServeMux()
+	mux.HandleFunc(legacySSEPath, g.HandleSSE)
+	mux.HandleFunc(legacyMessagesPath, g.HandleSSEMessage)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	resp, err := http.Get(server.URL + legacySSEPath)
+	if err != nil {
+		t.Fatalf("GET /sse: %v", err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", got)
+	}
+	reader := bufio.NewReader(resp.Body)
+	event, endpoint := readSSEEvent(t, reader)
+	if event != "endpoint" || !strings.HasPrefix(endpoint, legacyMessagesPath+"?sessionId=") {
+		t.Fatalf("first event = %s %q, want endpoint", event, endpoint)
+	}
+
+	post, err := http.Post(server.URL+endpoint, "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":"a","method":"tools/list"}`))
+	if err != nil {
+		t.Fatalf("POST message: %v", err)
+	}
+	post.Body.Close()
+	if post.StatusCode != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202", post.StatusCode)
+	}
+	if event, data := readSSEEvent(t, reader); event != "message" || !strings.Contains(data, `"id":"a"`) {
+		t.Fatalf("reply event = %s %s", event, data)
+	}
+
+	post, err = http.Post(server.URL+endpoint, "application/json", strings.NewReader(`[{"jsonrpc":"2.0","id":1,"method":"ping"},{"jsonrpc":"2.0","id":2,"method":"ping"}]`))
+	if err != nil {
+		t.Fatalf("POST batch: %v", err)
+	}
+	post.Body.Close()
+	if event, data := readSSEEvent(t, reader); event != "message" || !strings.HasPrefix(data, "[") || !strings.Contains(data, `"id":2`) {
+		t.Fatalf("batch reply event = %s %s", event, data)
+	}
+}
+
+func TestLegacySSEMessageRequiresSession(t *testing.T) {
+	g := newTestGateway(t, echoReply)
+	server := httptest.NewServer(http.HandlerFunc(g.HandleSSEMessage))
+	t.Cleanup(server.Close)
+
+	for path, want := range map[string]int{
+		legacyMessagesPath:                   http.StatusBadRequest,
+		legacyMessagesPath + "?sessionId=xx": http.StatusNotFound,
+	} {
+		resp, err := http.Post(server.URL+path, "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"ping"}`))
+		if err != nil {
+			t.Fatalf("POST %s: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("POST %s: status = %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestParseTransports(t *testing.T) {
+	transports, err := parseTransports("http-stream, sse,websocket")
+	if err != nil || len(transports) != 3 || !transports[transportSSE] {
+		t.Fatalf("parseTransports = %v, %v", transports, err)
+	}
+	for _, value := range []string{"", "grpc", "sse,stdio"} {
+		if _, err := parseTransports(value); err == nil {
+			t.Errorf("parseTransports(%q) accepted", value)
+		}
+	}
+}
+
+func TestLegacyMessagesRejectsStreamableSession(t *testing.T) {
+	g := newTestGateway(t, echoReply)
+	server := httptest.NewServer(mcpHandler(g))
+	t.Cleanup(server.Close)
+	sessionID := initializeSession(t, server.URL)
+	stream := doSessionRequest(t, http.MethodGet, server.URL, sessionID, "")
+	defer stream.Body.Close()
+	if stream.StatusCode != http.StatusOK {
+		t.Fatalf("GET status = %d, want 200", stream.StatusCode)
+	}
+
+	rec := httptest.NewRecorder()
+	g.HandleSSEMessage(rec, httptest.NewRequest(http.MethodPost, legacyMessagesPath+"?sessionId="+sessionID, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404 for a Streamable HTTP session", rec.Code)
+	}
+}
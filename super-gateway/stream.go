@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// requestStream collects the messages the child emits on behalf of a single
+// in-flight request whose client accepts an SSE response (progress updates,
+// log messages, server-initiated requests) until the final response arrives.
+type requestStream struct {
+	Key           string
+	ClientID      string
+	ProgressToken json.RawMessage
+	Send          chan []byte
+	// Done is closed once the request's handler stops reading Send
+	Done chan struct{}
+}
+
+// acceptsEventStream reports whether the Accept header allows an SSE response
+func acceptsEventStream(accept string) bool {
+	return strings.Contains(accept, "text/event-stream")
+}
+
+// writeSSEEvent writes a single JSON-RPC message as an SSE "message" event,
+// with its ID when the stream can be resumed
+func writeSSEEvent(w io.Writer, event sseEvent) error {
+	if event.ID != 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", event.ID); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "event: message\ndata: %s\n\n", event.Data)
+	return err
+}
+
+// openRequestStream registers a stream for the request identified by key. If
+// the request carries a progress token, it is rewritten to the key so that
+// progress notifications from the child can be matched back to this stream
+// even when several clients picked the same token.
+func (g *Gateway) openRequestStream(key, clientID string, msg *JSONRPCMessage) *requestStream {
+	stream := &requestStream{
+		Key:      key,
+		ClientID: clientID,
+		Send:     make(chan []byte, g.outbound.QueueSize),
+		Done:     make(chan struct{}),
+	}
+
+	if params, token, ok := rewriteProgressToken(msg.Params, key); ok {
+		msg.Params = params
+		stream.ProgressToken = token
+	}
+
+	g.streamsMu.Lock()
+	g.streams[key] = stream
+	g.streamsMu.Unlock()
+	return stream
+}
+
+// closeRequestStream removes the stream registered for key
+func (g *Gateway) closeRequestStream(key string) {
+	g.streamsMu.Lock()
+	defer g.streamsMu.Unlock()
+	if stream, ok := g.streams[key]; ok {
+		delete(g.streams, key)
+		close(stream.Done)
+	}
+}
+
+// routeToRequestStream delivers a child message that carries no routed ID to
+// the request stream it belongs to. Progress notifications are matched by
+// their progress token. Log messages carry no correlation, so they are only
+// attached to a stream when it is the only request stream open for a request
+// forwarded to the child; otherwise they are left to the session and
+// broadcast paths.
+func (g *Gateway) routeToRequestStream(c *childProcess, msg JSONRPCMessage) bool {
+	stream, msg, ok := g.requestStreamFor(c, msg)
+	if !ok {
+		return false
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return false
+	}
+	g.sendToStream(stream, data)
+	return true
+}
+
+// requestStreamFor finds the request stream of a child message for
+// routeToRequestStream, restoring the client's progress token
+func (g *Gateway) requestStreamFor(c *childProcess, msg JSONRPCMessage) (*requestStream, JSONRPCMessage, bool) {
+	switch msg.Method {
+	case "notifications/progress":
+		return g.progressStreamFor(msg)
+	case "notifications/message":
+		stream, ok := g.soleRequestStream(c)
+		return stream, msg, ok
+	}
+	return nil, msg, false
+}
+
+// progressStreamFor finds the request stream a progress notification's token
+// was rewritten to
+func (g *Gateway) progressStreamFor(msg JSONRPCMessage) (*requestStream, JSONRPCMessage, bool) {
+	g.streamsMu.RLock()
+	defer g.streamsMu.RUnlock()
+	if len(g.streams) == 0 {
+		return nil, msg, false
+	}
+
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return nil, msg, false
+	}
+	var token string
+	if err := json.Unmarshal(params["progressToken"], &token); err != nil {
+		return nil, msg, false
+	}
+	stream, ok := g.streams[token]
+	if !ok || stream.ProgressToken == nil {
+		return nil, msg, false
+	}
+	params["progressToken"] = stream.ProgressToken
+	restored, err := json.Marshal(params)
+	if err != nil {
+		return nil, msg, false
+	}
+	msg.Params = restored
+	return stream, msg, true
+}
+
+// soleRequestStream returns the request stream open for a request forwarded
+// to c when there is exactly one
+func (g *Gateway) soleRequestStream(c *childProcess) (*requestStream, bool) {
+	g.streamsMu.RLock()
+	defer g.streamsMu.RUnlock()
+
+	var sole *requestStream
+	for key, stream := range g.streams {
+		id, err := strconv.ParseInt(key, 10, 64)
+		if err != nil || !g.routes.ForwardedTo(id, c) {
+			continue
+		}
+		if sole != nil {
+			return nil, false
+		}
+		sole = stream
+	}
+	return sole, sole != nil
+}
+
+// requestStreamForServerRequest finds the request stream whose progress token
+// a server-initiated request carries in params._meta, restoring the client's
+// own token
+func (g *Gateway) requestStreamForServerRequest(msg JSONRPCMessage) (*requestStream, JSONRPCMessage, bool) {
+	token, ok := progressTokenOf(msg.Params)
+	if !ok {
+		return nil, msg, false
+	}
+	g.streamsMu.RLock()
+	stream, ok := g.streams[token]
+	g.streamsMu.RUnlock()
+	if !ok || stream.ProgressToken == nil {
+		return nil, msg, false
+	}
+	params, _, ok := replaceProgressToken(msg.Params, stream.ProgressToken)
+	if !ok {
+		return nil, msg, false
+	}
+	msg.Params = params
+	return stream, msg, true
+}
+
+// progressTokenOf returns params._meta.progressToken when it is a string
+func progressTokenOf(params json.RawMessage) (string, bool) {
+	var fields struct {
+		Meta struct {
+			ProgressToken json.RawMessage `json:"progressToken"`
+		} `json:"_meta"`
+	}
+	if err := json.Unmarshal(params, &fields); err != nil {
+		return "", false
+	}
+	var token string
+	if err := json.Unmarshal(fields.Meta.ProgressToken, &token); err != nil {
+		return "", false
+	}
+	return token, true
+}
+
+// rewriteProgressToken replaces params._meta.progressToken with token and
+// returns the updated params together with the original token
+func rewriteProgressToken(params json.RawMessage, token string) (json.RawMessage, json.RawMessage, bool) {
+	rewritten, err := json.Marshal(token)
+	if err != nil {
+		return params, nil, false
+	}
+	return replaceProgressToken(params, rewritten)
+}
+
+// replaceProgressToken sets params._meta.progressToken to the raw token and
+// returns the updated params together with the previous token
+func replaceProgressToken(params, token json.RawMessage) (json.RawMessage, json.RawMessage, bool) {
+	if len(params) == 0 {
+		return params, nil, false
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(params, &fields); err != nil {
+		return params, nil, false
+	}
+	var meta map[string]json.RawMessage
+	if err := json.Unmarshal(fields["_meta"], &meta); err != nil {
+		return params, nil, false
+	}
+	original, ok := meta["progressToken"]
+	if !ok {
+		return params, nil, false
+	}
+
+	var err error
+	meta["progressToken"] = token
+	if fields["_meta"], err = json.Marshal(meta); err != nil {
+		return params, nil, false
+	}
+	updated, err := json.Marshal(fields)
+	if err != nil {
+		return params, nil, false
+	}
+	return updated, original, true
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleHTTPMessageStreamsProgress(t *testing.T) {
+	g := newTestGateway(t, func(msg JSONRPCMessage) []string {
+		var params struct {
+			Meta struct {
+				ProgressToken string `json:"progressToken"`
+			} `json:"_meta"`
+		}
+		_ = json.Unmarshal(msg.Params, &params)
+		id, _ := json.Marshal(msg.ID)
+		return []string{
+			fmt.Sprintf(`{"jsonrpc":"2.0","method":"notifications/progress","params":{"progressToken":%q,"progress":1,"total":2}}`, params.Meta.ProgressToken),
+			`{"jsonrpc":"2.0","method":"notifications/message","params":{"level":"info","data":"searching"}}`,
+			fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":{"content":[]}}`, id),
+		}
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"search","_meta":{"progressToken":42}}}`))
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	recorder := httptest.NewRecorder()
+	g.HandleHTTPMessage(recorder, req)
+
+	if got := recorder.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", got)
+	}
+	events := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n\n")
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3: %s", len(events), recorder.Body.String())
+	}
+	if !strings.Contains(events[0], `"progressToken":42`) {
+		t.Fatalf("progress event did not restore the original token: %s", events[0])
+	}
+	if !strings.Contains(events[1], `notifications/message`) {
+		t.Fatalf("expected log message event, got %s", events[1])
+	}
+	if !strings.Contains(events[2], `"id":7`) || !strings.Contains(events[2], `"result"`) {
+		t.Fatalf("expected final response event, got %s", events[2])
+	}
+}
+
+func TestLogMessageWithSeveralStreamsGoesToStandaloneStream(t *testing.T) {
+	g := newTestGateway(t, func(msg JSONRPCMessage) []string { return nil })
+	standalone := g.registerSSEClient("session", transportHTTPStream)
+
+	var streams []*requestStream
+	for _, clientID := range []string{"tenant-a", "tenant-b"} {
+		id := g.routes.Register(clientID, json.RawMessage(`1`), make(chan []byte, 1))
+		g.routes.Forward(id, g.child)
+		key := fmt.Sprint(id)
+		streams = append(streams, g.openRequestStream(key, clientID, &JSONRPCMessage{}))
+		defer g.closeRequestStream(key)
+	}
+
+	g.handleChildLine(g.child, `{"jsonrpc":"2.0","method":"notifications/message","params":{"level":"info","data":"searching"}}`)
+
+	// Neither request can be told apart as the source, so the log message
+	// goes to the GET stream
+	select {
+	case event := <-standalone.Send:
+		if !strings.Contains(string(event.Data), `notifications/message`) {
+			t.Fatalf("standalone stream event = %s, want the log message", event.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("log message not sent on the standalone stream")
+	}
+	for _, stream := range streams {
+		select {
+		case data := <-stream.Send:
+			t.Fatalf("request stream %s received %s", stream.Key, data)
+		default:
+		}
+	}
+}
+
+func TestHandleHTTPMessageReturnsJSONWithoutStreamedMessages(t *testing.T) {
+	g := newTestGateway(t, func(msg JSONRPCMessage) []string {
+		id, _ := json.Marshal(msg.ID)
+		return []string{fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":{"tools":[]}}`, id)}
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`))
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	recorder := httptest.NewRecorder()
+	g.HandleHTTPMessage(recorder, req)
+
+	if got := recorder.Header().Get("Content-Type"); got != "application/json" {
+		t.Fatalf("Content-Type = %q, want application/json", got)
+	}
+	if !strings.Contains(recorder.Body.String(), `"id":1`) {
+		t.Fatalf("unexpected body: %s", recorder.Body.String())
+	}
+}
+
+func TestRewriteProgressToken(t *testing.T) {
+	params, original, ok := rewriteProgressToken(json.RawMessage(`{"name":"x","_meta":{"progressToken":"abc"}}`), "client:1")
+	if !ok {
+		t.Fatal("expected progress token to be rewritten")
+	}
+	if string(original) != `"abc"` {
+		t.Fatalf("original token = %s, want \"abc\"", original)
+	}
+	if !strings.Contains(string(params), `"progressToken":"client:1"`) {
+		t.Fatalf("params not rewritten: %s", params)
+	}
+
+	if _, _, ok := rewriteProgressToken(json.RawMessage(`{"name":"x"}`), "client:1"); ok {
+		t.Fatal("params without a progress token should not be rewritten")
+	}
+}
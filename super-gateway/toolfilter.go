@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+// maxToolListPages bounds the pages fetched when the gateway lists a
+// child's tools itself
+const maxToolListPages = 100
+
+// toolFilterConfig selects and renames the tools a child exposes. Allow and
+// deny are glob patterns matched against the child's own tool names; a tool
+// is exposed when it matches an allow pattern (or none are given) and no deny
+// pattern. Renames and description overrides are keyed by the child's names;
+// tools that are not renamed get the prefix.
+type toolFilterConfig struct {
+	Allow        []string          `json:"allow"`
+	Deny         []string          `json:"deny"`
+	Prefix       string            `json:"prefix"`
+	Rename       map[string]string `json:"rename"`
+	Descriptions map[string]string `json:"descriptions"`
+}
+
+// loadToolFilterConfig reads a JSON tool filter config file
+func loadToolFilterConfig(filename string) (toolFilterConfig, error) {
+	var cfg toolFilterConfig
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read tools config: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid tools config %s: %w", filename, err)
+	}
+	return cfg, nil
+}
+
+// parseToolRenames parses a comma-separated list of original=exposed pairs
+func parseToolRenames(value string) (map[string]string, error) {
+	renames := make(map[string]string)
+	for _, pair := range splitList(value) {
+		original, exposed, ok := strings.Cut(pair, "=")
+		if !ok || original == "" || exposed == "" {
+			return nil, fmt.Errorf("invalid tool rename %q: want original=exposed", pair)
+		}
+		renames[original] = exposed
+	}
+	return renames, nil
+}
+
+// toolFilter enforces a toolFilterConfig on tools/list results and
+// tools/call requests. A nil filter exposes every tool unchanged.
+type toolFilter struct {
+	allow        []string
+	deny         []string
+	prefix       string
+	rename       map[string]string
+	original     map[string]string // exposed name -> child's name, for renames
+	descriptions map[string]string
+}
+
+// newToolFilter returns nil when cfg leaves the tool list untouched
+func newToolFilter(cfg toolFilterConfig) (*toolFilter, error) {
+	if len(cfg.Allow) == 0 && len(cfg.Deny) == 0 && cfg.Prefix == "" && len(cfg.Rename) == 0 && len(cfg.Descriptions) == 0 {
+		return nil, nil
+	}
+	for _, pattern := range append(append([]string{}, cfg.Allow...), cfg.Deny...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid tool pattern %q: %w", pattern, err)
+		}
+	}
+
+	f := &toolFilter{
+		allow:        cfg.Allow,
+		deny:         cfg.Deny,
+		prefix:       cfg.Prefix,
+		rename:       cfg.Rename,
+		original:     make(map[string]string),
+		descriptions: cfg.Descriptions,
+	}
+	for original, exposed := range cfg.Rename {
+		if other, ok := f.original[exposed]; ok {
+			return nil, fmt.Errorf("tools %q and %q are both renamed to %q", other, original, exposed)
+		}
+		f.original[exposed] = original
+	}
+	return f, nil
+}
+
+// allowed reports whether the child's tool name is exposed
+func (f *toolFilter) allowed(name string) bool {
+	for _, pattern := range f.deny {
+		if matched, _ := path.Match(pattern, name); matched {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, pattern := range f.allow {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// exposedName returns the name clients see for the child's tool name
+func (f *toolFilter) exposedName(name string) string {
+	if exposed, ok := f.rename[name]; ok {
+		return exposed
+	}
+	return f.prefix + name
+}
+
+// originalName maps a name a client called back to the child's tool name,
+// reporting false for tools that are not exposed
+func (f *toolFilter) originalName(exposed string) (string, bool) {
+	name, ok := f.original[exposed]
+	if !ok {
+		if !strings.HasPrefix(exposed, f.prefix) {
+			return "", false
+		}
+		name = strings.TrimPrefix(exposed, f.prefix)
+		if _, renamed := f.rename[name]; renamed {
+			// Only reachable under its new name
+			return "", false
+		}
+	}
+	return name, f.allowed(name)
+}
+
+// rewriteCall maps the tool of a tools/call request to the child's name and
+// reports false when the tool is not exposed
+func (f *toolFilter) rewriteCall(msg JSONRPCMessage) (JSONRPCMessage, bool) {
+	if f == nil {
+		return msg, true
+	}
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return msg, false
+	}
+	name, ok := f.originalName(toolName(msg))
+	if !ok {
+		return msg, false
+	}
+	encoded, _ := json.Marshal(name)
+	params["name"] = encoded
+	rewritten, err := json.Marshal(params)
+	if err != nil {
+		return msg, false
+	}
+	msg.Params = rewritten
+	return msg, true
+}
+
+// filterList applies the filter to a tools/list result. Results that cannot
+// be decoded are replaced by an empty list rather than leaking hidden tools.
+func (f *toolFilter) filterList(result json.RawMessage) json.RawMessage {
+	if f == nil {
+		return result
+	}
+	var fields map[string]json.RawMessage
+	var tools []map[string]json.RawMessage
+	if err := json.Unmarshal(result, &fields); err == nil {
+		if err := json.Unmarshal(fields["tools"], &tools); err != nil {
+			tools = nil
+		}
+	}
+	if fields == nil {
+		fields = make(map[string]json.RawMessage)
+	}
+
+	exposed := make([]map[string]json.RawMessage, 0, len(tools))
+	for _, tool := range tools {
+		var name string
+		if err := json.Unmarshal(tool["name"], &name); err != nil || !f.allowed(name) {
+			continue
+		}
+		tool["name"], _ = json.Marshal(f.exposedName(name))
+		if description, ok := f.descriptions[name]; ok {
+			tool["description"], _ = json.Marshal(description)
+		}
+		exposed = append(exposed, tool)
+	}
+	fields["tools"], _ = json.Marshal(exposed)
+
+	filtered, err := json.Marshal(fields)
+	if err != nil {
+		return json.RawMessage(`{"tools":[]}`)
+	}
+	return filtered
+}
+
+// listTools fetches every page of a child's tool list with the filter
+// applied and returns the tools as JSON
+func (g *Gateway) listTools(c *childProcess) (string, error) {
+	var tools []json.RawMessage
+	var cursor string
+	for page := 0; page < maxToolListPages; page++ {
+		var params json.RawMessage
+		if cursor != "" {
+			params, _ = json.Marshal(map[string]string{"cursor": cursor})
+		}
+		reply, err := c.call("tools/list", params, g.responseTimeout)
+		if err != nil {
+			return "", err
+		}
+		var result struct {
+			Tools      []json.RawMessage `json:"tools"`
+			NextCursor string            `json:"nextCursor"`
+		}
+		if err := json.Unmarshal(g.tools.filterList(reply.Result), &result); err != nil {
+			return "", err
+		}
+		tools = append(tools, result.Tools...)
+		if cursor = result.NextCursor; cursor == "" {
+			break
+		}
+	}
+	data, err := json.Marshal(tools)
+	return string(data), err
+}
+
+// forwardToolsListChanged passes a child's tools/list_changed notification on
+// only when the filtered tool list changed, so changes to hidden tools stay
+// invisible. When the list cannot be fetched, or clients have not seen one
+// yet, the notification is forwarded.
+func (g *Gateway) forwardToolsListChanged(c *childProcess, msg JSONRPCMessage) {
+	c.toolsMu.Lock()
+	defer c.toolsMu.Unlock()
+
+	tools, err := g.listTools(c)
+	if err != nil {
+		log.Printf("Failed to list tools after list change, forwarding notification: %v", err)
+		g.deliverNotification(c, msg)
+		return
+	}
+	if tools == c.exposedTools {
+		log.Printf("Suppressing tools/list_changed: filtered tool list unchanged")
+		return
+	}
+	c.exposedTools = tools
+	g.deliverNotification(c, msg)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// toolsReply emulates a child that lists the given tools and answers every
+// other request like echoReply
+func toolsReply(tools func() []string) func(msg JSONRPCMessage) []string {
+	return func(msg JSONRPCMessage) []string {
+		if msg.Method != "tools/list" {
+			return echoReply(msg)
+		}
+		// The tools come on the first page, followed by an empty last one
+		list := []map[string]string{}
+		page := map[string]interface{}{"tools": &list}
+		if !strings.Contains(string(msg.Params), "cursor") {
+			for _, name := range tools() {
+				list = append(list, map[string]string{"name": name, "description": "original " + name})
+			}
+			page["nextCursor"] = "next"
+		}
+		result, _ := json.Marshal(page)
+		return []string{fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":%s}`, msg.ID, result)}
+	}
+}
+
+func postMCP(t *testing.T, g *Gateway, body string) []byte {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	g.HandleHTTPMessage(rec, httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	return rec.Body.Bytes()
+}
+
+func newGitHubFilter(t *testing.T) *toolFilter {
+	t.Helper()
+	filter, err := newToolFilter(toolFilterConfig{
+		Allow:        []string{"read_*", "list_*"},
+		Deny:         []string{"read_secret"},
+		Prefix:       "gh_",
+		Rename:       map[string]string{"list_repos": "repos"},
+		Descriptions: map[string]string{"read_file": "Read a file"},
+	})
+	if err != nil {
+		t.Fatalf("newToolFilter: %v", err)
+	}
+	return filter
+}
+
+func TestToolFilterRewritesToolsList(t *testing.T) {
+	g := newTestGateway(t, toolsReply(func() []string {
+		return []string{"read_file", "read_secret", "list_repos", "delete_repo"}
+	}))
+	g.tools = newGitHubFilter(t)
+
+	var reply struct {
+		Result struct {
+			Tools []struct {
+				Name        string `json:"name"`
+				Description string `json:"description"`
+			} `json:"tools"`
+			NextCursor string `json:"nextCursor"`
+		} `json:"result"`
+	}
+	body := postMCP(t, g, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+	if err := json.Unmarshal(body, &reply); err != nil {
+		t.Fatalf("unmarshal %s: %v", body, err)
+	}
+
+	tools := reply.Result.Tools
+	if len(tools) != 2 || tools[0].Name != "gh_read_file" || tools[0].Description != "Read a file" || tools[1].Name != "repos" || tools[1].Description != "original list_repos" {
+		t.Fatalf("tools = %+v", tools)
+	}
+	if reply.Result.NextCursor != "next" {
+		t.Fatalf("nextCursor = %q, want next", reply.Result.NextCursor)
+	}
+}
+
+func TestToolFilterBlocksHiddenTools(t *testing.T) {
+	called := make(chan string, 10)
+	g := newTestGateway(t, func(msg JSONRPCMessage) []string {
+		if msg.Method == "tools/call" {
+			called <- toolName(msg)
+		}
+		return echoReply(msg)
+	})
+	g.tools = newGitHubFilter(t)
+
+	for _, name := range []string{"gh_read_file", "repos"} {
+		postMCP(t, g, fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":%q}}`, name))
+	}
+	if got := <-called; got != "read_file" {
+		t.Fatalf("child called %q, want read_file", got)
+	}
+	if got := <-called; got != "list_repos" {
+		t.Fatalf("child called %q, want list_repos", got)
+	}
+
+	for _, name := range []string{"read_file", "gh_delete_repo", "gh_read_secret", "gh_list_repos", "list_repos"} {
+		body := postMCP(t, g, fmt.Sprintf(`{"jsonrpc":"2.0","id":"call-1","method":"tools/call","params":{"name":%q}}`, name))
+		var reply struct {
+			ID    string `json:"id"`
+			Error struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(body, &reply); err != nil {
+			t.Fatalf("unmarshal %s: %v", body, err)
+		}
+		if reply.ID != "call-1" || reply.Error.Code != jsonRPCInvalidParams || reply.Error.Message != "Unknown tool: "+name {
+			t.Fatalf("%s: reply = %s", name, body)
+		}
+	}
+
+	// Blocked calls inside a batch are answered alongside the forwarded ones
+	body := postMCP(t, g, `[{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"gh_read_file"}},{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"delete_repo"}}]`)
+	var replies []JSONRPCMessage
+	if err := json.Unmarshal(body, &replies); err != nil || len(replies) != 2 {
+		t.Fatalf("batch reply = %s", body)
+	}
+	if got := <-called; got != "read_file" {
+		t.Fatalf("child called %q, want read_file", got)
+	}
+
+	select {
+	case name := <-called:
+		t.Fatalf("blocked tool %q reached the child", name)
+	default:
+	}
+}
+
+func TestToolsListChangedOnlyForVisibleChanges(t *testing.T) {
+	var mu sync.Mutex
+	childTools := []string{"read_file"}
+	filter, err := newToolFilter(toolFilterConfig{Deny: []string{"delete_*"}})
+	if err != nil {
+		t.Fatalf("newToolFilter: %v", err)
+	}
+	g := newTestGateway(t, toolsReply(func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, childTools...)
+	}))
+	g.tools = filter
+	client := &SSEClient{ID: "a", Send: make(chan sseEvent, 10), Done: make(chan struct{})}
+	g.sseClientsMu.Lock()
+	g.sseClients[client.ID] = client
+	g.sseClientsMu.Unlock()
+
+	setTools := func(tools ...string) {
+		mu.Lock()
+		childTools = tools
+		mu.Unlock()
+	}
+	notification := JSONRPCMessage{JSONRPC: "2.0", Method: "notifications/tools/list_changed"}
+	expectForwarded := func(want bool) {
+		t.Helper()
+		select {
+		case <-client.Send:
+			if !want {
+				t.Fatal("tools/list_changed forwarded")
+			}
+		case <-time.After(200 * time.Millisecond):
+			if want {
+				t.Fatal("tools/list_changed not forwarded")
+			}
+		}
+	}
+
+	// Nobody has seen the filtered list yet
+	g.handleChildLine(g.child, `{"jsonrpc":"2.0","method":"notifications/tools/list_changed"}`)
+	expectForwarded(true)
+
+	setTools("read_file", "delete_file")
+	g.forwardToolsListChanged(g.child, notification)
+	expectForwarded(false)
+
+	setTools("read_file", "delete_file", "write_file")
+	g.forwardToolsListChanged(g.child, notification)
+	expectForwarded(true)
+}
+
+func TestNewToolFilterValidation(t *testing.T) {
+	if f, err := newToolFilter(toolFilterConfig{}); f != nil || err != nil {
+		t.Fatalf("empty config = %v, %v", f, err)
+	}
+	if _, err := newToolFilter(toolFilterConfig{Allow: []string{"read_["}}); err == nil {
+		t.Fatal("invalid pattern accepted")
+	}
+	if _, err := newToolFilter(toolFilterConfig{Rename: map[string]string{"a": "x", "b": "x"}}); err == nil {
+		t.Fatal("conflicting renames accepted")
+	}
+	if _, err := parseToolRenames("a=b,c"); err == nil {
+		t.Fatal("rename without = accepted")
+	}
+	if renames, err := parseToolRenames("a=b, c=d"); err != nil || renames["a"] != "b" || renames["c"] != "d" {
+		t.Fatalf("parseToolRenames = %v, %v", renames, err)
+	}
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Exporters selectable with --trace-exporter
+const (
+	traceExporterNone   = "none"
+	traceExporterOTLP   = "otlp"
+	traceExporterStdout = "stdout"
+	traceExporterFile   = "file"
+)
+
+const (
+	defaultOTLPTracesEndpoint = "http://localhost:4318/v1/traces"
+	defaultTraceServiceName   = "super-gateway"
+	traceQueueSize            = 2048
+	traceBatchSize            = 512
+	traceFlushInterval        = 5 * time.Second
+)
+
+// traceContext is a W3C trace context as carried by the traceparent and
+// tracestate headers
+type traceContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+	State   string
+}
+
+// valid reports whether the context names a trace and a parent span
+func (tc traceContext) valid() bool {
+	return tc.TraceID != [16]byte{} && tc.SpanID != [8]byte{}
+}
+
+// traceparent formats the context as a version 00 traceparent value
+func (tc traceContext) traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", hex.EncodeToString(tc.TraceID[:]), hex.EncodeToString(tc.SpanID[:]), tc.Flags)
+}
+
+// parseTraceparent parses a traceparent value. Unknown future versions are
+// accepted as long as they start with the version 00 fields.
+func parseTraceparent(value string) (traceContext, bool) {
+	var tc traceContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return tc, false
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return tc, false
+	}
+	if _, err := hex.Decode(tc.TraceID[:], []byte(parts[1])); err != nil {
+		return tc, false
+	}
+	if _, err := hex.Decode(tc.SpanID[:], []byte(parts[2])); err != nil {
+		return tc, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return tc, false
+	}
+	tc.Flags = byte(flags)
+	return tc, tc.valid()
+}
+
+// traceContextFromHeaders extracts the trace context of an incoming request
+func traceContextFromHeaders(header http.Header) traceContext {
+	tc, ok := parseTraceparent(header.Get("traceparent"))
+	if !ok {
+		return traceContext{}
+	}
+	tc.State = header.Get("tracestate")
+	return tc
+}
+
+// traceContextFromParams extracts a trace context the client put into
+// params._meta itself
+func traceContextFromParams(params json.RawMessage) traceContext {
+	var fields struct {
+		Meta struct {
+			Traceparent string `json:"traceparent"`
+			Tracestate  string `json:"tracestate"`
+		} `json:"_meta"`
+	}
+	if err := json.Unmarshal(params, &fields); err != nil {
+		return traceContext{}
+	}
+	tc, ok := parseTraceparent(fields.Meta.Traceparent)
+	if !ok {
+		return traceContext{}
+	}
+	tc.State = fields.Meta.Tracestate
+	return tc
+}
+
+// injectTraceContext sets params._meta.traceparent (and tracestate) so an
+// instrumented child can continue the trace. Params that are not a JSON
+// object are returned unchanged.
+func injectTraceContext(params json.RawMessage, tc traceContext) json.RawMessage {
+	fields := make(map[string]json.RawMessage)
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &fields); err != nil || fields == nil {
+			return params
+		}
+	}
+	meta := make(map[string]json.RawMessage)
+	if raw, ok := fields["_meta"]; ok {
+		if err := json.Unmarshal(raw, &meta); err != nil || meta == nil {
+			return params
+		}
+	}
+
+	meta["traceparent"], _ = json.Marshal(tc.traceparent())
+	if tc.State != "" {
+		meta["tracestate"], _ = json.Marshal(tc.State)
+	} else {
+		delete(meta, "tracestate")
+	}
+
+	var err error
+	if fields["_meta"], err = json.Marshal(meta); err != nil {
+		return params
+	}
+	updated, err := json.Marshal(fields)
+	if err != nil {
+		return params
+	}
+	return updated
+}
+
+// span is a finished or in-progress server span for one JSON-RPC request
+type span struct {
+	TraceID      [16]byte
+	SpanID       [8]byte
+	ParentSpanID [8]byte
+	Flags        byte
+	State        string
+	Name         string
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]string
+	Error        bool
+}
+
+// context returns the trace context under which the span's children run
+func (s *span) context() traceContext {
+	return traceContext{TraceID: s.TraceID, SpanID: s.SpanID, Flags: s.Flags, State: s.State}
+}
+
+// spanExporter sends a batch of finished spans somewhere
+type spanExporter interface {
+	Export(spans []*span) error
+}
+
+// tracer creates spans and hands finished ones to an exporter in batches.
+// A nil tracer records nothing.
+type tracer struct {
+	serviceName string
+	exporter    spanExporter
+	queue       chan *span
+	flush       chan chan struct{}
+	closeOnce   sync.Once
+}
+
+func newTracer(serviceName string, exporter spanExporter) *tracer {
+	t := &tracer{
+		serviceName: serviceName,
+		exporter:    exporter,
+		queue:       make(chan *span, traceQueueSize),
+		flush:       make(chan chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// start begins a span that continues parent, or a new trace when parent is
+// not valid
+func (t *tracer) start(parent traceContext, name string, attributes map[string]string) *span {
+	if t == nil {
+		return nil
+	}
+	s := &span{
+		Name:       name,
+		Start:      time.Now(),
+		Attributes: attributes,
+		Flags:      0x01, // sampled
+	}
+	if parent.valid() {
+		s.TraceID = parent.TraceID
+		s.ParentSpanID = parent.SpanID
+		s.Flags = parent.Flags
+		s.State = parent.State
+	} else {
+		_, _ = rand.Read(s.TraceID[:])
+	}
+	_, _ = rand.Read(s.SpanID[:])
+	return s
+}
+
+// finish ends s with the given outcome and queues it for export. Spans are
+// dropped rather than blocking the gateway when the exporter falls behind.
+func (t *tracer) finish(s *span, outcome string) {
+	if t == nil || s == nil {
+		return
+	}
+	s.End = time.Now()
+	s.Attributes["mcp.gateway.outcome"] = outcome
+	s.Error = outcome != outcomeSuccess
+	if s.Flags&0x01 == 0 {
+		// The caller asked for the trace not to be recorded
+		return
+	}
+	select {
+	case t.queue <- s:
+	default:
+		log.Printf("Dropping span %s: export queue full", s.Name)
+	}
+}
+
+// Close exports every queued span and stops the tracer
+func (t *tracer) Close() {
+	if t == nil {
+		return
+	}
+	t.closeOnce.Do(func() {
+		done := make(chan struct{})
+		t.flush <- done
+		<-done
+	})
+}
+
+func (t *tracer) run() {
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+
+	var batch []*span
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.Export(batch); err != nil {
+			log.Printf("Failed to export %d spans: %v", len(batch), err)
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case s := <-t.queue:
+			batch = append(batch, s)
+			if len(batch) >= traceBatchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case done := <-t.flush:
+			for drained := false; !drained; {
+				select {
+				case s := <-t.queue:
+					batch = append(batch, s)
+				default:
+					drained = true
+				}
+			}
+			export()
+			close(done)
+			return
+		}
+	}
+}
+
+// otlpTraceRequest encodes spans as an OTLP/JSON ExportTraceServiceRequest
+func otlpTraceRequest(serviceName string, spans []*span) ([]byte, error) {
+	type keyValue struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+		} `json:"value"`
+	}
+	attributes := func(m map[string]string) []keyValue {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		kvs := make([]keyValue, 0, len(keys))
+		for _, k := range keys {
+			kv := keyValue{Key: k}
+			kv.Value.StringValue = m[k]
+			kvs = append(kvs, kv)
+		}
+		return kvs
+	}
+
+	type otlpStatus struct {
+		Code int `json:"code"`
+	}
+	type otlpSpan struct {
+		TraceID           string     `json:"traceId"`
+		SpanID            string     `json:"spanId"`
+		ParentSpanID      string     `json:"parentSpanId,omitempty"`
+		TraceState        string     `json:"traceState,omitempty"`
+		Name              string     `json:"name"`
+		Kind              int        `json:"kind"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+		Attributes        []keyValue `json:"attributes"`
+		Status            otlpStatus `json:"status"`
+	}
+
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		o := otlpSpan{
+			TraceID:           hex.EncodeToString(s.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.SpanID[:]),
+			TraceState:        s.State,
+			Name:              s.Name,
+			Kind:              2, // SPAN_KIND_SERVER
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        attributes(s.Attributes),
+			Status:            otlpStatus{Code: 1}, // STATUS_CODE_OK
+		}
+		if s.ParentSpanID != [8]byte{} {
+			o.ParentSpanID = hex.EncodeToString(s.ParentSpanID[:])
+		}
+		if s.Error {
+			o.Status.Code = 2 // STATUS_CODE_ERROR
+		}
+		encoded = append(encoded, o)
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": attributes(map[string]string{"service.name": serviceName}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "super-gateway"},
+				"spans": encoded,
+			}},
+		}},
+	})
+}
+
+// otlpExporter posts spans to an OTLP/HTTP collector using the JSON encoding
+type otlpExporter struct {
+	serviceName string
+	endpoint    string
+	headers     map[string]string
+	client      *http.Client
+}
+
+func (e *otlpExporter) Export(spans []*span) error {
+	body, err := otlpTraceRequest(e.serviceName, spans)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("OTLP endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// writerExporter writes each batch as one line of OTLP/JSON, the format of
+// the OpenTelemetry Collector's file exporter
+type writerExporter struct {
+	serviceName string
+	mu          sync.Mutex
+	w           io.Writer
+}
+
+func (e *writerExporter) Export(spans []*span) error {
+	body, err := otlpTraceRequest(e.serviceName, spans)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.w.Write(append(body, '\n'))
+	return err
+}
+
+// parseOTLPHeaders parses OTEL_EXPORTER_OTLP_HEADERS ("k1=v1,k2=v2")
+func parseOTLPHeaders(value string) map[string]string {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			continue
+		}
+		headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return headers
+}
+
+// newTracerFromConfig builds the tracer for the selected exporter, or nil
+// when tracing is off. The OTLP endpoint, headers and service name follow
+// the standard OTEL_* environment variables.
+func newTracerFromConfig(exporter, endpoint, file string) (*tracer, error) {
+	serviceName := os.Getenv("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = defaultTraceServiceName
+	}
+
+	switch exporter {
+	case "", traceExporterNone:
+		return nil, nil
+	case traceExporterOTLP:
+		if endpoint == "" {
+			endpoint = os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+		}
+		if endpoint == "" {
+			if base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); base != "" {
+				endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+			}
+		}
+		if endpoint == "" {
+			endpoint = defaultOTLPTracesEndpoint
+		}
+		return newTracer(serviceName, &otlpExporter{
+			serviceName: serviceName,
+			endpoint:    endpoint,
+			headers:     parseOTLPHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")),
+			client:      &http.Client{Timeout: 10 * time.Second},
+		}), nil
+	case traceExporterStdout:
+		return newTracer(serviceName, &writerExporter{serviceName: serviceName, w: os.Stdout}), nil
+	case traceExporterFile:
+		if file == "" {
+			return nil, fmt.Errorf("--trace-file is required with the file exporter")
+		}
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		return newTracer(serviceName, &writerExporter{serviceName: serviceName, w: f}), nil
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", exporter)
+	}
+}
+
+// startRequestSpan opens the span of a request about to be forwarded and
+// returns the request with the trace context injected into params._meta. The
+// client's own params._meta.traceparent wins over the HTTP header, as it is
+// the more specific of the two.
+func (g *Gateway) startRequestSpan(msg JSONRPCMessage, clientID string, parent traceContext) (JSONRPCMessage, *span) {
+	if tc := traceContextFromParams(msg.Params); tc.valid() {
+		parent = tc
+	}
+
+	attributes := map[string]string{
+		"mcp.method.name":    msg.Method,
+		"jsonrpc.request.id": string(msg.ID),
+		"mcp.session.id":     clientID,
+	}
+	name := msg.Method
+	if tool := toolName(msg); tool != "" {
+		attributes["gen_ai.tool.name"] = tool
+		name += " " + tool
+	}
+
+	s := g.tracer.start(parent, name, attributes)
+	if s != nil {
+		msg.Params = injectTraceContext(msg.Params, s.context())
+	} else if parent.valid() {
+		// Not tracing ourselves, but the child can still join the caller's trace
+		msg.Params = injectTraceContext(msg.Params, parent)
+	}
+	return msg, s
+}
+
+// clientTraceContext returns the trace context a WebSocket client connected
+// with, which parents the requests it sends
+func (g *Gateway) clientTraceContext(clientID string) traceContext {
+	g.clientsMu.RLock()
+	defer g.clientsMu.RUnlock()
+	if client, ok := g.clients[clientID]; ok {
+		return client.TraceContext
+	}
+	return traceContext{}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []*span
+}
+
+func (e *recordingExporter) Export(spans []*span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tc, ok := parseTraceparent(testTraceparent)
+	if !ok {
+		t.Fatal("valid traceparent rejected")
+	}
+	if got := tc.traceparent(); got != testTraceparent {
+		t.Fatalf("traceparent round trip = %s, want %s", got, testTraceparent)
+	}
+
+	for _, value := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-xyz92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		if _, ok := parseTraceparent(value); ok {
+			t.Errorf("parseTraceparent(%q) accepted", value)
+		}
+	}
+}
+
+func TestInjectTraceContextKeepsExistingMeta(t *testing.T) {
+	tc, _ := parseTraceparent(testTraceparent)
+	params := injectTraceContext(json.RawMessage(`{"name":"echo","_meta":{"progressToken":7}}`), tc)
+
+	var decoded struct {
+		Name string `json:"name"`
+		Meta struct {
+			ProgressToken int    `json:"progressToken"`
+			Traceparent   string `json:"traceparent"`
+		} `json:"_meta"`
+	}
+	if err := json.Unmarshal(params, &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if decoded.Name != "echo" || decoded.Meta.ProgressToken != 7 || decoded.Meta.Traceparent != testTraceparent {
+		t.Fatalf("params = %s", params)
+	}
+
+	if got := injectTraceContext(nil, tc); !strings.Contains(string(got), testTraceparent) {
+		t.Fatalf("params without fields = %s", got)
+	}
+	if got := injectTraceContext(json.RawMessage(`[1,2]`), tc); string(got) != `[1,2]` {
+		t.Fatalf("array params changed to %s", got)
+	}
+}
+
+func TestRequestSpanContinuesIncomingTrace(t *testing.T) {
+	exporter := &recordingExporter{}
+	forwarded := make(chan string, 10)
+	g := newTestGateway(t, func(msg JSONRPCMessage) []string {
+		forwarded <- traceContextFromParams(msg.Params).traceparent()
+		return echoReply(msg)
+	})
+	g.tracer = newTracer("test", exporter)
+
+	req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"echo"}}`))
+	req.Header.Set("traceparent", testTraceparent)
+	rec := httptest.NewRecorder()
+	g.HandleHTTPMessage(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	g.tracer.Close()
+
+	if len(exporter.spans) != 1 {
+		t.Fatalf("exported %d spans, want 1", len(exporter.spans))
+	}
+	s := exporter.spans[0]
+	if got := hex.EncodeToString(s.TraceID[:]); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("trace ID = %s, want the incoming trace", got)
+	}
+	if got := hex.EncodeToString(s.ParentSpanID[:]); got != "00f067aa0ba902b7" {
+		t.Fatalf("parent span ID = %s, want the incoming span", got)
+	}
+	if s.Name != "tools/call echo" || s.Attributes["gen_ai.tool.name"] != "echo" || s.Attributes["mcp.gateway.outcome"] != outcomeSuccess || s.Error {
+		t.Fatalf("span = %+v", s)
+	}
+
+	// The child continues the trace under the gateway's span
+	if got, want := <-forwarded, s.context().traceparent(); got != want {
+		t.Fatalf("forwarded traceparent = %s, want %s", got, want)
+	}
+}
+
+func TestTraceContextPropagatedWithoutTracer(t *testing.T) {
+	forwarded := make(chan string, 10)
+	g := newTestGateway(t, func(msg JSONRPCMessage) []string {
+		forwarded <- traceContextFromParams(msg.Params).traceparent()
+		return echoReply(msg)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`))
+	req.Header.Set("traceparent", testTraceparent)
+	g.HandleHTTPMessage(httptest.NewRecorder(), req)
+
+	if got := <-forwarded; got != testTraceparent {
+		t.Fatalf("forwarded traceparent = %s, want %s", got, testTraceparent)
+	}
+}
+
+func TestOTLPExporterPostsJSON(t *testing.T) {
+	var body []byte
+	var contentType, auth string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		contentType = r.Header.Get("Content-Type")
+		auth = r.Header.Get("Authorization")
+	}))
+	defer collector.Close()
+
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "Authorization=Bearer abc")
+	tr, err := newTracerFromConfig(traceExporterOTLP, collector.URL+"/v1/traces", "")
+	if err != nil {
+		t.Fatalf("newTracerFromConfig: %v", err)
+	}
+	tc, _ := parseTraceparent(testTraceparent)
+	tr.finish(tr.start(tc, "tools/list", map[string]string{"mcp.method.name": "tools/list"}), outcomeTimeout)
+	tr.Close()
+
+	if contentType != "application/json" || auth != "Bearer abc" {
+		t.Fatalf("Content-Type = %q, Authorization = %q", contentType, auth)
+	}
+	var request struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID      string `json:"traceId"`
+					ParentSpanID string `json:"parentSpanId"`
+					Name         string `json:"name"`
+					Status       struct {
+						Code int `json:"code"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		t.Fatalf("unmarshal %s: %v", body, err)
+	}
+	spans := request.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 1 || spans[0].TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || spans[0].ParentSpanID != "00f067aa0ba902b7" || spans[0].Status.Code != 2 {
+		t.Fatalf("exported %s", body)
+	}
+}
+
+func TestNewTracerFromConfigRejectsUnknownExporter(t *testing.T) {
+	if _, err := newTracerFromConfig("jaeger", "", ""); err == nil {
+		t.Fatal("unknown exporter accepted")
+	}
+	if _, err := newTracerFromConfig(traceExporterFile, "", ""); err == nil {
+		t.Fatal("file exporter accepted without a file")
+	}
+	if tr, err := newTracerFromConfig(traceExporterNone, "", ""); err != nil || tr != nil {
+		t.Fatalf("none exporter = %v, %v", tr, err)
+	}
+}
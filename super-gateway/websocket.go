@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultWSPingInterval = 30 * time.Second
+	defaultWSPongTimeout  = 10 * time.Second
+)
+
+// wsConfig controls heartbeats, limits and the origin policy of WebSocket
+// connections
+type wsConfig struct {
+	// PingInterval is how often the gateway pings each client, 0 to disable
+	// heartbeats and read deadlines
+	PingInterval time.Duration
+	// PongTimeout is how long a client may take to answer a ping, and how
+	// long a write may block, before the connection is considered dead
+	PongTimeout time.Duration
+	// MaxMessageSize is the largest message accepted from a client
+	MaxMessageSize int64
+	// AllowedOrigins are glob patterns the Origin header of browser clients
+	// must match; empty allows every origin
+	AllowedOrigins []string
+}
+
+// parseAllowedOrigins splits a comma-separated list of origin patterns such
+// as https://app.example.com or https://*.example.com
+func parseAllowedOrigins(value string) ([]string, error) {
+	var origins []string
+	for _, origin := range strings.Split(value, ",") {
+		origin = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(origin), "/"))
+		if origin == "" {
+			continue
+		}
+		if _, err := path.Match(origin, ""); err != nil {
+			return nil, fmt.Errorf("invalid origin pattern %q: %w", origin, err)
+		}
+		origins = append(origins, origin)
+	}
+	return origins, nil
+}
+
+// checkOrigin reports whether a WebSocket upgrade request may proceed.
+// Requests without an Origin header come from non-browser clients, which the
+// same-origin policy does not apply to.
+func (c wsConfig) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(c.AllowedOrigins) == 0 || origin == "" {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	for _, pattern := range c.AllowedOrigins {
+		if matched, _ := path.Match(pattern, origin); matched {
+			return true
+		}
+	}
+	log.Printf("Rejecting WebSocket connection from origin %s", origin)
+	return false
+}
+
+// readDeadline is the time by which the next message or pong must arrive
+func (c wsConfig) readDeadline() time.Time {
+	if c.PingInterval <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(c.PingInterval + c.PongTimeout)
+}
+
+// writeDeadline is the time by which a write must complete
+func (c wsConfig) writeDeadline() time.Time {
+	if c.PongTimeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(c.PongTimeout)
+}
+
+// closeWebSocket sends a close frame with code and reason to a WebSocket
+// client and drops it
+func (g *Gateway) closeWebSocket(client *Client, code int, reason string) {
+	message := websocket.FormatCloseMessage(code, reason)
+	if err := client.Conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second)); err != nil && err != websocket.ErrCloseSent {
+		log.Printf("Failed to send close frame to client %s: %v", client.ID, err)
+	}
+	g.removeClient(client)
+}
+
+// closeWebSocketClients closes every WebSocket connection with code and
+// reason, e.g. so clients reconnect and initialize again after the shared
+// MCP server restarted
+func (g *Gateway) closeWebSocketClients(code int, reason string) {
+	g.clientsMu.RLock()
+	clients := make([]*Client, 0, len(g.clients))
+	for _, client := range g.clients {
+		clients = append(clients, client)
+	}
+	g.clientsMu.RUnlock()
+
+	for _, client := range clients {
+		g.closeWebSocket(client, code, reason)
+	}
+	if len(clients) > 0 {
+		log.Printf("Closed %d WebSocket connection(s): %s", len(clients), reason)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func dialWebSocket(t *testing.T, url string, header http.Header) *websocket.Conn {
+	t.Helper()
+	conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		t.Fatalf("dial: %v (status %d)", err, status)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// waitForClients waits until the gateway has n WebSocket clients
+func waitForClients(t *testing.T, g *Gateway, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		g.clientsMu.RLock()
+		count := len(g.clients)
+		g.clientsMu.RUnlock()
+		if count == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d WebSocket clients, want %d", count, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebSocketOriginAllowlist(t *testing.T) {
+	g := newTestGateway(t, echoReply)
+	g.ws.AllowedOrigins = []string{"https://*.example.com"}
+	server := httptest.NewServer(http.HandlerFunc(g.HandleWebSocket))
+	t.Cleanup(server.Close)
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	dialWebSocket(t, url, http.Header{"Origin": {"https://app.example.com"}})
+	dialWebSocket(t, url, nil)
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://evil.test"}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("dial from disallowed origin: err = %v, want 403", err)
+	}
+}
+
+func TestWebSocketDropsUnresponsivePeer(t *testing.T) {
+	g := newTestGateway(t, echoReply)
+	g.ws.PingInterval = 20 * time.Millisecond
+	g.ws.PongTimeout = 20 * time.Millisecond
+	server := httptest.NewServer(http.HandlerFunc(g.HandleWebSocket))
+	t.Cleanup(server.Close)
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	// A peer that never reads never answers pings
+	dialWebSocket(t, url, nil)
+	waitForClients(t, g, 1)
+	waitForClients(t, g, 0)
+}
+
+func TestWebSocketKeepsRespondingPeer(t *testing.T) {
+	g := newTestGateway(t, echoReply)
+	g.ws.PingInterval = 20 * time.Millisecond
+	g.ws.PongTimeout = 20 * time.Millisecond
+	server := httptest.NewServer(http.HandlerFunc(g.HandleWebSocket))
+	t.Cleanup(server.Close)
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conn := dialWebSocket(t, url, nil)
+	go func() {
+		// Reading answers pings
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	waitForClients(t, g, 1)
+	time.Sleep(200 * time.Millisecond)
+	waitForClients(t, g, 1)
+}
+
+func TestWebSocketRejectsOversizedMessage(t *testing.T) {
+	g := newTestGateway(t, echoReply)
+	g.ws.MaxMessageSize = 32
+	server := httptest.NewServer(http.HandlerFunc(g.HandleWebSocket))
+	t.Cleanup(server.Close)
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conn := dialWebSocket(t, url, nil)
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Fatalf("read after oversized message: %v, want close 1009", err)
+	}
+}
+
+func TestWebSocketClosedWithRestartCode(t *testing.T) {
+	g := newTestGateway(t, echoReply)
+	server := httptest.NewServer(http.HandlerFunc(g.HandleWebSocket))
+	t.Cleanup(server.Close)
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conn := dialWebSocket(t, url, nil)
+	waitForClients(t, g, 1)
+	g.closeWebSocketClients(websocket.CloseServiceRestart, "MCP server restarting")
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseServiceRestart) {
+		t.Fatalf("read after restart: %v, want close 1012", err)
+	}
+	waitForClients(t, g, 0)
+}
+
+func TestParseAllowedOrigins(t *testing.T) {
+	origins, err := parseAllowedOrigins(" https://App.example.com/ ,https://*.example.org")
+	if err != nil || strings.Join(origins, ",") != "https://app.example.com,https://*.example.org" {
+		t.Fatalf("parseAllowedOrigins = %v, %v", origins, err)
+	}
+	if _, err := parseAllowedOrigins("https://[example.com"); err == nil {
+		t.Fatal("parseAllowedOrigins accepted an invalid pattern")
+	}
+}